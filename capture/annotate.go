@@ -0,0 +1,100 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Annotation is a change to the user metadata of a capture. Nil Note and
+// Starred leave those fields as they are.
+type Annotation struct {
+	AddTags    []string `json:"add_tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+	Note       *string  `json:"note,omitempty"`
+	Starred    *bool    `json:"starred,omitempty"`
+}
+
+// HasTag reports whether c is tagged with tag.
+func (c *Capture) HasTag(tag string) bool {
+	return slices.Contains(c.Tags, tag)
+}
+
+// ValidTag reports why tag cannot be used, or nil if it can.
+func ValidTag(tag string) error {
+	if tag == "" || strings.ContainsAny(tag, " \t\r\n,") {
+		return fmt.Errorf("invalid tag %q: tags must be non-empty and contain no spaces or commas", tag)
+	}
+	return nil
+}
+
+func (a Annotation) apply(c *Capture) {
+	tags := slices.Clone(c.Tags)
+	for _, t := range a.AddTags {
+		if !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	tags = slices.DeleteFunc(tags, func(t string) bool { return slices.Contains(a.RemoveTags, t) })
+	if len(tags) == 0 {
+		tags = nil
+	}
+	c.Tags = tags
+	if a.Note != nil {
+		c.Note = strings.TrimSpace(*a.Note)
+	}
+	if a.Starred != nil {
+		c.Starred = *a.Starred
+	}
+}
+
+// Annotate applies a to the capture with the given ID, saves it and
+// returns the updated capture. The stored capture is changed in one step,
+// so concurrent annotations from other processes are not lost, and the
+// copy kept in memory takes its metadata from it.
+func (s *Store) Annotate(id string, a Annotation) (*Capture, error) {
+	for _, t := range a.AddTags {
+		if err := ValidTag(t); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	var stored *Capture
+	if s.backend != nil {
+		var err error
+		if stored, err = s.backend.Annotate(id, a); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	var updated *Capture
+	for i, c := range s.captures {
+		if c.ID == id {
+			cp := *c
+			if stored != nil {
+				cp.Tags, cp.Note, cp.Starred = stored.Tags, stored.Note, stored.Starred
+			} else {
+				a.apply(&cp)
+			}
+			s.captures[i] = &cp
+			updated = &cp
+			break
+		}
+	}
+	s.mu.Unlock()
+	if stored != nil {
+		hydrated := s.hydrate(stored)
+		if err := s.index.refresh(s.searchable(hydrated)); err != nil {
+			fmt.Fprintf(os.Stderr, "[snare] failed to index capture: %v\n", err)
+		}
+		if updated == nil {
+			updated = hydrated
+		}
+	}
+	if updated == nil {
+		return nil, fmt.Errorf("capture %s not found", id)
+	}
+	s.publish(Event{Type: EventUpdated, ID: id, Capture: updated})
+	return updated, nil
+}
@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BackendJSON = "json"
+	BackendBolt = "bolt"
+)
+
+// Backend persists captures for a Store. Listing methods return captures
+// newest first.
+type Backend interface {
+	Kind() string
+	Put(c *Capture) error
+	Get(id string) (*Capture, error)
+	// Annotate applies a to the stored capture with the given ID and saves
+	// it, with no other writer in between. It returns the updated capture,
+	// or nil if there is none.
+	Annotate(id string, a Annotation) (*Capture, error)
+	GetByPrefix(prefix string) (*Capture, error)
+	Delete(ids ...string) error
+	Clear() error
+	Recent(n int) ([]*Capture, error)
+	Find(f Filter) ([]*Capture, error)
+	Prune(max int) ([]*Capture, error)
+	Count() (int, error)
+}
+
+// Filter selects captures by indexed fields. Zero values match everything.
+// Host matches as a substring of the URL host, ignoring case, the others
+// match exactly.
+type Filter struct {
+	IDPrefix string
+	Method   string
+	Host     string
+	Status   int
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (f Filter) Match(c *Capture) bool {
+	if f.IDPrefix != "" && !strings.HasPrefix(c.ID, f.IDPrefix) {
+		return false
+	}
+	if f.Method != "" && c.Request.Method != f.Method {
+		return false
+	}
+	if f.Status != 0 && statusOf(c) != f.Status {
+		return false
+	}
+	if f.Host != "" && !strings.Contains(strings.ToLower(HostOf(c)), strings.ToLower(f.Host)) {
+		return false
+	}
+	if !f.Since.IsZero() && c.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && c.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+func HostOf(c *Capture) string {
+	u, err := url.Parse(c.Request.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func statusOf(c *Capture) int {
+	if c.Response == nil {
+		return 0
+	}
+	return c.Response.StatusCode
+}
+
+func OpenBackend(dir, kind string) (Backend, error) {
+	if kind == "" {
+		kind = DetectBackend(dir)
+	}
+	switch kind {
+	case BackendJSON:
+		return &jsonBackend{dir: dir}, nil
+	case BackendBolt:
+		return &feedBackend{boltBackend: &boltBackend{path: filepath.Join(dir, BoltFile)}, dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q (use json or bolt)", kind)
+	}
+}
+
+// DetectBackend reports the backend already in use in dir: bolt when the
+// database file exists, json otherwise.
+func DetectBackend(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, BoltFile)); err == nil {
+		return BackendBolt
+	}
+	return BackendJSON
+}
+
+// Migrate copies every capture in dir into a backend of kind to and removes
+// the source data once the copy succeeds.
+func Migrate(dir, to string) (int, error) {
+	from := DetectBackend(dir)
+	if from == to {
+		return 0, fmt.Errorf("store is already using the %s backend", to)
+	}
+	if feedListening(dir) {
+		return 0, fmt.Errorf("the store is in use by snare serve; stop it before migrating")
+	}
+	src, err := OpenBackend(dir, from)
+	if err != nil {
+		return 0, err
+	}
+	dst, err := OpenBackend(dir, to)
+	if err != nil {
+		return 0, err
+	}
+	all, err := src.Find(Filter{})
+	if err != nil {
+		return 0, err
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		if err := dst.Put(all[i]); err != nil {
+			return 0, fmt.Errorf("writing capture %s: %w", all[i].ID, err)
+		}
+	}
+	if err := src.Clear(); err != nil {
+		return len(all), err
+	}
+	if from == BackendBolt {
+		_ = os.Remove(filepath.Join(dir, BoltFile))
+	}
+	return len(all), nil
+}
+
+func takeN(out []*Capture, n int) []*Capture {
+	if n > 0 && len(out) > n {
+		return out[:n]
+	}
+	return out
+}
@@ -0,0 +1,485 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const BoltFile = "captures.db"
+
+var (
+	bktCaptures = []byte("captures")
+	bktTime     = []byte("time")
+	bktHost     = []byte("host")
+	bktMethod   = []byte("method")
+	bktStatus   = []byte("status")
+	bktStarred  = []byte("starred")
+	bktMeta     = []byte("meta")
+	keyCount    = []byte("count")
+)
+
+// boltHold is how long a backend that is not serving the feed keeps the
+// database open once it has opened it. Reusing the handle saves an open,
+// lock, and mmap per operation; letting it go lets another process take
+// the file lock in between.
+const boltHold = 500 * time.Millisecond
+
+// boltBackend keeps captures in a single bbolt file with secondary indexes
+// keyed by timestamp. A process serving the feed keeps the database open
+// for as long as it serves, and other processes send it their operations
+// over the feed socket (see feedBackend). Without a serving process, the
+// handle is shared by operations for up to boltHold and then closed.
+type boltBackend struct {
+	mu   sync.RWMutex
+	path string
+
+	dbMu    sync.Mutex
+	db      *bolt.DB
+	users   int
+	expired bool
+	keep    bool
+}
+
+func (b *boltBackend) Kind() string { return BackendBolt }
+
+// acquire returns the shared database handle, opening it if needed. Every
+// call must be paired with release.
+func (b *boltBackend) acquire(readOnly bool) (*bolt.DB, error) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	// A kept handle lasts for writes too, so open it read-write from the start.
+	readOnly = readOnly && !b.keep
+	if b.db != nil && !readOnly && b.db.IsReadOnly() {
+		// Writers hold b.mu exclusively, so nothing else is using it.
+		_ = b.db.Close()
+		b.db = nil
+	}
+	if b.db == nil {
+		db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: readOnly})
+		if err != nil {
+			return nil, err
+		}
+		b.db, b.expired = db, false
+		if !b.keep {
+			time.AfterFunc(boltHold, func() { b.expire(db) })
+		}
+	}
+	b.users++
+	return b.db, nil
+}
+
+func (b *boltBackend) release() {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	b.users--
+	if b.users == 0 && b.expired {
+		b.closeDB()
+	}
+}
+
+// hold keeps the database open until hold(false) when keep is set.
+func (b *boltBackend) hold(keep bool) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	b.keep = keep
+	b.expired = !keep
+	if !keep && b.users == 0 {
+		b.closeDB()
+	}
+}
+
+func (b *boltBackend) expire(db *bolt.DB) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	if b.db != db || b.keep {
+		return
+	}
+	b.expired = true
+	if b.users == 0 {
+		b.closeDB()
+	}
+}
+
+func (b *boltBackend) closeDB() {
+	if b.db != nil {
+		_ = b.db.Close()
+		b.db = nil
+	}
+}
+
+func (b *boltBackend) view(fn func(tx *bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, err := os.Stat(b.path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := b.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer b.release()
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bktCaptures) == nil {
+			return nil
+		}
+		return fn(tx)
+	})
+}
+
+func (b *boltBackend) update(fn func(tx *bolt.Tx) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	db, err := b.acquire(false)
+	if err != nil {
+		return err
+	}
+	defer b.release()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bktCaptures, bktTime, bktHost, bktMethod, bktStatus, bktStarred, bktMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+func timeKey(c *Capture) []byte {
+	k := make([]byte, 8, 8+len(c.ID))
+	binary.BigEndian.PutUint64(k, uint64(c.Timestamp.UnixNano()))
+	return append(k, c.ID...)
+}
+
+func boundKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+func indexEntries(c *Capture) [][2][]byte {
+	return [][2][]byte{
+		{bktHost, []byte(HostOf(c))},
+		{bktMethod, []byte(c.Request.Method)},
+		{bktStatus, []byte(strconv.Itoa(statusOf(c)))},
+	}
+}
+
+func getTx(tx *bolt.Tx, id []byte) *Capture {
+	data := tx.Bucket(bktCaptures).Get(id)
+	if data == nil {
+		return nil
+	}
+	var c Capture
+	if json.Unmarshal(data, &c) != nil {
+		return nil
+	}
+	return &c
+}
+
+func addCount(tx *bolt.Tx, delta int) error {
+	meta := tx.Bucket(bktMeta)
+	n := 0
+	if v := meta.Get(keyCount); v != nil {
+		n = int(binary.BigEndian.Uint64(v))
+	}
+	n += delta
+	if n < 0 {
+		n = 0
+	}
+	return meta.Put(keyCount, binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+func putTx(tx *bolt.Tx, c *Capture) error {
+	if old := getTx(tx, []byte(c.ID)); old != nil {
+		if err := unindexTx(tx, old); err != nil {
+			return err
+		}
+	} else if err := addCount(tx, 1); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bktCaptures).Put([]byte(c.ID), data); err != nil {
+		return err
+	}
+	tk := timeKey(c)
+	if err := tx.Bucket(bktTime).Put(tk, nil); err != nil {
+		return err
+	}
+	if c.Starred {
+		if err := tx.Bucket(bktStarred).Put(tk, nil); err != nil {
+			return err
+		}
+	}
+	for _, e := range indexEntries(c) {
+		sub, err := tx.Bucket(e[0]).CreateBucketIfNotExists(indexName(e[1]))
+		if err != nil {
+			return err
+		}
+		if err := sub.Put(tk, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexTx(tx *bolt.Tx, c *Capture) error {
+	tk := timeKey(c)
+	if err := tx.Bucket(bktTime).Delete(tk); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bktStarred).Delete(tk); err != nil {
+		return err
+	}
+	for _, e := range indexEntries(c) {
+		if sub := tx.Bucket(e[0]).Bucket(indexName(e[1])); sub != nil {
+			if err := sub.Delete(tk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteTx(tx *bolt.Tx, id []byte) error {
+	c := getTx(tx, id)
+	if c == nil {
+		return nil
+	}
+	if err := unindexTx(tx, c); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bktCaptures).Delete(id); err != nil {
+		return err
+	}
+	return addCount(tx, -1)
+}
+
+// indexName maps an empty index value to a placeholder, since bbolt rejects
+// zero-length bucket names.
+func indexName(v []byte) []byte {
+	if len(v) == 0 {
+		return []byte{0}
+	}
+	return v
+}
+
+func (b *boltBackend) Put(c *Capture) error {
+	return b.update(func(tx *bolt.Tx) error { return putTx(tx, c) })
+}
+
+func (b *boltBackend) Get(id string) (*Capture, error) {
+	var out *Capture
+	err := b.view(func(tx *bolt.Tx) error {
+		out = getTx(tx, []byte(id))
+		return nil
+	})
+	return out, err
+}
+
+func (b *boltBackend) Annotate(id string, a Annotation) (*Capture, error) {
+	var out *Capture
+	err := b.update(func(tx *bolt.Tx) error {
+		if out = getTx(tx, []byte(id)); out == nil {
+			return nil
+		}
+		a.apply(out)
+		return putTx(tx, out)
+	})
+	return out, err
+}
+
+func (b *boltBackend) GetByPrefix(prefix string) (*Capture, error) {
+	var out *Capture
+	err := b.view(func(tx *bolt.Tx) error {
+		p := []byte(prefix)
+		k, _ := tx.Bucket(bktCaptures).Cursor().Seek(p)
+		if k != nil && bytes.HasPrefix(k, p) {
+			out = getTx(tx, k)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (b *boltBackend) Delete(ids ...string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := deleteTx(tx, []byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBackend) Clear() error {
+	if _, err := os.Stat(b.path); os.IsNotExist(err) {
+		return nil
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bktCaptures, bktTime, bktHost, bktMethod, bktStatus, bktStarred, bktMeta} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBackend) Recent(n int) ([]*Capture, error) {
+	return b.Find(Filter{Limit: n})
+}
+
+// Find walks the most selective index available for f from newest to oldest
+// and only decodes captures whose index keys fall inside the time range.
+func (b *boltBackend) Find(f Filter) ([]*Capture, error) {
+	var out []*Capture
+	err := b.view(func(tx *bolt.Tx) error {
+		keys := candidateKeys(tx, f)
+		for _, k := range keys {
+			c := getTx(tx, k[8:])
+			if c == nil || !f.Match(c) {
+				continue
+			}
+			out = append(out, c)
+			if f.Limit > 0 && len(out) >= f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func candidateKeys(tx *bolt.Tx, f Filter) [][]byte {
+	limit := 0
+	if f.IDPrefix == "" {
+		limit = f.Limit
+	}
+	switch {
+	case f.Status != 0:
+		if f.Method != "" || f.Host != "" {
+			limit = 0
+		}
+		return scanDesc(tx.Bucket(bktStatus).Bucket([]byte(strconv.Itoa(f.Status))), f, limit)
+	case f.Method != "":
+		if f.Host != "" {
+			limit = 0
+		}
+		return scanDesc(tx.Bucket(bktMethod).Bucket(indexName([]byte(f.Method))), f, limit)
+	case f.Host != "":
+		var keys [][]byte
+		hosts := tx.Bucket(bktHost)
+		_ = hosts.ForEachBucket(func(name []byte) error {
+			if strings.Contains(strings.ToLower(string(name)), strings.ToLower(f.Host)) {
+				keys = append(keys, scanDesc(hosts.Bucket(name), f, limit)...)
+			}
+			return nil
+		})
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) > 0 })
+		return keys
+	case f.IDPrefix != "":
+		var keys [][]byte
+		p := []byte(f.IDPrefix)
+		c := tx.Bucket(bktCaptures).Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			if c := getTx(tx, k); c != nil {
+				keys = append(keys, timeKey(c))
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) > 0 })
+		return keys
+	default:
+		return scanDesc(tx.Bucket(bktTime), f, limit)
+	}
+}
+
+// scanDesc returns index keys newest first, bounded by f.Since and f.Until,
+// stopping after limit keys when limit is positive.
+func scanDesc(bkt *bolt.Bucket, f Filter, limit int) [][]byte {
+	if bkt == nil {
+		return nil
+	}
+	var lower []byte
+	if !f.Since.IsZero() {
+		lower = boundKey(f.Since)
+	}
+	c := bkt.Cursor()
+	var k []byte
+	if !f.Until.IsZero() {
+		k, _ = c.Seek(boundKey(f.Until.Add(time.Nanosecond)))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+	} else {
+		k, _ = c.Last()
+	}
+	var keys [][]byte
+	for ; k != nil; k, _ = c.Prev() {
+		if lower != nil && bytes.Compare(k, lower) < 0 {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	return keys
+}
+
+func (b *boltBackend) Prune(max int) ([]*Capture, error) {
+	n, err := b.Count()
+	if err != nil || n <= max {
+		return nil, err
+	}
+	var pruned []*Capture
+	err = b.update(func(tx *bolt.Tx) error {
+		starred := tx.Bucket(bktStarred)
+		excess := n - starred.Stats().KeyN - max
+		var ids [][]byte
+		c := tx.Bucket(bktTime).Cursor()
+		for k, _ := c.First(); k != nil && len(ids) < excess; k, _ = c.Next() {
+			if starred.Get(k) == nil {
+				ids = append(ids, append([]byte(nil), k[8:]...))
+			}
+		}
+		for _, id := range ids {
+			if c := getTx(tx, id); c != nil {
+				pruned = append(pruned, c)
+			}
+			if err := deleteTx(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return pruned, err
+}
+
+func (b *boltBackend) Count() (int, error) {
+	n := 0
+	err := b.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bktMeta).Get(keyCount); v != nil {
+			n = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,207 @@
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// feedCallTimeout bounds one backend call over the feed socket, including
+// reading back every capture for a full listing.
+const feedCallTimeout = time.Minute
+
+// feedBackend is the bolt backend of a store dir that a serving process may
+// hold open. While another process serves the store's feed, every operation
+// is sent to it over the feed socket instead of waiting for the file lock;
+// otherwise it runs on the file directly.
+type feedBackend struct {
+	*boltBackend
+	dir     string
+	serving atomic.Bool
+}
+
+// backendCall is a backend operation sent over the feed socket. Kind is the
+// backend the caller opened, so an operation never lands in a store of
+// another kind.
+type backendCall struct {
+	Op         string      `json:"op"`
+	Kind       string      `json:"kind"`
+	ID         string      `json:"id,omitempty"`
+	IDs        []string    `json:"ids,omitempty"`
+	N          int         `json:"n,omitempty"`
+	Filter     *Filter     `json:"filter,omitempty"`
+	Capture    *Capture    `json:"capture,omitempty"`
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
+
+type backendReply struct {
+	Capture  *Capture   `json:"capture,omitempty"`
+	Captures []*Capture `json:"captures,omitempty"`
+	N        int        `json:"n,omitempty"`
+	Err      string     `json:"error,omitempty"`
+}
+
+// serve marks this process as the one serving the feed, keeping the
+// database open until it stops.
+func (b *feedBackend) serve(on bool) {
+	b.serving.Store(on)
+	b.hold(on)
+}
+
+// call runs c in the process serving the feed. ok is false when there is
+// none, or it is this process, and the caller should use the file itself.
+func (b *feedBackend) call(c backendCall) (r backendReply, ok bool, err error) {
+	if b.serving.Load() {
+		return r, false, nil
+	}
+	conn, err := net.DialTimeout("unix", filepath.Join(b.dir, FeedFile), 200*time.Millisecond)
+	if err != nil {
+		return r, false, nil
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(feedCallTimeout))
+	c.Kind = b.Kind()
+	if err := json.NewEncoder(conn).Encode(feedMessage{Call: &c}); err != nil {
+		return r, true, err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var m feedMessage
+		if err := dec.Decode(&m); err != nil {
+			return r, true, fmt.Errorf("store call to snare serve: %w", err)
+		}
+		// Events published before serve read the call come first.
+		if m.Reply == nil {
+			continue
+		}
+		if m.Reply.Err != "" {
+			return *m.Reply, true, errors.New(m.Reply.Err)
+		}
+		return *m.Reply, true, nil
+	}
+}
+
+func (b *feedBackend) Put(c *Capture) error {
+	if _, ok, err := b.call(backendCall{Op: "put", Capture: c}); ok {
+		return err
+	}
+	return b.boltBackend.Put(c)
+}
+
+func (b *feedBackend) Get(id string) (*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "get", ID: id}); ok {
+		return r.Capture, err
+	}
+	return b.boltBackend.Get(id)
+}
+
+func (b *feedBackend) Annotate(id string, a Annotation) (*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "annotate", ID: id, Annotation: &a}); ok {
+		return r.Capture, err
+	}
+	return b.boltBackend.Annotate(id, a)
+}
+
+func (b *feedBackend) GetByPrefix(prefix string) (*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "prefix", ID: prefix}); ok {
+		return r.Capture, err
+	}
+	return b.boltBackend.GetByPrefix(prefix)
+}
+
+func (b *feedBackend) Delete(ids ...string) error {
+	if _, ok, err := b.call(backendCall{Op: "delete", IDs: ids}); ok {
+		return err
+	}
+	return b.boltBackend.Delete(ids...)
+}
+
+func (b *feedBackend) Clear() error {
+	if _, ok, err := b.call(backendCall{Op: "clear"}); ok {
+		return err
+	}
+	return b.boltBackend.Clear()
+}
+
+func (b *feedBackend) Recent(n int) ([]*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "recent", N: n}); ok {
+		return r.Captures, err
+	}
+	return b.boltBackend.Recent(n)
+}
+
+func (b *feedBackend) Find(f Filter) ([]*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "find", Filter: &f}); ok {
+		return r.Captures, err
+	}
+	return b.boltBackend.Find(f)
+}
+
+func (b *feedBackend) Prune(max int) ([]*Capture, error) {
+	if r, ok, err := b.call(backendCall{Op: "prune", N: max}); ok {
+		return r.Captures, err
+	}
+	return b.boltBackend.Prune(max)
+}
+
+func (b *feedBackend) Count() (int, error) {
+	if r, ok, err := b.call(backendCall{Op: "count"}); ok {
+		return r.N, err
+	}
+	return b.boltBackend.Count()
+}
+
+// answer runs a backend call another process sent over the feed socket.
+// The backend is used as is: the caller keeps its own blob references,
+// search index and events, as it would running the call itself.
+func (s *Store) answer(c *backendCall) *backendReply {
+	r := &backendReply{}
+	if s.backend == nil || s.backend.Kind() != c.Kind {
+		r.Err = fmt.Sprintf("the store is in use by snare serve with the %s backend", s.BackendKind())
+		return r
+	}
+	var err error
+	switch c.Op {
+	case "put":
+		if c.Capture == nil {
+			err = errors.New("no capture to put")
+			break
+		}
+		err = s.backend.Put(c.Capture)
+	case "get":
+		r.Capture, err = s.backend.Get(c.ID)
+	case "annotate":
+		if c.Annotation == nil {
+			err = errors.New("no annotation")
+			break
+		}
+		r.Capture, err = s.backend.Annotate(c.ID, *c.Annotation)
+	case "prefix":
+		r.Capture, err = s.backend.GetByPrefix(c.ID)
+	case "delete":
+		err = s.backend.Delete(c.IDs...)
+	case "clear":
+		err = s.backend.Clear()
+	case "recent":
+		r.Captures, err = s.backend.Recent(c.N)
+	case "find":
+		if c.Filter == nil {
+			c.Filter = &Filter{}
+		}
+		r.Captures, err = s.backend.Find(*c.Filter)
+	case "prune":
+		r.Captures, err = s.backend.Prune(c.N)
+	case "count":
+		r.N, err = s.backend.Count()
+	default:
+		err = fmt.Errorf("unknown store operation %q", c.Op)
+	}
+	if err != nil {
+		r.Err = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,220 @@
+package capture
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/muxover/snare/v2/internal/lockfile"
+)
+
+type jsonBackend struct {
+	dir string
+}
+
+func (b *jsonBackend) Kind() string { return BackendJSON }
+
+// lockName is the file Annotate locks so that processes sharing the store
+// take turns rewriting a capture.
+const lockName = "store.lock"
+
+// starSuffix marks a starred capture with an empty <id>.star file next to
+// its JSON, so Prune can skip it without reading every capture.
+const starSuffix = ".star"
+
+// Put writes c. Rewriting an existing capture keeps its modification time,
+// which orders Recent and Prune.
+func (b *jsonBackend) Put(c *Capture) error {
+	f := filepath.Join(b.dir, c.ID+".json")
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	old, statErr := os.Stat(f)
+	if err := os.WriteFile(f, data, 0600); err != nil {
+		return err
+	}
+	if statErr == nil {
+		_ = os.Chtimes(f, old.ModTime(), old.ModTime())
+	}
+	star := filepath.Join(b.dir, c.ID+starSuffix)
+	if c.Starred {
+		return os.WriteFile(star, nil, 0600)
+	}
+	if err := os.Remove(star); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *jsonBackend) Get(id string) (*Capture, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Capture
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (b *jsonBackend) Annotate(id string, a Annotation) (*Capture, error) {
+	unlock, err := lockfile.Lock(filepath.Join(b.dir, lockName))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	c, err := b.Get(id)
+	if c == nil || err != nil {
+		return nil, err
+	}
+	a.apply(c)
+	return c, b.Put(c)
+}
+
+func (b *jsonBackend) GetByPrefix(prefix string) (*Capture, error) {
+	if c, err := b.Get(prefix); c != nil || err != nil {
+		return c, err
+	}
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, nil
+	}
+	for _, e := range entries {
+		id, ok := jsonCaptureID(e)
+		if ok && strings.HasPrefix(id, prefix) {
+			return b.Get(id)
+		}
+	}
+	return nil, nil
+}
+
+func (b *jsonBackend) Delete(ids ...string) error {
+	for _, id := range ids {
+		err := os.Remove(filepath.Join(b.dir, id+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_ = os.Remove(filepath.Join(b.dir, id+starSuffix))
+	}
+	return nil
+}
+
+func (b *jsonBackend) Clear() error {
+	return filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(path); ext == ".json" || ext == starSuffix {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (b *jsonBackend) files() []os.FileInfo {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if _, ok := jsonCaptureID(e); !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	return files
+}
+
+func (b *jsonBackend) Recent(n int) ([]*Capture, error) {
+	files := b.files()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	if n > 0 && len(files) > n {
+		files = files[:n]
+	}
+	var out []*Capture
+	for _, f := range files {
+		c, _ := b.Get(strings.TrimSuffix(f.Name(), ".json"))
+		if c != nil {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (b *jsonBackend) Find(f Filter) ([]*Capture, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, nil
+	}
+	var out []*Capture
+	for _, e := range entries {
+		id, ok := jsonCaptureID(e)
+		if !ok || (f.IDPrefix != "" && !strings.HasPrefix(id, f.IDPrefix)) {
+			continue
+		}
+		c, _ := b.Get(id)
+		if c != nil && f.Match(c) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Timestamp.After(out[j].Timestamp)
+	})
+	return takeN(out, f.Limit), nil
+}
+
+func (b *jsonBackend) Prune(max int) ([]*Capture, error) {
+	files := b.files()
+	if len(files) <= max {
+		return nil, nil
+	}
+	starred := make(map[string]bool)
+	entries, _ := os.ReadDir(b.dir)
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), starSuffix); ok {
+			starred[id] = true
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	var pruned []*Capture
+	excess := len(files) - len(starred) - max
+	for i := 0; i < len(files) && excess > 0; i++ {
+		id := strings.TrimSuffix(files[i].Name(), ".json")
+		if starred[id] {
+			continue
+		}
+		if c, _ := b.Get(id); c != nil {
+			pruned = append(pruned, c)
+		}
+		_ = os.Remove(filepath.Join(b.dir, files[i].Name()))
+		excess--
+	}
+	return pruned, nil
+}
+
+func (b *jsonBackend) Count() (int, error) {
+	return len(b.files()), nil
+}
+
+func jsonCaptureID(e os.DirEntry) (string, bool) {
+	if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+		return "", false
+	}
+	return strings.TrimSuffix(e.Name(), ".json"), true
+}
@@ -0,0 +1,270 @@
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const BlobDir = "blobs"
+
+// BlobRef points at a body stored outside the capture record, in a
+// content-addressed file under the store's blob directory. Encoding is the
+// Content-Encoding of the stored bytes, which are kept as they crossed the wire.
+// Dedup marks bodies the store moved out on its own; they are read back in
+// transparently and never reach callers.
+type BlobRef struct {
+	Hash      string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Dedup     bool   `json:"dedup,omitempty"`
+
+	// tmp is where a spilled body waits until the capture holding it is
+	// stored and takes a reference on it.
+	tmp string
+}
+
+// validBlobHash reports whether hash is a lowercase hex SHA-256, the only
+// form a blob file name takes. Refs come from bundles too, so anything else
+// is rejected before it is joined into a path.
+func validBlobHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) blobPath(hash string) (string, error) {
+	if !validBlobHash(hash) {
+		return "", fmt.Errorf("invalid blob hash %q", hash)
+	}
+	return filepath.Join(s.dir, BlobDir, hash[:2], hash), nil
+}
+
+// Spill collects a body as it streams past. Bodies up to threshold stay in
+// memory; larger ones are moved to a blob file. Writes never fail, so a
+// Spill can sit behind an io.TeeReader without disturbing the relay.
+type Spill struct {
+	mu        sync.Mutex
+	dir       string
+	threshold int64
+	max       int64
+	n         int64
+	buf       bytes.Buffer
+	f         *os.File
+	h         hash.Hash
+	err       error
+	done      bool
+	truncated bool
+}
+
+// NewSpill starts collecting a body for this store. max caps how many bytes
+// are kept (0 = no limit). Stores without a directory never spill.
+func (s *Store) NewSpill(threshold, max int64) *Spill {
+	sp := &Spill{threshold: threshold, max: max}
+	if s != nil && s.dir != "" && threshold > 0 {
+		sp.dir = filepath.Join(s.dir, BlobDir)
+	}
+	return sp
+}
+
+func (sp *Spill) Write(p []byte) (int, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	n := len(p)
+	if sp.done || sp.err != nil {
+		return n, nil
+	}
+	if sp.max > 0 && sp.n+int64(len(p)) > sp.max {
+		p = p[:sp.max-sp.n]
+		sp.truncated = true
+	}
+	sp.n += int64(len(p))
+	if sp.f == nil && sp.dir != "" && int64(sp.buf.Len()+len(p)) > sp.threshold {
+		sp.startFile()
+	}
+	if sp.f != nil {
+		sp.h.Write(p)
+		if _, err := sp.f.Write(p); err != nil {
+			sp.err = err
+		}
+		return n, nil
+	}
+	sp.buf.Write(p)
+	return n, nil
+}
+
+func (sp *Spill) startFile() {
+	if err := os.MkdirAll(sp.dir, 0700); err != nil {
+		sp.err = err
+		return
+	}
+	f, err := os.CreateTemp(sp.dir, "spill-*")
+	if err != nil {
+		sp.err = err
+		return
+	}
+	sp.f = f
+	sp.h = sha256.New()
+	sp.h.Write(sp.buf.Bytes())
+	if _, err := f.Write(sp.buf.Bytes()); err != nil {
+		sp.err = err
+	}
+	sp.buf.Reset()
+}
+
+// Finish stops collecting. It returns the body bytes when they stayed in
+// memory, or a reference to the blob they were written to. The blob is
+// moved into place when the capture holding the reference is stored.
+func (sp *Spill) Finish(encoding string) ([]byte, *BlobRef, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.done = true
+	if sp.f == nil {
+		return sp.buf.Bytes(), nil, sp.err
+	}
+	tmp := sp.f.Name()
+	closeErr := sp.f.Close()
+	if sp.err == nil {
+		sp.err = closeErr
+	}
+	if sp.err != nil {
+		_ = os.Remove(tmp)
+		return nil, nil, sp.err
+	}
+	sum := hex.EncodeToString(sp.h.Sum(nil))
+	return nil, &BlobRef{Hash: sum, Size: sp.n, Encoding: encoding, Truncated: sp.truncated, tmp: tmp}, nil
+}
+
+// OpenBlob opens a blob body, undoing its Content-Encoding.
+func (s *Store) OpenBlob(ref *BlobRef) (io.ReadCloser, error) {
+	path, err := s.blobPath(ref.Hash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := DecodeReader(f, ref.Encoding)
+	if err != nil {
+		_, _ = f.Seek(0, io.SeekStart)
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// WithBodies returns c with any blob bodies read back in, so it can be
+// shown, exported or replayed like a capture whose bodies were stored
+// inline. c itself is left untouched; unreadable blobs leave the body empty.
+func (s *Store) WithBodies(c *Capture) *Capture {
+	out, _ := s.ReadBodies(c)
+	return out
+}
+
+// ReadBodies is WithBodies for callers that must not lose a body: it also
+// returns the first blob that could not be read. The returned capture keeps
+// a reference for each such blob.
+func (s *Store) ReadBodies(c *Capture) (*Capture, error) {
+	return s.readBodies(c, 0)
+}
+
+// SearchBodyLimit is how much of a blob body SearchBodies reads back in.
+const SearchBodyLimit = 8 << 20
+
+// SearchBodies returns c with up to SearchBodyLimit of each blob body read
+// back in, for body queries and regular expression searches.
+func (s *Store) SearchBodies(c *Capture) *Capture {
+	return s.PeekBodies(c, SearchBodyLimit)
+}
+
+// PeekBodies is WithBodies for searching: each blob body is read back only
+// up to limit bytes, so a body too large to hold whole can still be matched
+// by its start.
+func (s *Store) PeekBodies(c *Capture, limit int64) *Capture {
+	out, _ := s.readBodies(c, limit)
+	return out
+}
+
+func (s *Store) readBodies(c *Capture, limit int64) (*Capture, error) {
+	if c == nil || (c.Request.BodyBlob == nil && (c.Response == nil || c.Response.BodyBlob == nil)) {
+		return c, nil
+	}
+	out := *c
+	out.Request.Headers = c.Request.Headers.Clone()
+	err := s.inlineBody(&out.Request.BodyBlob, &out.Request.Body, out.Request.Headers, limit)
+	if c.Response != nil {
+		resp := *c.Response
+		resp.Headers = c.Response.Headers.Clone()
+		if rerr := s.inlineBody(&resp.BodyBlob, &resp.Body, resp.Headers, limit); err == nil {
+			err = rerr
+		}
+		out.Response = &resp
+	}
+	return &out, err
+}
+
+// inlineBody reads the blob at *ref into *body, at most limit bytes of it
+// when limit is positive.
+func (s *Store) inlineBody(ref **BlobRef, body *BodyBytes, headers http.Header, limit int64) error {
+	if *ref == nil || len(*body) > 0 {
+		return nil
+	}
+	rc, err := s.OpenBlob(*ref)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if limit > 0 {
+		r = io.LimitReader(rc, limit)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if (*ref).Encoding != "" && headers != nil {
+		headers.Del("Content-Encoding")
+		headers.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	*body, *ref = data, nil
+	return nil
+}
+
+// DecodeReader wraps r to undo a gzip, deflate or br Content-Encoding.
+// Other encodings are returned unchanged.
+func DecodeReader(r io.Reader, contentEncoding string) (io.Reader, error) {
+	enc := strings.TrimSpace(strings.Split(contentEncoding, ",")[0])
+	switch strings.ToLower(enc) {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return flate.NewReader(r), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	default:
+		return r, nil
+	}
+}
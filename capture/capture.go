@@ -41,11 +41,100 @@ type Capture struct {
 	Request   RequestSnapshot   `json:"request"`
 	Response  *ResponseSnapshot `json:"response,omitempty"`
 	Duration  time.Duration     `json:"duration_ns,omitempty"`
+	Timings   *Timings          `json:"timings,omitempty"`
+	TLS       *TLSInfo          `json:"tls,omitempty"`
+	Conn      *ConnInfo         `json:"conn,omitempty"`
 	Error     string            `json:"error,omitempty"`
 	WebSocket *WebSocketCapture `json:"websocket,omitempty"`
 	GRPC      *GRPCCapture      `json:"grpc,omitempty"`
 	SSE       *SSECapture       `json:"sse,omitempty"`
 	GraphQL   *GraphQLCapture   `json:"graphql,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Note      string            `json:"note,omitempty"`
+	Starred   bool              `json:"starred,omitempty"`
+	// Mock is the ID of the mock rule that answered the request instead of
+	// the origin.
+	Mock string `json:"mock,omitempty"`
+}
+
+// Timings breaks the upstream exchange of a capture into phases. Phases that
+// did not happen, such as DNS and connect on a reused connection, are zero.
+// Wait is the time to first byte after the request was sent and Receive the
+// time spent reading the response body.
+type Timings struct {
+	Blocked    time.Duration `json:"blocked_ns,omitempty"`
+	DNS        time.Duration `json:"dns_ns,omitempty"`
+	Connect    time.Duration `json:"connect_ns,omitempty"`
+	TLS        time.Duration `json:"tls_ns,omitempty"`
+	Send       time.Duration `json:"send_ns,omitempty"`
+	Wait       time.Duration `json:"wait_ns,omitempty"`
+	Receive    time.Duration `json:"receive_ns,omitempty"`
+	Reused     bool          `json:"reused,omitempty"`
+	RemoteAddr string        `json:"remote_addr,omitempty"`
+}
+
+// Phase is one named step of Timings.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Phases lists the timing phases in the order they happen.
+func (t *Timings) Phases() []Phase {
+	return []Phase{
+		{"blocked", t.Blocked},
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLS},
+		{"send", t.Send},
+		{"wait", t.Wait},
+		{"receive", t.Receive},
+	}
+}
+
+// Total is the sum of all phases.
+func (t *Timings) Total() time.Duration {
+	var d time.Duration
+	for _, p := range t.Phases() {
+		d += p.Duration
+	}
+	return d
+}
+
+// TLSInfo describes the TLS connection to the origin. Verified is false
+// when verification was skipped for the host; VerifyError is set when the
+// origin's chain was rejected, in which case the capture has no response.
+type TLSInfo struct {
+	Version     string     `json:"version,omitempty"`
+	CipherSuite string     `json:"cipher_suite,omitempty"`
+	ALPN        string     `json:"alpn,omitempty"`
+	SNI         string     `json:"sni,omitempty"`
+	Verified    bool       `json:"verified"`
+	VerifyError string     `json:"verify_error,omitempty"`
+	Chain       []CertInfo `json:"chain,omitempty"`
+	// ClientCert is the subject of the client certificate snare offers
+	// this origin, when one is configured for it.
+	ClientCert string `json:"client_cert,omitempty"`
+}
+
+// ConnInfo holds the addresses of the connections an exchange used, so it
+// can be found in a packet capture: the client's connection to snare and
+// snare's connection to the origin.
+type ConnInfo struct {
+	Client      string `json:"client,omitempty"`
+	Proxy       string `json:"proxy,omitempty"`
+	OriginLocal string `json:"origin_local,omitempty"`
+	Origin      string `json:"origin,omitempty"`
+}
+
+// CertInfo summarises one certificate of an origin's chain, leaf first.
+type CertInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA256    string    `json:"sha256"`
 }
 
 type GRPCCapture struct {
@@ -90,14 +179,16 @@ type WSFrame struct {
 }
 
 type RequestSnapshot struct {
-	Method  string      `json:"method"`
-	URL     string      `json:"url"`
-	Headers http.Header `json:"headers"`
-	Body    BodyBytes   `json:"body,omitempty"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  http.Header `json:"headers"`
+	Body     BodyBytes   `json:"body,omitempty"`
+	BodyBlob *BlobRef    `json:"body_blob,omitempty"`
 }
 
 type ResponseSnapshot struct {
 	StatusCode int         `json:"status_code"`
 	Headers    http.Header `json:"headers"`
 	Body       BodyBytes   `json:"body,omitempty"`
+	BodyBlob   *BlobRef    `json:"body_blob,omitempty"`
 }
@@ -0,0 +1,287 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// dedupMinSize is the smallest inline body moved to the shared blob area
+// when a capture is persisted. Smaller bodies cost less inline than as files.
+const dedupMinSize = 256
+
+const refsFile = "refs.db"
+
+var bktRefs = []byte("refs")
+
+// blobRefs counts how many persisted captures point at each blob, so blobs
+// can be removed once the last capture using them is deleted or pruned.
+type blobRefs struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (r *blobRefs) update(fn func(b *bolt.Bucket) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	db, err := bolt.Open(r.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bktRefs)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// holdBlobs takes a reference on every blob stored points at and, in the same
+// transaction, makes sure each blob file is in place. Holding the refs lock
+// across both keeps a concurrent release from deleting a blob between the
+// existence check and the new reference.
+func (s *Store) holdBlobs(stored *Capture, data map[string][]byte) error {
+	refs := bodyRefs(stored)
+	if len(refs) == 0 {
+		return nil
+	}
+	err := s.refs.update(func(b *bolt.Bucket) error {
+		for _, ref := range refs {
+			if err := s.placeBlob(ref, data[ref.Hash]); err != nil {
+				return err
+			}
+			n := uint64(0)
+			if v := b.Get([]byte(ref.Hash)); v != nil {
+				n = binary.BigEndian.Uint64(v)
+			}
+			if err := b.Put([]byte(ref.Hash), binary.BigEndian.AppendUint64(nil, n+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, ref := range refs {
+			if ref.tmp != "" {
+				_ = os.Remove(ref.tmp)
+				ref.tmp = ""
+			}
+		}
+	}
+	return err
+}
+
+// placeBlob makes sure the file for ref exists: an existing blob is kept,
+// otherwise a spilled body is moved in from its temp file or a deduplicated
+// one written from data.
+func (s *Store) placeBlob(ref *BlobRef, data []byte) error {
+	dst, err := s.blobPath(ref.Hash)
+	if err != nil {
+		return err
+	}
+	tmp := ref.tmp
+	ref.tmp = ""
+	if _, err := os.Stat(dst); err == nil {
+		if tmp != "" {
+			_ = os.Remove(tmp)
+		}
+		return nil
+	}
+	if tmp == "" && data == nil {
+		return fmt.Errorf("blob %s is missing", ref.Hash)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		if tmp != "" {
+			_ = os.Remove(tmp)
+		}
+		return err
+	}
+	if tmp == "" {
+		f, err := os.CreateTemp(filepath.Dir(dst), "dedup-*")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+		tmp = f.Name()
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// release drops one reference per hash and calls remove, inside the same
+// transaction, for each hash no capture references any more. Hashes without
+// a count were written before counting began and are left alone.
+func (r *blobRefs) release(hashes []string, remove func(hash string)) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	return r.update(func(b *bolt.Bucket) error {
+		for _, h := range hashes {
+			v := b.Get([]byte(h))
+			if v == nil {
+				continue
+			}
+			n := binary.BigEndian.Uint64(v)
+			if n <= 1 {
+				if err := b.Delete([]byte(h)); err != nil {
+					return err
+				}
+				remove(h)
+				continue
+			}
+			if err := b.Put([]byte(h), binary.BigEndian.AppendUint64(nil, n-1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func blobHashes(c *Capture) []string {
+	var out []string
+	for _, ref := range bodyRefs(c) {
+		out = append(out, ref.Hash)
+	}
+	return out
+}
+
+// stash returns the form of c written to the backend: inline bodies of
+// dedupMinSize or more are replaced by references to shared blobs, whose
+// contents are returned by hash for holdBlobs to write. c itself is not
+// modified.
+func (s *Store) stash(c *Capture) (*Capture, map[string][]byte) {
+	data := make(map[string][]byte)
+	out := *c
+	out.Request.Body, out.Request.BodyBlob = stashBody(c.Request.Body, c.Request.BodyBlob, data)
+	if c.Response != nil {
+		resp := *c.Response
+		resp.Body, resp.BodyBlob = stashBody(c.Response.Body, c.Response.BodyBlob, data)
+		out.Response = &resp
+	}
+	return &out, data
+}
+
+func stashBody(body BodyBytes, ref *BlobRef, data map[string][]byte) (BodyBytes, *BlobRef) {
+	if ref != nil || len(body) < dedupMinSize {
+		return body, ref
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	data[hash] = body
+	return nil, &BlobRef{Hash: hash, Size: int64(len(body)), Dedup: true}
+}
+
+// hydrate reverses stash for captures read from the backend. Spilled
+// bodies stay as references; see WithBodies.
+func (s *Store) hydrate(c *Capture) *Capture {
+	if c == nil {
+		return nil
+	}
+	deduped := func(ref *BlobRef) bool { return ref != nil && ref.Dedup }
+	if !deduped(c.Request.BodyBlob) && (c.Response == nil || !deduped(c.Response.BodyBlob)) {
+		return c
+	}
+	if deduped(c.Request.BodyBlob) {
+		if data, ok := s.blobs.load(s, c.Request.BodyBlob.Hash); ok {
+			c.Request.Body, c.Request.BodyBlob = data, nil
+		}
+	}
+	if c.Response != nil && deduped(c.Response.BodyBlob) {
+		if data, ok := s.blobs.load(s, c.Response.BodyBlob.Hash); ok {
+			c.Response.Body, c.Response.BodyBlob = data, nil
+		}
+	}
+	return c
+}
+
+func (s *Store) hydrateAll(cs []*Capture) []*Capture {
+	for i, c := range cs {
+		cs[i] = s.hydrate(c)
+	}
+	return cs
+}
+
+// releaseBlobs drops the blob references held by removed captures and
+// deletes blobs nothing points at any more.
+func (s *Store) releaseBlobs(removed []*Capture) {
+	var hashes []string
+	for _, c := range removed {
+		hashes = append(hashes, blobHashes(c)...)
+	}
+	err := s.refs.release(hashes, func(h string) {
+		if path, err := s.blobPath(h); err == nil {
+			_ = os.Remove(path)
+		}
+		s.blobs.forget(h)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] failed to release blobs: %v\n", err)
+	}
+}
+
+const blobCacheBytes = 16 << 20
+
+// blobCache keeps recently read dedup bodies, since polling traffic reads
+// the same few blobs for many captures.
+type blobCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string][]byte
+}
+
+func (bc *blobCache) load(s *Store, hash string) ([]byte, bool) {
+	bc.mu.Lock()
+	data, ok := bc.items[hash]
+	bc.mu.Unlock()
+	if ok {
+		return data, true
+	}
+	path, err := s.blobPath(hash)
+	if err != nil {
+		return nil, false
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.items == nil || bc.size+len(data) > blobCacheBytes {
+		bc.items = make(map[string][]byte)
+		bc.size = 0
+	}
+	bc.items[hash] = data
+	bc.size += len(data)
+	return data, true
+}
+
+func (bc *blobCache) forget(hash string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if data, ok := bc.items[hash]; ok {
+		bc.size -= len(data)
+		delete(bc.items, hash)
+	}
+}
@@ -0,0 +1,386 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+	EventCleared EventType = "cleared"
+)
+
+// Event describes one change to a Store. Capture is set for added and
+// updated events, ID for added, updated and deleted events.
+type Event struct {
+	Type    EventType `json:"type"`
+	ID      string    `json:"id,omitempty"`
+	Capture *Capture  `json:"capture,omitempty"`
+}
+
+// FeedFile is the Unix socket that serve listens on inside the store dir.
+const FeedFile = "feed.sock"
+
+// feedMessage is one line on the feed socket: an event, or a backend call
+// from another process and the reply to it.
+type feedMessage struct {
+	Event
+	Call  *backendCall  `json:"call,omitempty"`
+	Reply *backendReply `json:"reply,omitempty"`
+}
+
+const feedBuffer = 256
+
+type feed struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+	ln   net.Listener
+}
+
+// Subscribe returns a channel receiving every change made through this
+// Store, including changes forwarded to it over the feed socket. Events are
+// dropped for subscribers that fall more than feedBuffer events behind.
+func (s *Store) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, feedBuffer)
+	s.feed.mu.Lock()
+	if s.feed.subs == nil {
+		s.feed.subs = make(map[chan Event]struct{})
+	}
+	s.feed.subs[ch] = struct{}{}
+	s.feed.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.feed.mu.Lock()
+			delete(s.feed.subs, ch)
+			s.feed.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (s *Store) publish(ev Event) {
+	s.feed.mu.Lock()
+	for ch := range s.feed.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	serving := s.feed.ln != nil
+	s.feed.mu.Unlock()
+	if !serving && s.dir != "" {
+		forwardEvent(s.dir, ev)
+	}
+}
+
+// forwardEvent hands a change made by a CLI command to a running serve so
+// its memory and followers stay in sync. It is a no-op when nothing listens.
+func forwardEvent(dir string, ev Event) {
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, FeedFile), 200*time.Millisecond)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = json.NewEncoder(conn).Encode(ev)
+}
+
+// ServeFeed listens on the store's feed socket and streams every event to
+// connected clients as NDJSON. Events written by clients are applied to the
+// in-memory list and republished.
+func (s *Store) ServeFeed() error {
+	if s.dir == "" {
+		return nil
+	}
+	path := filepath.Join(s.dir, FeedFile)
+	if feedListening(s.dir) {
+		return &os.PathError{Op: "listen", Path: path, Err: os.ErrExist}
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	_ = os.Chmod(path, 0600)
+	s.feed.mu.Lock()
+	s.feed.ln = ln
+	s.feed.mu.Unlock()
+	if b, ok := s.backend.(*feedBackend); ok {
+		b.serve(true)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveFeedConn(conn)
+		}
+	}()
+	return nil
+}
+
+// feedListening reports whether a process is serving the feed of dir.
+func feedListening(dir string) bool {
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, FeedFile), 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// CloseFeed stops the feed listener and removes its socket.
+func (s *Store) CloseFeed() {
+	s.feed.mu.Lock()
+	ln := s.feed.ln
+	s.feed.ln = nil
+	s.feed.mu.Unlock()
+	if ln != nil {
+		ln.Close()
+		if b, ok := s.backend.(*feedBackend); ok {
+			b.serve(false)
+		}
+	}
+}
+
+// serveFeedConn streams events to a client and applies what it sends: a
+// forwarded event, or backend calls, after which the connection only
+// carries their replies.
+func (s *Store) serveFeedConn(conn net.Conn) {
+	defer conn.Close()
+	events, cancel := s.Subscribe()
+	defer cancel()
+	var mu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(v any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(v)
+	}
+	go func() {
+		for ev := range events {
+			if send(ev) != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	dec := json.NewDecoder(conn)
+	for {
+		var m feedMessage
+		if err := dec.Decode(&m); err != nil {
+			return
+		}
+		if m.Call != nil {
+			cancel()
+			if send(feedMessage{Reply: s.answer(m.Call)}) != nil {
+				return
+			}
+			continue
+		}
+		s.applyRemote(m.Event)
+	}
+}
+
+// applyRemote applies an event a CLI command forwarded. The event only
+// says what changed: the capture is read back from the backend, and a
+// delete or clear is applied only if the backend agrees, so a client
+// cannot forge captures or drop ones that are still stored.
+func (s *Store) applyRemote(ev Event) {
+	if s.backend == nil {
+		return
+	}
+	switch ev.Type {
+	case EventAdded, EventUpdated:
+		if ev.ID == "" && ev.Capture != nil {
+			ev.ID = ev.Capture.ID
+		}
+		stored, _ := s.backend.Get(ev.ID)
+		if stored == nil {
+			return
+		}
+		ev.Capture = s.hydrate(stored)
+		s.mu.Lock()
+		if ev.Type == EventAdded {
+			s.removeLocked(ev.ID)
+			s.captures = append(s.captures, ev.Capture)
+			if len(s.captures) > s.max {
+				s.captures = s.captures[len(s.captures)-s.max:]
+			}
+		} else {
+			for i, c := range s.captures {
+				if c.ID == ev.ID {
+					s.captures[i] = ev.Capture
+				}
+			}
+		}
+		s.mu.Unlock()
+	case EventDeleted:
+		if stored, _ := s.backend.Get(ev.ID); stored != nil {
+			return
+		}
+		s.mu.Lock()
+		s.removeLocked(ev.ID)
+		s.mu.Unlock()
+	case EventCleared:
+		if n, _ := s.backend.Count(); n > 0 {
+			return
+		}
+		s.mu.Lock()
+		s.captures = s.captures[:0]
+		s.mu.Unlock()
+	default:
+		return
+	}
+	s.publish(ev)
+}
+
+// Follow streams changes made by any process to the store dir. It reads the
+// feed socket of a running serve and falls back to polling the backend every
+// poll interval while no serve is listening; only additions are seen while
+// polling. Captures that existed before Follow was called are not reported.
+func (s *Store) Follow(poll time.Duration) (<-chan Event, func()) {
+	if s.dir == "" {
+		return s.Subscribe()
+	}
+	out := make(chan Event, feedBuffer)
+	done := make(chan struct{})
+	var once sync.Once
+	p := newPoller(s)
+	go func() {
+		defer close(out)
+		for {
+			if conn, err := net.DialTimeout("unix", filepath.Join(s.dir, FeedFile), 200*time.Millisecond); err == nil {
+				if !p.emit(p.poll(), out, done) || !p.readFeed(conn, out, done) {
+					return
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(poll):
+			}
+			if !p.emit(p.poll(), out, done) {
+				return
+			}
+		}
+	}()
+	return out, func() { once.Do(func() { close(done) }) }
+}
+
+// pollWindow is how far before the newest seen capture the poller looks
+// again, since a capture's timestamp is when its request started and slow
+// exchanges are saved after faster ones that started later.
+const pollWindow = time.Minute
+
+// poller tracks captures already reported to a follower so that captures
+// arriving both over the feed socket and through polling are sent once.
+type poller struct {
+	s     *Store
+	since time.Time
+	seen  map[string]time.Time
+}
+
+func newPoller(s *Store) *poller {
+	p := &poller{s: s, seen: make(map[string]time.Time)}
+	if latest := s.ListFromDisk(1); len(latest) > 0 {
+		p.since = latest[0].Timestamp
+	}
+	p.poll()
+	return p
+}
+
+func (p *poller) poll() []Event {
+	var since time.Time
+	if !p.since.IsZero() {
+		since = p.since.Add(-pollWindow)
+	}
+	found := p.s.Find(Filter{Since: since})
+	var evs []Event
+	for i := len(found) - 1; i >= 0; i-- {
+		if p.mark(found[i]) {
+			evs = append(evs, Event{Type: EventAdded, ID: found[i].ID, Capture: found[i]})
+		}
+	}
+	p.prune(since)
+	return evs
+}
+
+func (p *poller) prune(before time.Time) {
+	for id, ts := range p.seen {
+		if ts.Before(before) {
+			delete(p.seen, id)
+		}
+	}
+}
+
+// mark records c as seen and reports whether it was new.
+func (p *poller) mark(c *Capture) bool {
+	if _, ok := p.seen[c.ID]; ok {
+		return false
+	}
+	p.seen[c.ID] = c.Timestamp
+	if c.Timestamp.After(p.since) {
+		p.since = c.Timestamp
+	}
+	if len(p.seen) > 4*feedBuffer*feedBuffer {
+		p.prune(p.since.Add(-pollWindow))
+	}
+	return true
+}
+
+func (p *poller) emit(evs []Event, out chan<- Event, done <-chan struct{}) bool {
+	for _, ev := range evs {
+		select {
+		case out <- ev:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+// readFeed forwards events from a serve feed connection until it closes. It
+// returns false once the follower has been stopped.
+func (p *poller) readFeed(conn net.Conn, out chan<- Event, done <-chan struct{}) bool {
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-done:
+		case <-closed:
+		}
+		conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var ev Event
+		if json.Unmarshal(sc.Bytes(), &ev) != nil {
+			continue
+		}
+		if ev.Type == EventAdded && (ev.Capture == nil || !p.mark(ev.Capture)) {
+			continue
+		}
+		if !p.emit([]Event{ev}, out, done) {
+			return false
+		}
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
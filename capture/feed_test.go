@@ -0,0 +1,68 @@
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestFollowFeed(t *testing.T) {
+	dir := t.TempDir()
+	serve := NewStore(100, dir)
+	if err := serve.ServeFeed(); err != nil {
+		t.Fatal(err)
+	}
+	defer serve.CloseFeed()
+	serve.Add(testCapture(0, "GET", "http://a.test/", 200))
+
+	events, stop := NewStore(100, dir).Follow(time.Hour)
+	defer stop()
+	time.Sleep(100 * time.Millisecond)
+
+	serve.Add(testCapture(1, "GET", "http://a.test/", 200))
+	if ev := nextEvent(t, events); ev.Type != EventAdded || ev.ID != "id-001" {
+		t.Fatalf("got %+v", ev)
+	}
+
+	// A delete made by another process is forwarded to serve and republished.
+	if err := NewStore(100, dir).DeleteByID("id-000"); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventDeleted || ev.ID != "id-000" {
+		t.Fatalf("got %+v", ev)
+	}
+	if serve.Get("id-000") != nil {
+		t.Fatal("serve still holds deleted capture")
+	}
+
+	// A delete for a capture that is still stored is not applied.
+	forwardEvent(dir, Event{Type: EventDeleted, ID: "id-001"})
+	forwardEvent(dir, Event{Type: EventCleared})
+	time.Sleep(100 * time.Millisecond)
+	if serve.Get("id-001") == nil || len(serve.All()) != 1 {
+		t.Fatal("serve applied a delete the backend does not agree with")
+	}
+}
+
+func TestFollowPolls(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(100, dir)
+	s.Add(testCapture(0, "GET", "http://a.test/", 200))
+
+	events, stop := NewStore(100, dir).Follow(20 * time.Millisecond)
+	defer stop()
+	s.Add(testCapture(1, "GET", "http://a.test/", 200))
+	if ev := nextEvent(t, events); ev.ID != "id-001" {
+		t.Fatalf("got %+v", ev)
+	}
+}
@@ -0,0 +1,239 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Retention limits what the store keeps on disk on top of the max-captures
+// cap. Zero fields are not enforced. Starred captures and captures for which
+// Keep returns true are never pruned and do not count towards any limit.
+type Retention struct {
+	MaxAge     time.Duration
+	MaxBytes   int64
+	MaxPerHost int
+	Keep       func(*Capture) bool
+}
+
+// Enabled reports whether r asks for anything beyond the max-captures cap
+// enforced on every Add, and so needs a compactor.
+func (r Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0 || r.MaxPerHost > 0 || r.Keep != nil
+}
+
+// SetRetention replaces the store's retention policy. It takes effect on the
+// next Compact. While a Keep func is set, the max-captures cap is enforced by
+// Compact instead of on every Add, so kept captures survive it.
+func (s *Store) SetRetention(r Retention) {
+	s.mu.Lock()
+	s.retention = r
+	s.mu.Unlock()
+}
+
+// StartCompactor runs Compact every interval until the returned func is called.
+func (s *Store) StartCompactor(interval time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			if _, err := s.Compact(); err != nil {
+				fmt.Fprintf(os.Stderr, "[snare] compaction failed: %v\n", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Compact deletes persisted captures that fall outside the retention policy
+// and reports how many were removed. Captures over the age limit go first,
+// then the oldest captures of hosts over their quota, then the oldest
+// captures overall until the store fits in MaxBytes.
+func (s *Store) Compact() (int, error) {
+	if s.backend == nil {
+		return 0, nil
+	}
+	s.mu.RLock()
+	r, max := s.retention, s.max
+	s.mu.RUnlock()
+	all, err := s.backend.Find(Filter{})
+	if err != nil {
+		return 0, err
+	}
+	keep := func(c *Capture) bool { return c.Starred || (r.Keep != nil && r.Keep(c)) }
+	cutoff := time.Now().Add(-r.MaxAge)
+	drop := make(map[string]bool)
+	perHost := make(map[string]int)
+	kept := 0
+	for _, c := range all {
+		if keep(c) {
+			continue
+		}
+		host := HostOf(c)
+		switch {
+		case r.MaxAge > 0 && c.Timestamp.Before(cutoff),
+			r.MaxPerHost > 0 && perHost[host] >= r.MaxPerHost,
+			kept >= max:
+			drop[c.ID] = true
+		default:
+			perHost[host]++
+			kept++
+		}
+	}
+	if r.MaxBytes > 0 {
+		var u usage
+		for _, c := range all {
+			if !drop[c.ID] {
+				u.add(c)
+			}
+		}
+		for i := len(all) - 1; i >= 0 && u.bytes > r.MaxBytes; i-- {
+			if c := all[i]; !drop[c.ID] && !keep(c) {
+				drop[c.ID] = true
+				u.remove(c)
+			}
+		}
+	}
+	var removed []*Capture
+	var ids []string
+	for _, c := range all {
+		if drop[c.ID] {
+			removed = append(removed, c)
+			ids = append(ids, c.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := s.backend.Delete(ids...); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	for _, id := range ids {
+		s.removeLocked(id)
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		s.publish(Event{Type: EventDeleted, ID: id})
+	}
+	s.forget(removed)
+	return len(removed), nil
+}
+
+// HostUsage is the share of the store taken by one host. Bytes counts every
+// body the host's captures reference, including ones shared with other captures.
+type HostUsage struct {
+	Host     string `json:"host"`
+	Captures int    `json:"captures"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Usage summarises what the store holds on disk. Bytes is an estimate of
+// capture records plus each distinct blob counted once.
+type Usage struct {
+	Backend   string      `json:"backend"`
+	Captures  int         `json:"captures"`
+	Bytes     int64       `json:"bytes"`
+	BlobBytes int64       `json:"blob_bytes"`
+	Oldest    time.Time   `json:"oldest,omitempty"`
+	Newest    time.Time   `json:"newest,omitempty"`
+	Hosts     []HostUsage `json:"hosts"`
+}
+
+// Usage reports disk usage for the store, with hosts ordered by size.
+func (s *Store) Usage() (Usage, error) {
+	out := Usage{Backend: s.BackendKind()}
+	if s.backend == nil {
+		return out, nil
+	}
+	all, err := s.backend.Find(Filter{})
+	if err != nil {
+		return out, err
+	}
+	var u usage
+	hosts := make(map[string]*HostUsage)
+	for _, c := range all {
+		u.add(c)
+		h := hosts[HostOf(c)]
+		if h == nil {
+			h = &HostUsage{Host: HostOf(c)}
+			hosts[h.Host] = h
+		}
+		h.Captures++
+		h.Bytes += recordSize(c)
+		for _, ref := range bodyRefs(c) {
+			h.Bytes += ref.Size
+		}
+	}
+	out.Captures, out.Bytes, out.BlobBytes = len(all), u.bytes, u.blobBytes
+	if len(all) > 0 {
+		out.Newest, out.Oldest = all[0].Timestamp, all[len(all)-1].Timestamp
+	}
+	for _, h := range hosts {
+		out.Hosts = append(out.Hosts, *h)
+	}
+	sort.Slice(out.Hosts, func(i, j int) bool {
+		if out.Hosts[i].Bytes != out.Hosts[j].Bytes {
+			return out.Hosts[i].Bytes > out.Hosts[j].Bytes
+		}
+		return out.Hosts[i].Host < out.Hosts[j].Host
+	})
+	return out, nil
+}
+
+// usage tallies record and blob bytes, counting a shared blob only while at
+// least one tallied capture references it.
+type usage struct {
+	bytes     int64
+	blobBytes int64
+	refs      map[string]int
+}
+
+func (u *usage) add(c *Capture) {
+	if u.refs == nil {
+		u.refs = make(map[string]int)
+	}
+	u.bytes += recordSize(c)
+	for _, ref := range bodyRefs(c) {
+		if u.refs[ref.Hash] == 0 {
+			u.bytes += ref.Size
+			u.blobBytes += ref.Size
+		}
+		u.refs[ref.Hash]++
+	}
+}
+
+func (u *usage) remove(c *Capture) {
+	u.bytes -= recordSize(c)
+	for _, ref := range bodyRefs(c) {
+		u.refs[ref.Hash]--
+		if u.refs[ref.Hash] == 0 {
+			u.bytes -= ref.Size
+			u.blobBytes -= ref.Size
+		}
+	}
+}
+
+func recordSize(c *Capture) int64 {
+	data, _ := json.Marshal(c)
+	return int64(len(data))
+}
+
+func bodyRefs(c *Capture) []*BlobRef {
+	var out []*BlobRef
+	if c.Request.BodyBlob != nil {
+		out = append(out, c.Request.BodyBlob)
+	}
+	if c.Response != nil && c.Response.BodyBlob != nil {
+		out = append(out, c.Response.BodyBlob)
+	}
+	return out
+}
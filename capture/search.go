@@ -0,0 +1,562 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexFile holds the full-text index inside the store dir.
+const IndexFile = "index.db"
+
+const (
+	maxIndexText  = 64 << 10
+	maxTermLen    = 64
+	snippetRadius = 40
+	maxHighlights = 3
+)
+
+var (
+	bktPostings = []byte("postings")
+	bktDocs     = []byte("docs")
+	keyDocs     = []byte("docs")
+	keyLen      = []byte("len")
+)
+
+// SearchHit is one capture matching a full-text search, best first.
+type SearchHit struct {
+	Capture    *Capture    `json:"capture"`
+	Score      float64     `json:"score"`
+	Highlights []Highlight `json:"highlights,omitempty"`
+}
+
+// Highlight is an excerpt of one searched field with the byte ranges of
+// matched terms inside Snippet.
+type Highlight struct {
+	Field   string   `json:"field"`
+	Snippet string   `json:"snippet"`
+	Matches [][2]int `json:"matches"`
+}
+
+type textField struct {
+	name string
+	text string
+}
+
+// searchText returns the text of c covered by full-text search: the URL,
+// headers, request and response bodies, WebSocket and SSE payloads, and the
+// user's note and tags.
+// Binary bodies are skipped and long ones are cut at maxIndexText. Bodies
+// spilled to blobs are only covered once read back in with searchable.
+func searchText(c *Capture) []textField {
+	out := []textField{{"url", c.Request.URL}}
+	addText := func(name string, b []byte) {
+		if len(b) > maxIndexText {
+			b = b[:maxIndexText]
+		}
+		if len(b) > 0 && utf8.Valid(b) {
+			out = append(out, textField{name, string(b)})
+		}
+	}
+	addHeaders := func(name string, h map[string][]string) {
+		var sb strings.Builder
+		keys := make([]string, 0, len(h))
+		for k := range h {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range h[k] {
+				sb.WriteString(k + ": " + v + "\n")
+			}
+		}
+		if sb.Len() > 0 {
+			out = append(out, textField{name, sb.String()})
+		}
+	}
+	addHeaders("req.header", c.Request.Headers)
+	addText("req.body", c.Request.Body)
+	if c.Response != nil {
+		addHeaders("resp.header", c.Response.Headers)
+		addText("resp.body", c.Response.Body)
+	}
+	if c.WebSocket != nil {
+		for _, f := range c.WebSocket.Frames {
+			addText("ws."+f.Direction, f.Payload)
+		}
+	}
+	if c.SSE != nil {
+		for _, f := range c.SSE.Frames {
+			addText("sse", []byte(f.Data))
+		}
+	}
+	addText("note", []byte(c.Note))
+	addText("tags", []byte(strings.Join(c.Tags, " ")))
+	return out
+}
+
+// searchable returns c with the start of its blob bodies read back in, so
+// they are indexed and highlighted like inline ones.
+func (s *Store) searchable(c *Capture) *Capture {
+	return s.PeekBodies(c, maxIndexText)
+}
+
+// tokens calls fn for each word in text with its byte offsets. Words are
+// runs of letters, digits and underscores, folded to lower case.
+func tokens(text string, fn func(term string, start, end int)) {
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			emitToken(text, start, i, fn)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		emitToken(text, start, len(text), fn)
+	}
+}
+
+func emitToken(text string, start, end int, fn func(string, int, int)) {
+	if end-start < 2 || end-start > maxTermLen {
+		return
+	}
+	fn(strings.ToLower(text[start:end]), start, end)
+}
+
+// SearchTerms splits a search string into the terms matched against the index.
+func SearchTerms(text string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	tokens(text, func(t string, _, _ int) {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	})
+	return out
+}
+
+func termCounts(c *Capture) (map[string]uint64, uint64) {
+	tf := make(map[string]uint64)
+	var n uint64
+	for _, f := range searchText(c) {
+		tokens(f.text, func(t string, _, _ int) {
+			tf[t]++
+			n++
+		})
+	}
+	return tf, n
+}
+
+// searchIndex is an inverted index from term to the captures containing it,
+// kept in its own bbolt file and opened per operation like boltBackend.
+type searchIndex struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (x *searchIndex) update(fn func(tx *bolt.Tx) error) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	db, err := bolt.Open(x.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bktPostings, bktDocs, bktMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+func (x *searchIndex) view(fn func(tx *bolt.Tx) error) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	db, err := bolt.Open(x.path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bktDocs) == nil {
+			return nil
+		}
+		return fn(tx)
+	})
+}
+
+func (x *searchIndex) exists() bool {
+	_, err := os.Stat(x.path)
+	return err == nil
+}
+
+func postingKey(term, id string) []byte {
+	return []byte(term + "\x00" + id)
+}
+
+func addMeta(tx *bolt.Tx, key []byte, delta int64) error {
+	meta := tx.Bucket(bktMeta)
+	var n int64
+	if v := meta.Get(key); v != nil {
+		n = int64(binary.BigEndian.Uint64(v))
+	}
+	return meta.Put(key, binary.BigEndian.AppendUint64(nil, uint64(max(n+delta, 0))))
+}
+
+func metaValue(tx *bolt.Tx, key []byte) float64 {
+	if v := tx.Bucket(bktMeta).Get(key); v != nil {
+		return float64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (x *searchIndex) add(cs ...*Capture) error {
+	return x.update(func(tx *bolt.Tx) error {
+		for _, c := range cs {
+			if err := unindexDocTx(tx, c.ID); err != nil {
+				return err
+			}
+			tf, n := termCounts(c)
+			terms := make([]string, 0, len(tf))
+			post := tx.Bucket(bktPostings)
+			for t, k := range tf {
+				terms = append(terms, t)
+				if err := post.Put(postingKey(t, c.ID), binary.AppendUvarint(nil, k)); err != nil {
+					return err
+				}
+			}
+			doc := binary.AppendUvarint(nil, n)
+			doc = append(doc, strings.Join(terms, "\x00")...)
+			if err := tx.Bucket(bktDocs).Put([]byte(c.ID), doc); err != nil {
+				return err
+			}
+			if err := addMeta(tx, keyDocs, 1); err != nil {
+				return err
+			}
+			if err := addMeta(tx, keyLen, int64(n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// refresh re-indexes c if the index has been built. A missing index is left
+// for Search to build from every capture at once.
+func (x *searchIndex) refresh(c *Capture) error {
+	if !x.exists() {
+		return nil
+	}
+	return x.add(c)
+}
+
+func unindexDocTx(tx *bolt.Tx, id string) error {
+	docs := tx.Bucket(bktDocs)
+	doc := docs.Get([]byte(id))
+	if doc == nil {
+		return nil
+	}
+	n, w := binary.Uvarint(doc)
+	if len(doc) > w {
+		post := tx.Bucket(bktPostings)
+		for _, t := range strings.Split(string(doc[w:]), "\x00") {
+			if err := post.Delete(postingKey(t, id)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := docs.Delete([]byte(id)); err != nil {
+		return err
+	}
+	if err := addMeta(tx, keyDocs, -1); err != nil {
+		return err
+	}
+	return addMeta(tx, keyLen, -int64(n))
+}
+
+func (x *searchIndex) remove(ids []string) error {
+	if len(ids) == 0 || !x.exists() {
+		return nil
+	}
+	return x.update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := unindexDocTx(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type scored struct {
+	id    string
+	score float64
+}
+
+// search ranks the documents containing every term with BM25.
+func (x *searchIndex) search(terms []string) ([]scored, error) {
+	var out []scored
+	err := x.view(func(tx *bolt.Tx) error {
+		post := tx.Bucket(bktPostings)
+		docs := tx.Bucket(bktDocs)
+		var sets []map[string]uint64
+		for _, t := range terms {
+			set := make(map[string]uint64)
+			prefix := []byte(t + "\x00")
+			c := post.Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				tf, _ := binary.Uvarint(v)
+				set[string(k[len(prefix):])] = tf
+			}
+			sets = append(sets, set)
+		}
+		docLen := func(id string) float64 {
+			n, _ := binary.Uvarint(docs.Get([]byte(id)))
+			return float64(n)
+		}
+		out = rank(sets, docLen, metaValue(tx, keyDocs), metaValue(tx, keyLen))
+		return nil
+	})
+	return out, err
+}
+
+// rank scores documents that appear in every posting set with BM25.
+func rank(sets []map[string]uint64, docLen func(string) float64, n, totalLen float64) []scored {
+	if len(sets) == 0 || n == 0 {
+		return nil
+	}
+	const k1, b = 1.2, 0.75
+	avg := totalLen / n
+	var out []scored
+	for id := range sets[0] {
+		score := 0.0
+		for _, set := range sets {
+			tf, ok := set[id]
+			if !ok {
+				score = -1
+				break
+			}
+			df := float64(len(set))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			f := float64(tf)
+			score += idf * f * (k1 + 1) / (f + k1*(1-b+b*docLen(id)/avg))
+		}
+		if score >= 0 {
+			out = append(out, scored{id, score})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].id > out[j].id
+	})
+	return out
+}
+
+// rankCaptures is the index-free path used by memory-only stores.
+func rankCaptures(cs []*Capture, terms []string) []scored {
+	sets := make([]map[string]uint64, len(terms))
+	for i := range sets {
+		sets[i] = make(map[string]uint64)
+	}
+	lens := make(map[string]float64, len(cs))
+	var total float64
+	for _, c := range cs {
+		tf, n := termCounts(c)
+		lens[c.ID] = float64(n)
+		total += float64(n)
+		for i, t := range terms {
+			if k := tf[t]; k > 0 {
+				sets[i][c.ID] = k
+			}
+		}
+	}
+	return rank(sets, func(id string) float64 { return lens[id] }, float64(len(cs)), total)
+}
+
+// Search finds captures containing every word of text, ranked by relevance,
+// with excerpts showing where the words matched. limit caps the hits
+// (0 = no limit). The index is built on first use for stores that predate it.
+func (s *Store) Search(text string, limit int) ([]SearchHit, error) {
+	terms := SearchTerms(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var ranked []scored
+	if s.backend == nil {
+		ranked = rankCaptures(s.All(), terms)
+	} else {
+		if !s.index.exists() {
+			if _, err := s.Reindex(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if ranked, err = s.index.search(terms); err != nil {
+			return nil, err
+		}
+	}
+	var hits []SearchHit
+	for _, r := range ranked {
+		if limit > 0 && len(hits) >= limit {
+			break
+		}
+		c := s.Get(r.id)
+		if c == nil {
+			continue
+		}
+		hits = append(hits, SearchHit{Capture: c, Score: r.score, Highlights: Highlights(s.searchable(c), terms)})
+	}
+	return hits, nil
+}
+
+// Reindex rebuilds the full-text index from every persisted capture.
+func (s *Store) Reindex() (int, error) {
+	if s.backend == nil {
+		return 0, nil
+	}
+	_ = os.Remove(s.index.path)
+	all := s.AllFromDisk()
+	const batch = 500
+	for i := 0; i < len(all); i += batch {
+		cs := make([]*Capture, 0, batch)
+		for _, c := range all[i:min(i+batch, len(all))] {
+			cs = append(cs, s.searchable(c))
+		}
+		if err := s.index.add(cs...); err != nil {
+			return i, err
+		}
+	}
+	if len(all) == 0 {
+		return 0, s.index.update(func(*bolt.Tx) error { return nil })
+	}
+	return len(all), nil
+}
+
+// Scan finds captures whose searched text contains every word of text
+// anywhere, also inside longer words ("token" in "access_token", part of an
+// ID), which the index only matches whole. Captures in skip are left out.
+// Scan reads every capture, so it is meant as a fallback after Search.
+func (s *Store) Scan(text string, skip map[string]bool) []SearchHit {
+	terms := SearchTerms(text)
+	if len(terms) == 0 {
+		return nil
+	}
+	all := s.All()
+	if s.backend != nil {
+		all = s.AllFromDisk()
+	}
+	var hits []SearchHit
+	for _, c := range all {
+		if skip[c.ID] {
+			continue
+		}
+		full := s.searchable(c)
+		found := make(map[string]bool, len(terms))
+		for _, f := range searchText(full) {
+			lower := strings.ToLower(f.text)
+			for _, t := range terms {
+				if strings.Contains(lower, t) {
+					found[t] = true
+				}
+			}
+		}
+		if len(found) == len(terms) {
+			hits = append(hits, SearchHit{Capture: c, Highlights: highlights(full, func(text string) [][2]int { return substrings(text, terms) })})
+		}
+	}
+	return hits
+}
+
+// substrings returns the byte ranges in text where any of terms occur,
+// ignoring case.
+func substrings(text string, terms []string) [][2]int {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		return nil
+	}
+	var spans [][2]int
+	for i := 0; i < len(lower); {
+		end := 0
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], t) {
+				end = max(end, i+len(t))
+			}
+		}
+		if end == 0 {
+			i++
+			continue
+		}
+		spans = append(spans, [2]int{i, end})
+		i = end
+	}
+	return spans
+}
+
+// Highlights returns up to maxHighlights excerpts of c where any of terms
+// occur.
+func Highlights(c *Capture, terms []string) []Highlight {
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+	return highlights(c, func(text string) [][2]int {
+		var spans [][2]int
+		tokens(text, func(t string, start, end int) {
+			if want[t] {
+				spans = append(spans, [2]int{start, end})
+			}
+		})
+		return spans
+	})
+}
+
+func highlights(c *Capture, match func(text string) [][2]int) []Highlight {
+	var out []Highlight
+	for _, f := range searchText(c) {
+		if len(out) >= maxHighlights {
+			break
+		}
+		spans := match(f.text)
+		if len(spans) == 0 {
+			continue
+		}
+		from := runeStart(f.text, max(spans[0][0]-snippetRadius, 0))
+		to := runeStart(f.text, min(spans[0][1]+snippetRadius, len(f.text)))
+		if to < spans[0][1] {
+			to = len(f.text)
+		}
+		h := Highlight{Field: f.name, Snippet: f.text[from:to]}
+		for _, sp := range spans {
+			if sp[0] >= from && sp[1] <= to {
+				h.Matches = append(h.Matches, [2]int{sp[0] - from, sp[1] - from})
+			}
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
@@ -1,24 +1,40 @@
 package capture
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"sync"
 )
 
 const defaultMaxCaptures = 1000
 
 type Store struct {
-	mu       sync.RWMutex
-	captures []*Capture
-	max      int
-	dir      string
+	mu        sync.RWMutex
+	captures  []*Capture
+	max       int
+	dir       string
+	backend   Backend
+	feed      feed
+	refs      blobRefs
+	blobs     blobCache
+	retention Retention
+	index     searchIndex
 }
 
+// NewStore opens the store in persistDir with whichever backend the
+// directory already uses, falling back to the JSON backend.
 func NewStore(maxCaptures int, persistDir string) *Store {
+	s, err := NewStoreBackend(maxCaptures, persistDir, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] failed to open store: %v\n", err)
+	}
+	return s
+}
+
+// NewStoreBackend is like NewStore but selects the backend explicitly.
+// An empty kind detects it from persistDir.
+func NewStoreBackend(maxCaptures int, persistDir, kind string) (*Store, error) {
 	if maxCaptures <= 0 {
 		maxCaptures = defaultMaxCaptures
 	}
@@ -26,85 +42,152 @@ func NewStore(maxCaptures int, persistDir string) *Store {
 		captures: make([]*Capture, 0, maxCaptures),
 		max:      maxCaptures,
 		dir:      persistDir,
+		refs:     blobRefs{path: filepath.Join(persistDir, BlobDir, refsFile)},
+		index:    searchIndex{path: filepath.Join(persistDir, IndexFile)},
 	}
-	if persistDir != "" {
-		existing := s.AllFromDisk()
-		if len(existing) > maxCaptures {
-			existing = existing[len(existing)-maxCaptures:]
-		}
-		s.captures = existing
+	if persistDir == "" {
+		return s, nil
 	}
-	return s
+	b, err := OpenBackend(persistDir, kind)
+	if err != nil {
+		return s, err
+	}
+	s.backend = b
+	recent, _ := b.Recent(maxCaptures)
+	for i := len(recent) - 1; i >= 0; i-- {
+		s.captures = append(s.captures, s.hydrate(recent[i]))
+	}
+	return s, nil
+}
+
+// BackendKind reports the persistence backend, or "" for memory-only stores.
+func (s *Store) BackendKind() string {
+	if s.backend == nil {
+		return ""
+	}
+	return s.backend.Kind()
 }
 
 func (s *Store) Add(c *Capture) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.captures = append(s.captures, c)
 	if len(s.captures) > s.max {
 		s.captures = s.captures[len(s.captures)-s.max:]
 	}
-	if s.dir != "" {
-		if err := s.persistOne(c); err != nil {
+	if s.backend != nil {
+		if err := s.persist(c); err != nil {
 			fmt.Fprintf(os.Stderr, "[snare] failed to save capture: %v\n", err)
 		}
-		s.pruneDiskToMax()
+		if s.retention.Keep == nil {
+			pruned, _ := s.backend.Prune(s.max)
+			s.forget(pruned)
+		}
 	}
+	s.mu.Unlock()
+	s.publish(Event{Type: EventAdded, ID: c.ID, Capture: c})
 }
 
-func (s *Store) DeleteByID(id string) error {
-	if s.dir == "" {
-		return fmt.Errorf("no store directory")
+// Import persists c unless a capture with the same ID already exists.
+// Body references must name a blob this store already holds; references to
+// missing blobs are dropped, and malformed ones are an error.
+func (s *Store) Import(c *Capture) (bool, error) {
+	if s.backend == nil {
+		return false, fmt.Errorf("no store directory")
 	}
-	f := filepath.Join(s.dir, id+".json")
-	if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
-		return err
+	if err := s.checkRefs(c); err != nil {
+		return false, err
 	}
-	s.mu.Lock()
-	for i := range s.captures {
-		if s.captures[i].ID == id {
-			s.captures = append(s.captures[:i], s.captures[i+1:]...)
-			break
-		}
+	if existing, _ := s.backend.Get(c.ID); existing != nil {
+		return false, nil
 	}
-	s.mu.Unlock()
-	return nil
+	if err := s.persist(c); err != nil {
+		return false, err
+	}
+	s.publish(Event{Type: EventAdded, ID: c.ID, Capture: c})
+	return true, nil
 }
 
-func (s *Store) pruneDiskToMax() {
-	entries, err := os.ReadDir(s.dir)
-	if err != nil {
-		return
-	}
-	var files []os.FileInfo
-	for _, e := range entries {
-		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
-			continue
+func (s *Store) checkRefs(c *Capture) error {
+	check := func(ref **BlobRef) error {
+		if *ref == nil {
+			return nil
 		}
-		info, err := e.Info()
+		path, err := s.blobPath((*ref).Hash)
 		if err != nil {
-			continue
+			return err
 		}
-		files = append(files, info)
+		if _, err := os.Stat(path); err != nil {
+			*ref = nil
+		}
+		return nil
+	}
+	if err := check(&c.Request.BodyBlob); err != nil {
+		return err
+	}
+	if c.Response != nil {
+		return check(&c.Response.BodyBlob)
 	}
-	if len(files) <= s.max {
+	return nil
+}
+
+// persist writes c to the backend with its larger bodies deduplicated and
+// takes a reference on every blob it points at.
+func (s *Store) persist(c *Capture) error {
+	stored, data := s.stash(c)
+	if err := s.holdBlobs(stored, data); err != nil {
+		return err
+	}
+	if err := s.backend.Put(stored); err != nil {
+		s.releaseBlobs([]*Capture{stored})
+		return err
+	}
+	if err := s.index.refresh(s.searchable(c)); err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] failed to index capture: %v\n", err)
+	}
+	return nil
+}
+
+// forget cleans up after captures removed from the backend: their blob
+// references and their search index entries.
+func (s *Store) forget(removed []*Capture) {
+	if len(removed) == 0 {
 		return
 	}
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].ModTime().Before(files[j].ModTime())
-	})
-	for i := 0; i < len(files)-s.max; i++ {
-		_ = os.Remove(filepath.Join(s.dir, files[i].Name()))
+	s.releaseBlobs(removed)
+	ids := make([]string, len(removed))
+	for i, c := range removed {
+		ids[i] = c.ID
+	}
+	if err := s.index.remove(ids); err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] failed to update search index: %v\n", err)
 	}
 }
 
-func (s *Store) persistOne(c *Capture) error {
-	f := filepath.Join(s.dir, c.ID+".json")
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
+func (s *Store) DeleteByID(id string) error {
+	if s.backend == nil {
+		return fmt.Errorf("no store directory")
+	}
+	stored, _ := s.backend.Get(id)
+	if err := s.backend.Delete(id); err != nil {
 		return err
 	}
-	return os.WriteFile(f, data, 0600)
+	if stored != nil {
+		s.forget([]*Capture{stored})
+	}
+	s.mu.Lock()
+	s.removeLocked(id)
+	s.mu.Unlock()
+	s.publish(Event{Type: EventDeleted, ID: id})
+	return nil
+}
+
+func (s *Store) removeLocked(id string) {
+	for i := range s.captures {
+		if s.captures[i].ID == id {
+			s.captures = append(s.captures[:i], s.captures[i+1:]...)
+			return
+		}
+	}
 }
 
 func (s *Store) List(n int) []*Capture {
@@ -130,51 +213,34 @@ func (s *Store) Get(id string) *Capture {
 		}
 	}
 	s.mu.RUnlock()
-	if s.dir != "" {
-		return s.loadFromDisk(id)
+	if s.backend != nil {
+		c, _ := s.backend.Get(id)
+		return s.hydrate(c)
 	}
 	return nil
 }
 
-func (s *Store) loadFromDisk(id string) *Capture {
-	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
-	if err != nil {
-		return nil
-	}
-	var c Capture
-	if json.Unmarshal(data, &c) != nil {
-		return nil
-	}
-	return &c
-}
-
 func (s *Store) GetByPrefix(prefix string) *Capture {
 	if c := s.Get(prefix); c != nil {
 		return c
 	}
-	for _, c := range s.AllFromDisk() {
-		if len(c.ID) >= len(prefix) && c.ID[:len(prefix)] == prefix {
-			return c
-		}
+	if s.backend != nil {
+		c, _ := s.backend.GetByPrefix(prefix)
+		return s.hydrate(c)
 	}
 	return nil
 }
 
 func (s *Store) Clear(deleteFiles bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.captures = s.captures[:0]
-	if deleteFiles && s.dir != "" {
-		_ = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() {
-				return nil
-			}
-			if filepath.Ext(path) == ".json" {
-				_ = os.Remove(path)
-			}
-			return nil
-		})
+	if deleteFiles && s.backend != nil {
+		_ = s.backend.Clear()
+		_ = os.RemoveAll(filepath.Join(s.dir, BlobDir))
+		_ = os.Remove(s.index.path)
 	}
+	s.mu.Unlock()
+	s.publish(Event{Type: EventCleared})
 }
 
 func (s *Store) All() []*Capture {
@@ -186,68 +252,22 @@ func (s *Store) All() []*Capture {
 }
 
 func (s *Store) ListFromDisk(n int) []*Capture {
-	if s.dir == "" {
-		return nil
-	}
-	entries, err := os.ReadDir(s.dir)
-	if err != nil {
+	if s.backend == nil {
 		return nil
 	}
-	var files []os.FileInfo
-	for _, e := range entries {
-		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
-			continue
-		}
-		info, err := e.Info()
-		if err != nil {
-			continue
-		}
-		files = append(files, info)
-	}
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].ModTime().After(files[j].ModTime())
-	})
-	if n > 0 && len(files) > n {
-		files = files[:n]
-	}
-	var out []*Capture
-	for _, f := range files {
-		id := f.Name()
-		if len(id) > 5 {
-			id = id[:len(id)-5]
-		}
-		c := s.loadFromDisk(id)
-		if c != nil {
-			out = append(out, c)
-		}
-	}
-	return out
+	out, _ := s.backend.Recent(n)
+	return s.hydrateAll(out)
 }
 
 func (s *Store) AllFromDisk() []*Capture {
-	if s.dir == "" {
-		return nil
-	}
-	entries, err := os.ReadDir(s.dir)
-	if err != nil {
+	return s.Find(Filter{})
+}
+
+// Find returns persisted captures matching f, newest first.
+func (s *Store) Find(f Filter) []*Capture {
+	if s.backend == nil {
 		return nil
 	}
-	var out []*Capture
-	for _, e := range entries {
-		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
-			continue
-		}
-		id := e.Name()
-		if len(id) > 5 {
-			id = id[:len(id)-5]
-		}
-		c := s.loadFromDisk(id)
-		if c != nil {
-			out = append(out, c)
-		}
-	}
-	sort.Slice(out, func(i, j int) bool {
-		return out[i].Timestamp.After(out[j].Timestamp)
-	})
-	return out
+	out, _ := s.backend.Find(f)
+	return s.hydrateAll(out)
 }
@@ -0,0 +1,491 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCapture(i int, method, url string, status int) *Capture {
+	return &Capture{
+		ID:        fmt.Sprintf("id-%03d", i),
+		Timestamp: time.Unix(1700000000, 0).Add(time.Duration(i) * time.Second),
+		Request:   RequestSnapshot{Method: method, URL: url},
+		Response:  &ResponseSnapshot{StatusCode: status},
+	}
+}
+
+func TestBoltBackendFind(t *testing.T) {
+	s, err := NewStoreBackend(100, t.TempDir(), BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		method, status := "GET", 200
+		if i%2 == 1 {
+			method, status = "POST", 500
+		}
+		s.Add(testCapture(i, method, "https://api.example.com/x", status))
+	}
+	s.Add(testCapture(10, "GET", "http://other.test/", 404))
+
+	if got := s.Find(Filter{Method: "POST", Status: 500, Limit: 2}); len(got) != 2 || got[0].ID != "id-009" || got[1].ID != "id-007" {
+		t.Fatalf("method+status: got %v", ids(got))
+	}
+	if got := s.Find(Filter{Host: "example"}); len(got) != 10 {
+		t.Fatalf("host: got %d captures", len(got))
+	}
+	if got := s.Find(Filter{Host: "API.Example"}); len(got) != 10 {
+		t.Fatalf("host ignoring case: got %d captures", len(got))
+	}
+	since := time.Unix(1700000000, 0).Add(3 * time.Second)
+	until := since.Add(2 * time.Second)
+	if got := s.Find(Filter{Since: since, Until: until}); len(got) != 3 || got[0].ID != "id-005" || got[2].ID != "id-003" {
+		t.Fatalf("time range: got %v", ids(got))
+	}
+	if c := s.GetByPrefix("id-01"); c == nil || c.ID != "id-010" {
+		t.Fatalf("prefix: got %v", c)
+	}
+	if err := s.DeleteByID("id-010"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Find(Filter{Status: 404}); len(got) != 0 {
+		t.Fatalf("deleted capture still indexed: %v", ids(got))
+	}
+}
+
+func TestBoltBackendPrune(t *testing.T) {
+	s, err := NewStoreBackend(3, t.TempDir(), BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		s.Add(testCapture(i, "GET", "http://a.test/", 200))
+	}
+	got := s.AllFromDisk()
+	if len(got) != 3 || got[2].ID != "id-002" {
+		t.Fatalf("got %v", ids(got))
+	}
+}
+
+func TestBoltBackendSharesFile(t *testing.T) {
+	dir := t.TempDir()
+	serve, err := NewStoreBackend(10, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		serve.Add(testCapture(i, "GET", "http://a.test/", 200))
+	}
+	// A second store stands in for a CLI command; it gets the file once
+	// serve's handle is released.
+	cli, err := NewStoreBackend(10, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.AllFromDisk(); len(got) != 3 {
+		t.Fatalf("cli sees %d captures", len(got))
+	}
+	serve.Add(testCapture(3, "GET", "http://a.test/", 200))
+	if c, _ := cli.backend.Get("id-003"); c == nil {
+		t.Fatal("cli does not see capture added after it read")
+	}
+}
+
+func TestBoltBackendThroughFeed(t *testing.T) {
+	dir := t.TempDir()
+	serve, err := NewStoreBackend(1000, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.ServeFeed(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			serve.Add(testCapture(i, "GET", "http://a.test/", 200))
+		}
+	}()
+	// The CLI store is answered by serve, which keeps the file open.
+	cli, err := NewStoreBackend(1000, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		if _, err := cli.backend.Find(Filter{Limit: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if _, err := cli.Annotate("id-007", Annotation{AddTags: []string{"seen"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := serve.backend.Get("id-007"); c == nil || !c.HasTag("seen") {
+		t.Fatalf("annotation not stored: %+v", c)
+	}
+	if n, err := cli.backend.Count(); err != nil || n != 200 {
+		t.Fatalf("count: %d %v", n, err)
+	}
+	b := serve.backend.(*feedBackend)
+	b.dbMu.Lock()
+	open := b.db != nil
+	b.dbMu.Unlock()
+	if !open {
+		t.Fatal("serve let go of the database while serving")
+	}
+
+	serve.CloseFeed()
+	if n, err := cli.backend.Count(); err != nil || n != 200 {
+		t.Fatalf("count after serve stopped: %d %v", n, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(100, dir)
+	for i := 0; i < 4; i++ {
+		s.Add(testCapture(i, "GET", "http://a.test/", 200))
+	}
+	n, err := Migrate(dir, BackendBolt)
+	if err != nil || n != 4 {
+		t.Fatalf("migrate: n=%d err=%v", n, err)
+	}
+	if DetectBackend(dir) != BackendBolt {
+		t.Fatal("expected bolt backend after migrate")
+	}
+	s = NewStore(100, dir)
+	if s.BackendKind() != BackendBolt || len(s.List(0)) != 4 || s.List(1)[0].ID != "id-003" {
+		t.Fatalf("reopened store: kind=%s %v", s.BackendKind(), ids(s.List(0)))
+	}
+	if n, err := Migrate(dir, BackendJSON); err != nil || n != 4 {
+		t.Fatalf("migrate back: n=%d err=%v", n, err)
+	}
+	if DetectBackend(dir) != BackendJSON || len(NewStore(100, dir).AllFromDisk()) != 4 {
+		t.Fatal("expected json backend with 4 captures")
+	}
+}
+
+func TestDedupBodies(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(3, dir)
+	body := bytes.Repeat([]byte("poll "), 200)
+	for i := 0; i < 5; i++ {
+		c := testCapture(i, "GET", "http://a.test/poll", 200)
+		c.Response.Body = body
+		s.Add(c)
+	}
+	blobs := func() int {
+		n := 0
+		_ = filepath.WalkDir(filepath.Join(dir, BlobDir), func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && filepath.Ext(path) == "" {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+	if n := blobs(); n != 1 {
+		t.Fatalf("expected 1 shared blob, got %d", n)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, "id-004.json"))
+	if bytes.Contains(raw, []byte("poll poll")) {
+		t.Fatal("body stored inline")
+	}
+	got := NewStore(3, dir).Get("id-004")
+	if got == nil || !bytes.Equal(got.Response.Body, body) || got.Response.BodyBlob != nil {
+		t.Fatalf("body not read back: %+v", got)
+	}
+	for _, id := range []string{"id-002", "id-003"} {
+		if err := s.DeleteByID(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := blobs(); n != 1 {
+		t.Fatalf("blob removed while still referenced, got %d", n)
+	}
+	if err := s.DeleteByID("id-004"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobs(); n != 0 {
+		t.Fatalf("expected unreferenced blob to be removed, got %d", n)
+	}
+}
+
+func TestDedupConcurrentRelease(t *testing.T) {
+	dir := t.TempDir()
+	body := bytes.Repeat([]byte("shared "), 100)
+	churn, keep := NewStore(100, dir), NewStore(100, dir)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			c := testCapture(100+i, "GET", "http://a.test/churn", 200)
+			c.Response.Body = body
+			churn.Add(c)
+			_ = churn.DeleteByID(c.ID)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		c := testCapture(i, "GET", "http://a.test/keep", 200)
+		c.Response.Body = body
+		keep.Add(c)
+	}
+	<-done
+	all := NewStore(100, dir).All()
+	if len(all) != 20 {
+		t.Fatalf("expected 20 captures, got %d", len(all))
+	}
+	for _, c := range all {
+		if !bytes.Equal(c.Response.Body, body) {
+			t.Fatalf("%s lost its body to a concurrent release", c.ID)
+		}
+	}
+}
+
+func TestImportBlobRefs(t *testing.T) {
+	s := NewStore(10, t.TempDir())
+	c := testCapture(1, "GET", "http://a.test/", 200)
+	c.Response.BodyBlob = &BlobRef{Hash: "../../x", Size: 1}
+	if _, err := s.Import(c); err == nil {
+		t.Fatal("imported a capture with a malformed blob hash")
+	}
+	c.Response.BodyBlob = &BlobRef{Hash: strings.Repeat("ab", 32), Size: 1}
+	if added, err := s.Import(c); err != nil || !added {
+		t.Fatalf("import: %v %v", added, err)
+	}
+	if got := s.Get(c.ID); got == nil || got.Response.BodyBlob != nil {
+		t.Fatalf("ref to a missing blob kept: %+v", got)
+	}
+	if _, err := s.OpenBlob(&BlobRef{Hash: "ab"}); err == nil {
+		t.Fatal("opened a blob with a short hash")
+	}
+}
+
+func TestCompactRetention(t *testing.T) {
+	s, err := NewStoreBackend(100, t.TempDir(), BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		s.Add(testCapture(i, "GET", "http://a.test/", 200))
+	}
+	for i := 6; i < 9; i++ {
+		s.Add(testCapture(i, "GET", "http://b.test/", 200))
+	}
+	keepID := "id-000"
+	s.SetRetention(Retention{MaxPerHost: 2, Keep: func(c *Capture) bool { return c.ID == keepID }})
+	if n, err := s.Compact(); err != nil || n != 4 {
+		t.Fatalf("per-host: removed %d, err %v", n, err)
+	}
+	if got := ids(s.AllFromDisk()); fmt.Sprint(got) != "[id-008 id-007 id-005 id-004 id-000]" {
+		t.Fatalf("per-host: got %v", got)
+	}
+	if got := ids(s.List(0)); len(got) != 5 {
+		t.Fatalf("memory not compacted: %v", got)
+	}
+
+	one := recordSize(testCapture(8, "GET", "http://b.test/", 200))
+	s.SetRetention(Retention{MaxBytes: 3*one + one/2, Keep: func(c *Capture) bool { return c.ID == keepID }})
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(s.AllFromDisk()); fmt.Sprint(got) != "[id-008 id-007 id-000]" {
+		t.Fatalf("size: got %v", got)
+	}
+
+	s.SetRetention(Retention{MaxAge: time.Hour})
+	if _, err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.AllFromDisk(); len(got) != 0 {
+		t.Fatalf("age: got %v", ids(got))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStoreBackend(100, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testCapture(0, "GET", "http://a.test/orders", 200)
+	a.Response.Body = BodyBytes(`{"error":"payment declined","detail":"card declined by issuer"}`)
+	b := testCapture(1, "POST", "http://b.test/pay", 402)
+	b.Request.Body = BodyBytes(`{"note":"payment declined"}`)
+	c := testCapture(2, "GET", "http://c.test/", 200)
+	c.Response.Body = BodyBytes(`all good`)
+	for _, x := range []*Capture{a, b, c} {
+		s.Add(x)
+	}
+
+	hits, err := s.Search("Declined payment", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].Score < hits[1].Score {
+		t.Fatalf("hits: %+v", hits)
+	}
+	var h []Highlight
+	for _, hit := range hits {
+		if hit.Capture.ID == "id-000" {
+			h = hit.Highlights
+		}
+	}
+	if len(h) == 0 || h[0].Field != "resp.body" || len(h[0].Matches) == 0 {
+		t.Fatalf("highlights: %+v", h)
+	}
+	if m := h[0].Matches[0]; !bytes.EqualFold([]byte(h[0].Snippet[m[0]:m[1]]), []byte("payment")) {
+		t.Fatalf("match %v in %q", m, h[0].Snippet)
+	}
+
+	if err := s.DeleteByID("id-000"); err != nil {
+		t.Fatal(err)
+	}
+	if hits, _ := s.Search("issuer", 0); len(hits) != 0 {
+		t.Fatalf("deleted capture still indexed: %+v", hits)
+	}
+
+	if err := os.Remove(filepath.Join(dir, IndexFile)); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := NewStoreBackend(100, dir, BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits, err := s2.Search("declined", 0); err != nil || len(hits) != 1 || hits[0].Capture.ID != "id-001" {
+		t.Fatalf("after rebuild: %+v %v", hits, err)
+	}
+
+	d := testCapture(3, "GET", "http://d.test/auth?access_token=abc", 200)
+	s2.Add(d)
+	if hits, _ := s2.Search("token", 0); len(hits) != 0 {
+		t.Fatalf("index matched inside a word: %+v", hits)
+	}
+	hits = s2.Scan("TOKEN", nil)
+	if len(hits) != 1 || hits[0].Capture.ID != "id-003" || len(hits[0].Highlights) == 0 {
+		t.Fatalf("scan: %+v", hits)
+	}
+	if hl := hits[0].Highlights[0]; hl.Snippet[hl.Matches[0][0]:hl.Matches[0][1]] != "token" {
+		t.Fatalf("scan highlight: %+v", hl)
+	}
+	if hits := s2.Scan("token", map[string]bool{"id-003": true}); len(hits) != 0 {
+		t.Fatalf("scan ignored skip: %+v", hits)
+	}
+
+	sp := s2.NewSpill(8, 0)
+	_, _ = sp.Write([]byte("a large body mentioning a spilled invoice"))
+	_, ref, err := sp.Finish("")
+	if err != nil || ref == nil {
+		t.Fatalf("spill: %v %v", ref, err)
+	}
+	e := testCapture(4, "GET", "http://e.test/", 200)
+	e.Response.BodyBlob = ref
+	s2.Add(e)
+	hits, err = s2.Search("invoice", 0)
+	if err != nil || len(hits) != 1 || hits[0].Capture.ID != "id-004" {
+		t.Fatalf("blob body not indexed: %+v %v", hits, err)
+	}
+	if h := hits[0].Highlights; len(h) == 0 || h[0].Field != "resp.body" {
+		t.Fatalf("blob body highlights: %+v", h)
+	}
+}
+
+func TestAnnotateConcurrent(t *testing.T) {
+	for _, kind := range []string{BackendJSON, BackendBolt} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			a, err := NewStoreBackend(100, dir, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Add(testCapture(0, "GET", "http://a.test/", 200))
+			// A second store on the directory stands in for another process.
+			b, err := NewStoreBackend(100, dir, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for i := range 20 {
+				s := []*Store{a, b}[i%2]
+				wg.Go(func() {
+					if _, err := s.Annotate("id-000", Annotation{AddTags: []string{fmt.Sprintf("t%d", i)}}); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+			wg.Wait()
+			c, err := NewStoreBackend(100, dir, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Get("id-000"); got == nil || len(got.Tags) != 20 {
+				t.Fatalf("stored tags: %+v", got)
+			}
+		})
+	}
+}
+
+func TestAnnotateAndStarredPrune(t *testing.T) {
+	for _, kind := range []string{BackendJSON, BackendBolt} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := NewStoreBackend(3, dir, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				s.Add(testCapture(i, "GET", "http://a.test/", 200))
+				time.Sleep(10 * time.Millisecond)
+			}
+			star, note := true, "  checkout bug  "
+			if _, err := s.Annotate("id-000", Annotation{AddTags: []string{"bug-1", "triage"}, Note: &note, Starred: &star}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Annotate("id-000", Annotation{RemoveTags: []string{"triage"}}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Annotate("id-001", Annotation{AddTags: []string{"bad tag"}}); err == nil {
+				t.Fatal("expected invalid tag error")
+			}
+			for i := 3; i < 6; i++ {
+				s.Add(testCapture(i, "GET", "http://a.test/", 200))
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := ids(s.AllFromDisk()); fmt.Sprint(got) != "[id-005 id-004 id-003 id-000]" {
+				t.Fatalf("after prune: %v", got)
+			}
+
+			s2, err := NewStoreBackend(3, dir, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := s2.Get("id-000")
+			if c == nil || !c.Starred || fmt.Sprint(c.Tags) != "[bug-1]" || c.Note != "checkout bug" {
+				t.Fatalf("reloaded: %+v", c)
+			}
+			unstar := false
+			if _, err := s2.Annotate("id-000", Annotation{Starred: &unstar}); err != nil {
+				t.Fatal(err)
+			}
+			s2.Add(testCapture(6, "GET", "http://a.test/", 200))
+			if got := ids(s2.AllFromDisk()); fmt.Sprint(got) != "[id-006 id-005 id-004]" {
+				t.Fatalf("after unstar: %v", got)
+			}
+		})
+	}
+}
+
+func ids(cs []*Capture) []string {
+	var out []string
+	for _, c := range cs {
+		out = append(out, c.ID)
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/config"
+
+	"github.com/spf13/cobra"
+)
+
+var tagRemove bool
+
+var tagCmd = &cobra.Command{
+	Use:   "tag <id> <tag>...",
+	Short: "Tag a capture",
+	Long:  "Add tags to a capture by ID or prefix, or remove them with --remove. Filter by tag with --tag on list, export and bundle pack, or with tag == name in a query.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a := capture.Annotation{AddTags: args[1:]}
+		if tagRemove {
+			a = capture.Annotation{RemoveTags: args[1:]}
+		}
+		return annotate(args[0], a)
+	},
+}
+
+var noteCmd = &cobra.Command{
+	Use:   "note <id> <text>",
+	Short: "Attach a note to a capture",
+	Long:  "Set the note on a capture by ID or prefix. An empty text removes the note.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		note := strings.Join(args[1:], " ")
+		return annotate(args[0], capture.Annotation{Note: &note})
+	},
+}
+
+var starCmd = &cobra.Command{
+	Use:   "star <id>...",
+	Short: "Star captures so they are never pruned",
+	Long:  "Star captures by ID or prefix. Starred captures are kept when the store prunes to --max-captures or applies retention limits, and do not count towards them.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setStarred(args, true)
+	},
+}
+
+var unstarCmd = &cobra.Command{
+	Use:   "unstar <id>...",
+	Short: "Remove the star from captures",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setStarred(args, false)
+	},
+}
+
+func init() {
+	tagCmd.Flags().BoolVarP(&tagRemove, "remove", "d", false, "Remove the tags instead of adding them")
+}
+
+func setStarred(ids []string, starred bool) error {
+	for _, id := range ids {
+		if err := annotate(id, capture.Annotation{Starred: &starred}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func annotate(id string, a capture.Annotation) error {
+	store := capture.NewStore(0, config.StoreDir())
+	c := store.GetByPrefix(id)
+	if c == nil {
+		return fmt.Errorf("capture not found: %s", id)
+	}
+	c, err := store.Annotate(c.ID, a)
+	if err != nil {
+		return err
+	}
+	printCaptureLine(c)
+	if c.Note != "" {
+		fmt.Println("  " + colorFaint.Render("note: ") + c.Note)
+	}
+	return nil
+}
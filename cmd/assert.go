@@ -4,7 +4,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/config"
@@ -12,38 +11,32 @@ import (
 )
 
 var (
-	assertMethod string
-	assertStatus int
-	assertURL    string
-	assertBody   string
+	assertQuery  queryFlags
 	assertMin    int
 	assertMax    int
 	assertFormat string
-	assertSlow   int
 )
 
 var assertCmd = &cobra.Command{
 	Use:   "assert",
 	Short: "Assert capture conditions; exits 1 if they are not met",
-	Long:  "Filter captures using the same flags as 'list', then assert the matched count is within --min/--max bounds. Exits 0 on success, 1 on failure. Designed for use in CI pipelines.",
+	Long:  "Filter captures using the same flags and --query expressions as 'list', then assert the matched count is within --min/--max bounds. Exits 0 on success, 1 on failure. Designed for use in CI pipelines.",
 	RunE:  runAssert,
 }
 
 func init() {
-	assertCmd.Flags().StringVar(&assertMethod, "method", "", "Filter by HTTP method")
-	assertCmd.Flags().IntVar(&assertStatus, "status", 0, "Filter by response status code")
-	assertCmd.Flags().StringVar(&assertURL, "url", "", "Filter by URL substring")
-	assertCmd.Flags().StringVar(&assertBody, "body", "", "Filter by substring in request or response body")
+	assertQuery.register(assertCmd, "method", "status", "url", "body", "slow")
 	assertCmd.Flags().IntVar(&assertMin, "min", 1, "Minimum number of matching captures (inclusive)")
 	assertCmd.Flags().IntVar(&assertMax, "max", -1, "Maximum number of matching captures (-1 = no limit)")
 	assertCmd.Flags().StringVar(&assertFormat, "format", "text", "Output format: text or junit")
-	assertCmd.Flags().IntVar(&assertSlow, "slow", 0, "Filter to captures slower than N milliseconds")
 }
 
 func runAssert(cmd *cobra.Command, args []string) error {
 	store := capture.NewStore(0, config.StoreDir())
-	captures := store.AllFromDisk()
-	matched := filterCaptures(captures, assertMethod, assertStatus, assertURL, "", assertBody, "", time.Time{}, time.Time{}, assertSlow)
+	matched, _, err := assertQuery.find(store, 0)
+	if err != nil {
+		return err
+	}
 	n := len(matched)
 
 	label := buildAssertLabel()
@@ -74,20 +67,23 @@ func runAssert(cmd *cobra.Command, args []string) error {
 
 func buildAssertLabel() string {
 	var parts []string
-	if assertMethod != "" {
-		parts = append(parts, "method="+assertMethod)
+	if assertQuery.method != "" {
+		parts = append(parts, "method="+assertQuery.method)
+	}
+	if assertQuery.status != 0 {
+		parts = append(parts, fmt.Sprintf("status=%d", assertQuery.status))
 	}
-	if assertStatus != 0 {
-		parts = append(parts, fmt.Sprintf("status=%d", assertStatus))
+	if assertQuery.url != "" {
+		parts = append(parts, "url="+assertQuery.url)
 	}
-	if assertURL != "" {
-		parts = append(parts, "url="+assertURL)
+	if assertQuery.body != "" {
+		parts = append(parts, "body="+assertQuery.body)
 	}
-	if assertBody != "" {
-		parts = append(parts, "body="+assertBody)
+	if assertQuery.slow > 0 {
+		parts = append(parts, fmt.Sprintf("slow>%dms", assertQuery.slow))
 	}
-	if assertSlow > 0 {
-		parts = append(parts, fmt.Sprintf("slow>%dms", assertSlow))
+	if assertQuery.expr != "" {
+		parts = append(parts, assertQuery.expr)
 	}
 	if len(parts) == 0 {
 		return "*"
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -27,6 +26,7 @@ var (
 	bundlePackOut     string
 	bundlePackSession string
 	bundlePackIDs     string
+	bundlePackQuery   queryFlags
 )
 
 var bundlePackCmd = &cobra.Command{
@@ -36,7 +36,8 @@ var bundlePackCmd = &cobra.Command{
 
   snare bundle pack                          Pack all captures
   snare bundle pack --session baseline       Pack only captures from a named session
-  snare bundle pack --ids abc123,def456      Pack specific captures by ID prefix`,
+  snare bundle pack --ids abc123,def456      Pack specific captures by ID prefix
+  snare bundle pack --tag bug-1234           Pack only captures with a tag`,
 	RunE: runBundlePack,
 }
 
@@ -51,6 +52,7 @@ func init() {
 	bundlePackCmd.Flags().StringVarP(&bundlePackOut, "out", "o", "bundle.snare", "Output file")
 	bundlePackCmd.Flags().StringVar(&bundlePackSession, "session", "", "Pack only captures from this session")
 	bundlePackCmd.Flags().StringVar(&bundlePackIDs, "ids", "", "Comma-separated capture IDs (or prefixes) to pack")
+	bundlePackQuery.register(bundlePackCmd, "tag")
 
 	bundleCmd.AddCommand(bundlePackCmd)
 	bundleCmd.AddCommand(bundleUnpackCmd)
@@ -93,6 +95,11 @@ func runBundlePack(cmd *cobra.Command, args []string) error {
 	default:
 		captures = store.AllFromDisk()
 	}
+	q, err := bundlePackQuery.compile()
+	if err != nil {
+		return err
+	}
+	captures = q.WithBodies(store.SearchBodies).Filter(captures)
 
 	mocks := mock.NewStore(config.MockFile()).Rules()
 	sessions, err := sess.Load()
@@ -124,7 +131,11 @@ func runBundlePack(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, c := range captures {
-		data, err := json.Marshal(c)
+		full, err := store.ReadBodies(c)
+		if err != nil {
+			return fmt.Errorf("reading body of capture %s: %w", c.ID, err)
+		}
+		data, err := json.Marshal(full)
 		if err != nil {
 			continue
 		}
@@ -175,6 +186,7 @@ func runBundleUnpack(cmd *cobra.Command, args []string) error {
 	if err := os.MkdirAll(storeDir, 0700); err != nil {
 		return err
 	}
+	store := capture.NewStore(0, storeDir)
 
 	mockStore := mock.NewStore(config.MockFile())
 	sessions, err := sess.Load()
@@ -207,14 +219,14 @@ func runBundleUnpack(cmd *cobra.Command, args []string) error {
 			if err := json.Unmarshal(rec.Data, &c); err != nil {
 				continue
 			}
-			path := filepath.Join(storeDir, c.ID+".json")
-			if _, err := os.Stat(path); err == nil {
+			added, err := store.Import(&c)
+			if err != nil {
+				return fmt.Errorf("writing capture %s: %w", c.ID, err)
+			}
+			if !added {
 				capSkipped++
 				continue
 			}
-			if err := os.WriteFile(path, rec.Data, 0600); err != nil {
-				return fmt.Errorf("writing capture %s: %w", c.ID, err)
-			}
 			capImported++
 
 		case "mock":
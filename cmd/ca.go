@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/muxover/snare/v2/config"
@@ -17,11 +20,20 @@ import (
 )
 
 var caInstallDevice string
+var caKeyType string
+var caRSABits int
+var caIntermediate bool
+var caNameConstraints []string
+var caKeepRoot bool
+var caExportFormat string
+var caExportOut string
+var caExportPassword string
 
 var caCmd = &cobra.Command{
 	Use:   "ca",
 	Short: "CA certificate commands",
-	Long:  "Manage the proxy's CA certificate used for HTTPS MITM. generate: create CA if missing. install: add the CA to your system trust store.",
+	Long: "Manage the proxy's CA certificate used for HTTPS MITM. generate: create CA if missing. rotate: replace it. " +
+		"export: write it as PEM, DER, or PKCS#12. fingerprint: print its SHA-256 fingerprint. install and uninstall: add or remove the CA in your system or an application trust store.",
 }
 
 var caGenerateCmd = &cobra.Command{
@@ -30,28 +42,172 @@ var caGenerateCmd = &cobra.Command{
 	RunE:  runCAGenerate,
 }
 
+var caRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Archive the current CA and generate a new one",
+	Long: "Move the CA and saved host certificates to ca-archive/<time> and generate a new CA with the given options. " +
+		"With --keep-root, only a new intermediate is issued, so clients that trust the root keep working; the root key must be present.",
+	RunE: runCARotate,
+}
+
+var caExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the root CA certificate as PEM, DER, or PKCS#12",
+	Long: "Write the root certificate as pem or der, or the root certificate and key as a password-protected p12, " +
+		"for example to keep the root key offline once host certificates come from an intermediate.",
+	RunE: runCAExport,
+}
+
+var caFingerprintCmd = &cobra.Command{
+	Use:   "fingerprint",
+	Short: "Print the CA's subject, validity, and SHA-256 fingerprint",
+	RunE:  runCAFingerprint,
+}
+
 var caInstallCmd = &cobra.Command{
 	Use:   "install",
-	Short: "Install CA into system trust store",
+	Short: "Install CA into the system or an application trust store",
 	RunE:  runCAInstall,
 }
 
 func init() {
 	caInstallCmd.Flags().StringVar(&caInstallDevice, "device", "", "Install on a connected device: ios or android")
+	for _, c := range []*cobra.Command{caGenerateCmd, caRotateCmd} {
+		c.Flags().StringVar(&caKeyType, "key-type", "ecdsa", "Key algorithm for the CA and host certificates: ecdsa or rsa")
+		c.Flags().IntVar(&caRSABits, "rsa-bits", 2048, "RSA key size")
+		c.Flags().BoolVar(&caIntermediate, "intermediate", false, "Issue host certificates from an intermediate CA so the root key can be kept offline")
+		c.Flags().StringArrayVar(&caNameConstraints, "name-constraint", nil, "Limit the CA to this domain and its subdomains (repeatable)")
+	}
+	caRotateCmd.Flags().BoolVar(&caKeepRoot, "keep-root", false, "Keep the root and issue a new intermediate")
+	caExportCmd.Flags().StringVarP(&caExportFormat, "format", "f", "pem", "Format: pem, der, or p12")
+	caExportCmd.Flags().StringVarP(&caExportOut, "out", "o", "", "Output file (default: snare-ca.<format>)")
+	caExportCmd.Flags().StringVar(&caExportPassword, "password", "", "Password protecting the p12 file (required for p12)")
 	caCmd.AddCommand(caGenerateCmd)
+	caCmd.AddCommand(caRotateCmd)
+	caCmd.AddCommand(caExportCmd)
+	caCmd.AddCommand(caFingerprintCmd)
 	caCmd.AddCommand(caInstallCmd)
 }
 
+func caOptions() cert.Options {
+	return cert.Options{
+		KeyType:          caKeyType,
+		RSABits:          caRSABits,
+		Intermediate:     caIntermediate,
+		PermittedDomains: caNameConstraints,
+	}
+}
+
 func runCAGenerate(cmd *cobra.Command, args []string) error {
 	dir := config.CADir()
-	_, _, err := cert.LoadOrCreateCA(dir)
+	if _, err := os.Stat(filepath.Join(dir, cert.CertFile)); err == nil {
+		if _, err := cert.Load(dir); err != nil {
+			return err
+		}
+		fmt.Println("CA is at", dir, "(use 'snare ca rotate' to replace it)")
+		return nil
+	}
+	ca, err := cert.Create(dir, caOptions())
 	if err != nil {
 		return err
 	}
 	fmt.Println("CA is at", dir)
+	printCA(ca)
+	return nil
+}
+
+func runCARotate(cmd *cobra.Command, args []string) error {
+	dir := config.CADir()
+	if caKeepRoot {
+		ca, err := cert.NewIntermediate(dir, caOptions())
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(dir, cert.LeafDir)); err != nil {
+			return err
+		}
+		fmt.Println("Issued a new intermediate; the root is unchanged.")
+		printCA(ca)
+		return nil
+	}
+	archive, err := cert.Archive(dir)
+	if err != nil {
+		return err
+	}
+	ca, err := cert.Create(dir, caOptions())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Previous CA archived to", archive)
+	printCA(ca)
+	fmt.Println("Restart snare and run 'snare ca install' so clients trust the new root.")
+	return nil
+}
+
+func runCAExport(cmd *cobra.Command, args []string) error {
+	dir := config.CADir()
+	ca, err := cert.Load(dir)
+	if err != nil {
+		return err
+	}
+	out := caExportOut
+	if out == "" {
+		out = "snare-ca." + caExportFormat
+	}
+	var data []byte
+	switch caExportFormat {
+	case "pem":
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Root.Raw})
+	case "der":
+		data = ca.Root.Raw
+	case "p12":
+		if caExportPassword == "" {
+			return fmt.Errorf("p12 export includes the CA key: set a --password to protect it")
+		}
+		key, err := cert.LoadRootKey(dir)
+		if err != nil {
+			return err
+		}
+		data, err = cert.EncodePKCS12(key, []*x509.Certificate{ca.Root}, ca.Root.Subject.CommonName, caExportPassword)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q — use pem, der, or p12", caExportFormat)
+	}
+	mode := os.FileMode(0644)
+	if caExportFormat == "p12" {
+		mode = 0600
+	}
+	if err := os.WriteFile(out, data, mode); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", out)
 	return nil
 }
 
+func runCAFingerprint(cmd *cobra.Command, args []string) error {
+	ca, err := cert.Load(config.CADir())
+	if err != nil {
+		return err
+	}
+	printCA(ca)
+	return nil
+}
+
+func printCA(ca *cert.Authority) {
+	certs := append([]*x509.Certificate{ca.Root}, ca.Chain...)
+	for _, c := range certs {
+		fmt.Printf("%s\n", c.Subject.CommonName)
+		fmt.Printf("  SHA-256:  %s\n", cert.Fingerprint(c))
+		fmt.Printf("  Expires:  %s\n", c.NotAfter.Format("2006-01-02"))
+		fmt.Printf("  Key:      %s\n", c.PublicKeyAlgorithm)
+		if len(c.PermittedDNSDomains) > 0 {
+			fmt.Printf("  Limited:  %s\n", strings.Join(c.PermittedDNSDomains, ", "))
+		}
+	}
+}
+
 func runCAInstall(cmd *cobra.Command, args []string) error {
 	dir := config.CADir()
 	certPath := filepath.Join(dir, cert.CertFile)
@@ -60,6 +216,17 @@ func runCAInstall(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if caTarget != "" {
+		if caInstallDevice != "" {
+			return fmt.Errorf("use --target or --device, not both")
+		}
+		ca, err := cert.Load(dir)
+		if err != nil {
+			return err
+		}
+		return installTarget(caTarget, ca.Root, certPath)
+	}
+
 	switch caInstallDevice {
 	case "android":
 		return installAndroid(certPath)
@@ -106,8 +273,10 @@ func installDarwin(certPath string) error {
 	return nil
 }
 
+const linuxCADest = "/usr/local/share/ca-certificates/snare-ca.crt"
+
 func installLinux(certPath string) error {
-	dest := "/usr/local/share/ca-certificates/snare-ca.crt"
+	dest := linuxCADest
 	fmt.Printf("Copying CA to %s...\n", dest)
 	src, err := os.Open(certPath)
 	if err != nil {
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/muxover/snare/v2/config"
+	"github.com/muxover/snare/v2/proxy/cert"
+	"github.com/spf13/cobra"
+)
+
+// trustAlias names the CA in keystores and NSS databases.
+const trustAlias = "snare"
+
+var caTarget string
+var caKeystore string
+var caStorePass string
+var caProfile string
+var caBundle string
+var caEnvFile string
+
+var caUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the CA from the system or an application trust store",
+	RunE:  runCAUninstall,
+}
+
+// systemBundles are where Linux distributions and macOS keep the system
+// roots as one PEM file.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+func init() {
+	for _, c := range []*cobra.Command{caInstallCmd, caUninstallCmd} {
+		c.Flags().StringVar(&caTarget, "target", "", "Application trust store: java, nss, python, node, or curl")
+		c.Flags().StringVar(&caKeystore, "keystore", "", "JKS keystore for --target java (default: $JAVA_HOME/lib/security/cacerts)")
+		c.Flags().StringVar(&caStorePass, "storepass", "changeit", "Keystore password for --target java")
+		c.Flags().StringVar(&caProfile, "profile", "", "NSS database directory for --target nss, such as a Firefox profile (default: ~/.pki/nssdb)")
+		c.Flags().StringVar(&caBundle, "bundle", "", "CA bundle to extend for --target python or curl (default: certifi's, or the system bundle)")
+		c.Flags().StringVar(&caEnvFile, "env-file", "", "Shell file that --target python, node, and curl write variables to (default: ~/.snare/env.sh)")
+	}
+	caCmd.AddCommand(caUninstallCmd)
+}
+
+func installTarget(target string, root *x509.Certificate, certPath string) error {
+	switch target {
+	case "java":
+		return installJava(root)
+	case "nss":
+		db, err := nssDB()
+		if err != nil {
+			return err
+		}
+		out, err := exec.Command("certutil", "-d", db, "-A", "-t", "C,,", "-n", trustAlias, "-i", certPath).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("certutil failed (install nss-tools / libnss3-tools): %w\n%s", err, out)
+		}
+		fmt.Printf("Added %q to %s. Restart the browser.\n", trustAlias, db)
+		return nil
+	case "python", "curl":
+		src, err := sourceBundle(target)
+		if err != nil {
+			return err
+		}
+		dest := trustBundle(target)
+		if err := writeBundle(src, dest, root); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s (%s plus the snare CA)\n", dest, src)
+		if target == "python" {
+			return setEnv(target, [][2]string{{"REQUESTS_CA_BUNDLE", dest}, {"SSL_CERT_FILE", dest}})
+		}
+		return setEnv(target, [][2]string{{"CURL_CA_BUNDLE", dest}})
+	case "node":
+		return setEnv(target, [][2]string{{"NODE_EXTRA_CA_CERTS", certPath}})
+	default:
+		return fmt.Errorf("unknown target %q — use java, nss, python, node, or curl", target)
+	}
+}
+
+func runCAUninstall(cmd *cobra.Command, args []string) error {
+	ca, err := cert.Load(config.CADir())
+	if err != nil {
+		return err
+	}
+	switch caTarget {
+	case "":
+		return uninstallSystem(ca.Root)
+	case "java":
+		path, err := javaKeystore()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ks, err := cert.ParseJKS(data, caStorePass)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !ks.Remove(trustAlias) {
+			fmt.Printf("%s has no %q entry.\n", path, trustAlias)
+			return nil
+		}
+		if err := writeKeystore(path, ks.Marshal(caStorePass), false); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %q from %s\n", trustAlias, path)
+		if _, err := os.Stat(keystoreBackup(path)); err == nil {
+			fmt.Printf("The keystore from before the install is kept as %s\n", keystoreBackup(path))
+		}
+		return nil
+	case "nss":
+		db, err := nssDB()
+		if err != nil {
+			return err
+		}
+		out, err := exec.Command("certutil", "-d", db, "-D", "-n", trustAlias).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("certutil failed: %w\n%s", err, out)
+		}
+		fmt.Printf("Removed %q from %s\n", trustAlias, db)
+		return nil
+	case "python", "curl":
+		if err := os.Remove(trustBundle(caTarget)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return setEnv(caTarget, nil)
+	case "node":
+		return setEnv(caTarget, nil)
+	default:
+		return fmt.Errorf("unknown target %q — use java, nss, python, node, or curl", caTarget)
+	}
+}
+
+func javaKeystore() (string, error) {
+	if caKeystore != "" {
+		return caKeystore, nil
+	}
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		return filepath.Join(home, "lib", "security", "cacerts"), nil
+	}
+	return "", fmt.Errorf("set --keystore or JAVA_HOME")
+}
+
+func installJava(root *x509.Certificate) error {
+	path, err := javaKeystore()
+	if err != nil {
+		return err
+	}
+	ks, err := readKeystore(path, caStorePass)
+	if err != nil {
+		return fmt.Errorf("%s: %w (for PKCS#12 keystores use keytool -importcert -alias %s -file <ca.pem> -keystore %s)", path, err, trustAlias, path)
+	}
+	backup := !slices.Contains(ks.Aliases(), trustAlias)
+	ks.SetTrustedCert(trustAlias, root)
+	if err := writeKeystore(path, ks.Marshal(caStorePass), backup); err != nil {
+		return err
+	}
+	fmt.Printf("Added %q to %s\n", trustAlias, path)
+	if _, err := os.Stat(keystoreBackup(path)); err == nil {
+		fmt.Printf("The keystore from before the install is kept as %s\n", keystoreBackup(path))
+	}
+	return nil
+}
+
+// readKeystore parses the JKS keystore at path; a missing file is an
+// empty keystore.
+func readKeystore(path, password string) (*cert.JKS, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &cert.JKS{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cert.ParseJKS(data, password)
+}
+
+// keystoreBackup is where installJava keeps the keystore as it was before
+// the CA was added.
+func keystoreBackup(path string) string {
+	return path + ".snare-backup"
+}
+
+// writeKeystore replaces path, keeping its permissions. The new keystore is
+// written next to it and renamed over it, so a failed write never leaves a
+// truncated cacerts. With backup set, the current keystore is first copied
+// to keystoreBackup.
+func writeKeystore(path string, data []byte, backup bool) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		if backup {
+			old, err := os.ReadFile(path)
+			if err == nil {
+				err = os.WriteFile(keystoreBackup(path), old, mode)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot back up %s (try sudo): %w", path, err)
+			}
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot write %s (try sudo): %w", path, err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write %s (try sudo): %w", path, err)
+	}
+	return nil
+}
+
+func nssDB() (string, error) {
+	dir := caProfile
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".pki", "nssdb")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cert9.db")); err != nil {
+		return "", fmt.Errorf("no cert9.db in %s; pass a Firefox profile or NSS directory with --profile", dir)
+	}
+	return "sql:" + dir, nil
+}
+
+// sourceBundle is the bundle --target python or curl extends: --bundle,
+// certifi's for python, or the system bundle.
+func sourceBundle(target string) (string, error) {
+	if caBundle != "" {
+		return caBundle, nil
+	}
+	if target == "python" {
+		out, err := exec.Command("python3", "-c", "import certifi; print(certifi.where())").Output()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	if p := systemBundle(); p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("no CA bundle found; pass one with --bundle")
+}
+
+// writeBundle writes the PEM bundle src with root appended to dest. An
+// empty src writes root alone.
+func writeBundle(src, dest string, root *x509.Certificate) error {
+	var data []byte
+	if src != "" {
+		roots, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		data = append(roots, '\n')
+	}
+	data = append(data, fmt.Sprintf("# %s\n", root.Subject.CommonName)...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})...)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, data, 0644)
+}
+
+// systemBundle returns the first system bundle that exists, or "".
+func systemBundle() string {
+	for _, p := range systemBundles {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+func trustBundle(target string) string {
+	return filepath.Join(config.CADir(), "trust", target+"-cacert.pem")
+}
+
+func envFile() string {
+	if caEnvFile != "" {
+		return caEnvFile
+	}
+	return filepath.Join(config.CADir(), "env.sh")
+}
+
+// setEnv replaces target's lines in the env file with exports of vars;
+// nil vars removes them. Each line is tagged with its target.
+func setEnv(target string, vars [][2]string) error {
+	path := envFile()
+	tag := " # snare:" + target
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" && !strings.HasSuffix(line, tag) {
+			lines = append(lines, line)
+		}
+	}
+	for _, kv := range vars {
+		lines = append(lines, fmt.Sprintf("export %s=%s%s", kv[0], shellQuote(kv[1]), tag))
+	}
+	if len(lines) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+		err = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if vars == nil {
+		fmt.Printf("Removed %s settings from %s; open a new shell to drop them.\n", target, path)
+		return nil
+	}
+	fmt.Printf("Wrote %s settings to %s. Load them with:\n  source %s\n", target, path, path)
+	return nil
+}
+
+// uninstallSystem removes root from the system store. Certificates are
+// named by their SHA-1 hash, since other CAs may share the common name.
+func uninstallSystem(root *x509.Certificate) error {
+	sum := sha1.Sum(root.Raw)
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	switch runtime.GOOS {
+	case "windows":
+		out, err := exec.Command("certutil", "-delstore", "Root", hash).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("certutil failed: %w\n%s", err, out)
+		}
+	case "darwin":
+		out, err := exec.Command("sudo", "security", "delete-certificate", "-Z", hash, "/Library/Keychains/System.keychain").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("security command failed: %w\n%s", err, out)
+		}
+	case "linux":
+		if err := os.Remove(linuxCADest); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove %s (try sudo): %w", linuxCADest, err)
+		}
+		out, err := exec.Command("update-ca-certificates", "--fresh").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("update-ca-certificates failed: %w\n%s", err, out)
+		}
+	default:
+		fmt.Println("Unsupported OS. Remove the CA manually:", root.Subject.CommonName)
+		return nil
+	}
+	fmt.Println("Removed.")
+	return nil
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/config"
@@ -10,12 +9,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var (
-	clearMethod string
-	clearStatus int
-	clearURL    string
-	clearHost   string
-)
+var clearQuery queryFlags
 
 var clearCmd = &cobra.Command{
 	Use:   "clear",
@@ -25,25 +19,27 @@ var clearCmd = &cobra.Command{
 }
 
 func init() {
-	clearCmd.Flags().StringVar(&clearMethod, "method", "", "Delete only captures with this HTTP method")
-	clearCmd.Flags().IntVar(&clearStatus, "status", 0, "Delete only captures with this response status code")
-	clearCmd.Flags().StringVar(&clearURL, "url", "", "Delete only captures whose URL contains this substring")
-	clearCmd.Flags().StringVar(&clearHost, "host", "", "Delete only captures matching this host")
+	clearQuery.register(clearCmd, "method", "status", "url", "host")
 }
 
 func runClear(cmd *cobra.Command, args []string) error {
-	hasFilter := clearMethod != "" || clearStatus != 0 || clearURL != "" || clearHost != ""
+	q, err := clearQuery.compile()
+	if err != nil {
+		return err
+	}
 
 	store := capture.NewStore(0, config.StoreDir())
 
-	if !hasFilter {
+	if q.Empty() {
 		store.Clear(true)
 		fmt.Println("Cleared all captures.")
 		return nil
 	}
 
-	all := store.AllFromDisk()
-	matches := filterCaptures(all, clearMethod, clearStatus, clearURL, clearHost, "", "", time.Time{}, time.Time{}, 0)
+	matches, _, err := clearQuery.find(store, 0)
+	if err != nil {
+		return err
+	}
 	if len(matches) == 0 {
 		fmt.Println("No captures matched the filter.")
 		return nil
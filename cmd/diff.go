@@ -63,8 +63,8 @@ func runDiff(cmd *cobra.Command, args []string) error {
 
 func runCaptureDiff(idA, idB string) error {
 	store := capture.NewStore(0, config.StoreDir())
-	a := store.GetByPrefix(idA)
-	b := store.GetByPrefix(idB)
+	a := store.WithBodies(store.GetByPrefix(idA))
+	b := store.WithBodies(store.GetByPrefix(idB))
 	if a == nil {
 		return fmt.Errorf("capture not found: %s", idA)
 	}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"strings"
@@ -12,28 +13,37 @@ import (
 
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/config"
+	"github.com/muxover/snare/v2/pcapng"
 
 	"github.com/spf13/cobra"
 )
 
 var exportFormat string
 var exportLast int
+var exportQuery queryFlags
 
 var exportCmd = &cobra.Command{
 	Use:   "export",
-	Short: "Export captures to HAR, JSON, Postman collection, or bundle",
-	Long:  "Export the last N captures to a single file. Format: json (default), har, postman, or bundle.",
+	Short: "Export captures to HAR, JSON, Postman collection, bundle, or pcapng",
+	Long:  "Export the last N captures to a single file. Format: json (default), har, postman, bundle, or pcapng. Filter with --tag or --query.",
 	RunE:  runExport,
 }
 
 func init() {
-	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Format: json, har, postman, or bundle")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Format: json, har, postman, bundle, or pcapng")
 	exportCmd.Flags().IntVarP(&exportLast, "last", "n", 50, "")
+	exportQuery.register(exportCmd, "tag")
 }
 
 func runExport(cmd *cobra.Command, args []string) error {
 	store := capture.NewStore(0, config.StoreDir())
-	captures := store.ListFromDisk(exportLast)
+	captures, _, err := exportQuery.find(store, exportLast)
+	if err != nil {
+		return err
+	}
+	for i, c := range captures {
+		captures[i] = store.WithBodies(c)
+	}
 	if len(captures) == 0 {
 		fmt.Println("No captures to export.")
 		return nil
@@ -43,6 +53,7 @@ func runExport(cmd *cobra.Command, args []string) error {
 		bundlePackOut = "export.snare"
 		bundlePackSession = ""
 		bundlePackIDs = ""
+		bundlePackQuery = exportQuery
 		return runBundlePack(nil, nil)
 	case "har":
 		out := "export.har"
@@ -60,6 +71,16 @@ func runExport(cmd *cobra.Command, args []string) error {
 			return err
 		}
 		return os.WriteFile(out, data, 0644)
+	case "pcapng":
+		f, err := os.Create("export.pcapng")
+		if err != nil {
+			return err
+		}
+		if err := pcapng.WriteCaptures(f, captures); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
 	default:
 		out := "export.json"
 		data, err := json.MarshalIndent(captures, "", "  ")
@@ -90,6 +111,22 @@ func buildHAR(captures []*capture.Capture) map[string]interface{} {
 				"bodySize": len(c.Response.Body),
 			}
 		}
+		ent["timings"] = timingsHAR(c)
+		if c.Timings != nil {
+			ent["time"] = float64(c.Timings.Total().Microseconds()) / 1000
+		}
+		if t := c.Timings; t != nil && t.RemoteAddr != "" {
+			if ip, _, err := net.SplitHostPort(t.RemoteAddr); err == nil {
+				ent["serverIPAddress"] = ip
+			}
+		}
+		// HAR's connection is the client-side port of the TCP connection,
+		// which for snare is its own end of the connection to the origin.
+		if conn := c.Conn; conn != nil && conn.OriginLocal != "" {
+			if _, port, err := net.SplitHostPort(conn.OriginLocal); err == nil {
+				ent["connection"] = port
+			}
+		}
 		if c.WebSocket != nil && len(c.WebSocket.Frames) > 0 {
 			ent["_webSocketMessages"] = websocketMessagesHAR(c.WebSocket.Frames)
 		}
@@ -104,6 +141,35 @@ func buildHAR(captures []*capture.Capture) map[string]interface{} {
 	}
 }
 
+// timingsHAR fills the HAR timings object. Phases that did not happen are
+// -1, as the format asks; captures without Timings put their whole
+// duration under wait. HAR's connect includes the TLS handshake.
+func timingsHAR(c *capture.Capture) map[string]float64 {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	opt := func(d time.Duration) float64 {
+		if d == 0 {
+			return -1
+		}
+		return ms(d)
+	}
+	t := c.Timings
+	if t == nil {
+		return map[string]float64{
+			"blocked": -1, "dns": -1, "connect": -1, "ssl": -1,
+			"send": 0, "wait": ms(c.Duration), "receive": 0,
+		}
+	}
+	return map[string]float64{
+		"blocked": opt(t.Blocked),
+		"dns":     opt(t.DNS),
+		"connect": opt(t.Connect + t.TLS),
+		"ssl":     opt(t.TLS),
+		"send":    ms(t.Send),
+		"wait":    ms(t.Wait),
+		"receive": ms(t.Receive),
+	}
+}
+
 func headersToHAR(h map[string][]string) []map[string]string {
 	var out []map[string]string
 	for k, v := range h {
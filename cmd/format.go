@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
@@ -92,5 +93,11 @@ func printCaptureLine(c *capture.Capture) {
 		}(),
 		c.Request.URL,
 	)
+	if c.Starred {
+		line = colorYellow.Render("★") + " " + line
+	}
+	if len(c.Tags) > 0 {
+		line += "  " + colorCyan.Render("#"+strings.Join(c.Tags, " #"))
+	}
 	fmt.Println(line)
 }
@@ -51,7 +51,7 @@ type fuzzVariant struct {
 
 func runFuzz(cmd *cobra.Command, args []string) error {
 	store := capture.NewStore(0, config.StoreDir())
-	c := store.GetByPrefix(args[0])
+	c := store.WithBodies(store.GetByPrefix(args[0]))
 	if c == nil {
 		return fmt.Errorf("capture not found: %s", args[0])
 	}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/charmbracelet/lipgloss"
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/config"
 
@@ -12,53 +13,117 @@ import (
 )
 
 var (
-	grepInvert bool
-	grepMethod string
-	grepHost   string
+	grepInvert    bool
+	grepRegex     bool
+	grepSubstring bool
+	grepLimit     int
+	grepQuery     queryFlags
 )
 
 var grepCmd = &cobra.Command{
 	Use:   "grep <pattern>",
-	Short: "Search capture bodies for a pattern",
-	Long:  "Search request and response bodies across all captures using a regular expression. Prints one line per matching capture.",
+	Short: "Search captures for words or a pattern",
+	Long:  "Search URLs, headers, request and response bodies, and WebSocket and SSE payloads using the store's full-text index. Hits are ranked by relevance and shown with highlighted excerpts; with --substring, or when the index finds nothing, matches inside longer words (such as token in access_token) follow them, found by a slower scan. Patterns containing regular expression syntax, --regex and --invert scan request and response bodies with a regular expression instead. Narrow the captures searched with --query.",
 	Args:  cobra.ExactArgs(1),
 	RunE:  runGrep,
 }
 
 func init() {
-	grepCmd.Flags().BoolVarP(&grepInvert, "invert", "v", false, "Print captures that do NOT match the pattern")
-	grepCmd.Flags().StringVar(&grepMethod, "method", "", "Limit to this HTTP method")
-	grepCmd.Flags().StringVar(&grepHost, "host", "", "Limit to this host")
+	grepCmd.Flags().BoolVarP(&grepInvert, "invert", "v", false, "Print captures that do NOT match the pattern (implies --regex)")
+	grepCmd.Flags().BoolVarP(&grepRegex, "regex", "E", false, "Match the pattern as a regular expression against bodies instead of using the index")
+	grepCmd.Flags().BoolVar(&grepSubstring, "substring", false, "Also scan every capture for the pattern inside longer words")
+	grepCmd.Flags().IntVar(&grepLimit, "limit", 0, "Show at most this many hits (0 = all)")
+	grepQuery.register(grepCmd, "method", "host")
 }
 
+var colorMatch = lipgloss.NewStyle().Foreground(lipgloss.Color("3")).Bold(true)
+
 func runGrep(cmd *cobra.Command, args []string) error {
-	re, err := regexp.Compile(args[0])
+	pattern := args[0]
+	if grepRegex || grepInvert || regexp.QuoteMeta(pattern) != pattern || len(capture.SearchTerms(pattern)) == 0 {
+		return runGrepRegex(pattern)
+	}
+	q, err := grepQuery.compile()
 	if err != nil {
-		return fmt.Errorf("invalid pattern: %w", err)
+		return err
 	}
-
 	store := capture.NewStore(0, config.StoreDir())
-	all := store.AllFromDisk()
-
+	q = q.WithBodies(store.SearchBodies)
+	hits, err := store.Search(pattern, 0)
+	if err != nil {
+		return fmt.Errorf("search index: %w", err)
+	}
+	// The index matches whole words only; scan for the pattern inside
+	// longer ones after the ranked hits when asked, or when there are none.
+	if grepSubstring || len(hits) == 0 {
+		seen := make(map[string]bool, len(hits))
+		for _, h := range hits {
+			seen[h.Capture.ID] = true
+		}
+		hits = append(hits, store.Scan(pattern, seen)...)
+	}
 	count := 0
-	for _, c := range all {
-		if grepMethod != "" && !strings.EqualFold(c.Request.Method, grepMethod) {
+	for _, h := range hits {
+		if !q.Match(h.Capture) {
 			continue
 		}
-		if grepHost != "" {
-			host := hostFromURL(c.Request.URL)
-			if !strings.EqualFold(host, grepHost) {
-				continue
-			}
+		printCaptureLine(h.Capture)
+		for _, hl := range h.Highlights {
+			fmt.Printf("    %s  %s\n", colorFaint.Render(fmt.Sprintf("%-11s", hl.Field)), renderHighlight(hl))
+		}
+		count++
+		if grepLimit > 0 && count >= grepLimit {
+			break
+		}
+	}
+	if count == 0 {
+		fmt.Println("no matches")
+	}
+	return nil
+}
+
+func renderHighlight(h capture.Highlight) string {
+	flat := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
 		}
+		return r
+	}, h.Snippet)
+	var sb strings.Builder
+	last := 0
+	for _, m := range h.Matches {
+		sb.WriteString(flat[last:m[0]])
+		sb.WriteString(colorMatch.Render(flat[m[0]:m[1]]))
+		last = m[1]
+	}
+	sb.WriteString(flat[last:])
+	return sb.String()
+}
+
+func runGrepRegex(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
 
-		matched := bodyMatches(re, c)
+	store := capture.NewStore(0, config.StoreDir())
+	all, _, err := grepQuery.find(store, 0)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, c := range all {
+		matched := bodyMatches(re, store.SearchBodies(c))
 		if grepInvert {
 			matched = !matched
 		}
 		if matched {
 			printCaptureLine(c)
 			count++
+			if grepLimit > 0 && count >= grepLimit {
+				break
+			}
 		}
 	}
 
@@ -77,17 +142,3 @@ func bodyMatches(re *regexp.Regexp, c *capture.Capture) bool {
 	}
 	return false
 }
-
-func hostFromURL(rawURL string) string {
-	if idx := strings.Index(rawURL, "://"); idx >= 0 {
-		rest := rawURL[idx+3:]
-		if end := strings.IndexAny(rest, "/?#"); end >= 0 {
-			return rest[:end]
-		}
-		return rest
-	}
-	if idx := strings.IndexByte(rawURL, '/'); idx >= 0 {
-		return rawURL[:idx]
-	}
-	return rawURL
-}
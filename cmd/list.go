@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"net/url"
-	"strings"
-	"time"
 
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/config"
@@ -13,75 +10,28 @@ import (
 )
 
 var (
-	listLast      int
-	listMethod    string
-	listStatus    int
-	listURL       string
-	listHost      string
-	listSince     string
-	listUntil     string
-	listBody      string
-	listOperation string
-	listSlow      int
+	listLast  int
+	listQuery queryFlags
 )
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List recent captures",
-	Long:  "List captures from the store directory (same as used by serve). Reads from disk so it works even when the proxy is not running. Filters: --method, --status, --url, --host, --since, --until, --body.",
+	Long:  "List captures from the store directory (same as used by serve). Reads from disk so it works even when the proxy is not running. Filter with --query or the --method, --status, --url, --host, --since, --until, --body shorthands.",
 	RunE:  runList,
 }
 
 func init() {
 	listCmd.Flags().IntVarP(&listLast, "last", "n", 20, "")
-	listCmd.Flags().StringVar(&listMethod, "method", "", "Filter by HTTP method (e.g. GET, POST)")
-	listCmd.Flags().IntVar(&listStatus, "status", 0, "Filter by response status code (e.g. 200, 404)")
-	listCmd.Flags().StringVar(&listURL, "url", "", "Filter by URL substring")
-	listCmd.Flags().StringVar(&listHost, "host", "", "Filter by host (URL host part)")
-	listCmd.Flags().StringVar(&listSince, "since", "", "Include captures at or after this time (RFC3339 or 2006-01-02)")
-	listCmd.Flags().StringVar(&listUntil, "until", "", "Include captures at or before this time (RFC3339 or 2006-01-02)")
-	listCmd.Flags().StringVar(&listBody, "body", "", "Filter by substring in request or response body")
-	listCmd.Flags().StringVar(&listOperation, "operation", "", "Filter by GraphQL operation name")
-	listCmd.Flags().IntVar(&listSlow, "slow", 0, "Show only captures slower than N milliseconds")
+	listQuery.register(listCmd, "method", "status", "url", "host", "since", "until", "body", "operation", "slow", "tag")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	since, err := parseSinceFlag(listSince)
-	if err != nil {
-		return err
-	}
-	until, err := parseUntilFlag(listUntil)
+	store := capture.NewStore(0, config.StoreDir())
+	captures, _, err := listQuery.find(store, listLast)
 	if err != nil {
 		return err
 	}
-	if !since.IsZero() && !until.IsZero() && until.Before(since) {
-		return fmt.Errorf("--until must be on or after --since")
-	}
-
-	store := capture.NewStore(0, config.StoreDir())
-
-	hasFilter := listMethod != "" || listStatus != 0 || listURL != "" || listHost != "" ||
-		listSince != "" || listUntil != "" || listBody != "" || listOperation != "" || listSlow > 0
-	scanAll := listSince != "" || listUntil != "" || listBody != "" || listOperation != "" || listSlow > 0
-
-	var captures []*capture.Capture
-	if scanAll {
-		captures = store.AllFromDisk()
-	} else {
-		loadN := listLast
-		if loadN <= 0 {
-			loadN = 100
-		}
-		if hasFilter {
-			loadN = 500
-		}
-		captures = store.ListFromDisk(loadN)
-	}
-
-	captures = filterCaptures(captures, listMethod, listStatus, listURL, listHost, listBody, listOperation, since, until, listSlow)
-	if listLast > 0 && len(captures) > listLast {
-		captures = captures[:listLast]
-	}
 
 	if len(captures) == 0 {
 		storeDir := config.StoreDir()
@@ -97,49 +47,3 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 	return nil
 }
-
-func filterCaptures(captures []*capture.Capture, method string, status int, urlSub, host, bodySub, operation string, since, until time.Time, slowMs int) []*capture.Capture {
-	var out []*capture.Capture
-	for _, c := range captures {
-		if method != "" && c.Request.Method != method {
-			continue
-		}
-		if status != 0 {
-			if c.Response == nil || c.Response.StatusCode != status {
-				continue
-			}
-		}
-		if urlSub != "" && !strings.Contains(c.Request.URL, urlSub) {
-			continue
-		}
-		if host != "" {
-			u, err := url.Parse(c.Request.URL)
-			if err != nil || !strings.Contains(u.Host, host) {
-				continue
-			}
-		}
-		if !since.IsZero() && c.Timestamp.Before(since) {
-			continue
-		}
-		if !until.IsZero() && c.Timestamp.After(until) {
-			continue
-		}
-		if bodySub != "" {
-			inReq := strings.Contains(string(c.Request.Body), bodySub)
-			inResp := c.Response != nil && strings.Contains(string(c.Response.Body), bodySub)
-			if !inReq && !inResp {
-				continue
-			}
-		}
-		if operation != "" {
-			if c.GraphQL == nil || !strings.EqualFold(c.GraphQL.OperationName, operation) {
-				continue
-			}
-		}
-		if slowMs > 0 && c.Duration < time.Duration(slowMs)*time.Millisecond {
-			continue
-		}
-		out = append(out, c)
-	}
-	return out
-}
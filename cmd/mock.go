@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/muxover/snare/v2/capture"
@@ -14,7 +18,7 @@ import (
 var mockCmd = &cobra.Command{
 	Use:   "mock",
 	Short: "Manage mock rules",
-	Long:  "Add, list, remove, and generate mock rules. Rules are matched in order; first match wins.",
+	Long:  "Add, list, remove, and generate mock rules. The matching rule with the highest --priority wins; among equal priorities, the first added.",
 }
 
 var (
@@ -25,13 +29,32 @@ var (
 	mockAddContentType string
 	mockAddHeader      []string
 	mockAddName        string
+	mockAddMatch       string
+	mockFromMatch      string
+	mockQuery          []string
+	mockReqHeader      []string
+	mockJSON           []string
+	mockPriority       int
+	mockAddTemplate    bool
+	mockAddStatusTmpl  string
+	mockAddScenario    string
+	mockAddWhenState   string
+	mockAddSetState    string
+	mockAddSequence    []string
+	mockAddSeqMode     string
+	mockAddLatency     time.Duration
+	mockAddJitter      time.Duration
+	mockAddThrottle    int
+	mockAddFault       string
+	mockAddFaultAfter  int
 )
 
 var mockAddCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a mock rule",
-	Long:  "Add a rule that intercepts matching requests and returns a fixed response. Use --method and --url to match; --status, --body, --content-type, --header to define the response.",
-	RunE:  runMockAdd,
+	Long: "Add a rule that intercepts matching requests and returns a fixed response. Use --method, --url, and --match to match the URL, " +
+		"--query, --req-header, and --json to match query parameters, headers, and JSON body fields; --status, --body, --content-type, --header to define the response.",
+	RunE: runMockAdd,
 }
 
 var mockListCmd = &cobra.Command{
@@ -50,9 +73,10 @@ var mockRemoveCmd = &cobra.Command{
 var mockFromCmd = &cobra.Command{
 	Use:   "from [capture-id]",
 	Short: "Generate a mock rule from a captured response",
-	Long:  "Load a capture by ID and create a mock rule that replays its response for matching requests.",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runMockFrom,
+	Long: "Load a capture by ID and create a mock rule that replays its response for matching requests. By default the rule matches the captured URL exactly; " +
+		"--match path turns numeric and UUID path segments into parameters, so /users/42 becomes /users/{id}.",
+	Args: cobra.ExactArgs(1),
+	RunE: runMockFrom,
 }
 
 var mockClearCmd = &cobra.Command{
@@ -63,13 +87,33 @@ var mockClearCmd = &cobra.Command{
 
 func init() {
 	mockAddCmd.Flags().StringVar(&mockAddMethod, "method", "", "HTTP method to match (empty = any)")
-	mockAddCmd.Flags().StringVar(&mockAddURL, "url", "", "URL substring to match (required)")
+	mockAddCmd.Flags().StringVar(&mockAddURL, "url", "", "URL pattern to match (required)")
+	mockAddCmd.Flags().StringVar(&mockAddMatch, "match", mock.MatchContains, "How --url matches: contains, exact, glob, regex, or path (a template such as /users/{id})")
 	mockAddCmd.Flags().IntVar(&mockAddStatus, "status", 200, "")
 	mockAddCmd.Flags().StringVar(&mockAddBody, "body", "", "")
 	mockAddCmd.Flags().StringVar(&mockAddContentType, "content-type", "application/json", "")
 	mockAddCmd.Flags().StringArrayVar(&mockAddHeader, "header", nil, "Extra response header (Key: Value); repeatable")
 	mockAddCmd.Flags().StringVar(&mockAddName, "name", "", "label for this rule")
+	mockAddCmd.Flags().BoolVar(&mockAddTemplate, "template", false, "Render --body and --header values as templates over the request (see README)")
+	mockAddCmd.Flags().StringVar(&mockAddStatusTmpl, "status-template", "", "Status code template, such as '{{if .Query.id}}200{{else}}404{{end}}' (implies --template)")
+	mockAddCmd.Flags().StringVar(&mockAddScenario, "scenario", "", "Scenario (named state machine) this rule belongs to")
+	mockAddCmd.Flags().StringVar(&mockAddWhenState, "when-state", "", "Only match while the scenario is in this state (scenarios start in \""+mock.StartState+"\")")
+	mockAddCmd.Flags().StringVar(&mockAddSetState, "set-state", "", "Move the scenario to this state when the rule matches")
+	mockAddCmd.Flags().StringArrayVar(&mockAddSequence, "sequence", nil, "Response for the next match, as STATUS, STATUS:BODY, or close, reset, or malformed (repeatable, served in order)")
+	mockAddCmd.Flags().StringVar(&mockAddSeqMode, "sequence-mode", mock.SequenceLast, "After the last --sequence response: last (repeat it) or cycle")
+	mockAddCmd.Flags().DurationVar(&mockAddLatency, "latency", 0, "Wait this long before responding, e.g. 500ms")
+	mockAddCmd.Flags().DurationVar(&mockAddJitter, "jitter", 0, "Add up to this much random latency")
+	mockAddCmd.Flags().IntVar(&mockAddThrottle, "throttle", 0, "Send the body at this many bytes per second")
+	mockAddCmd.Flags().StringVar(&mockAddFault, "fault", "", "Break the response: close (mid-body), reset (TCP RST), or malformed")
+	mockAddCmd.Flags().IntVar(&mockAddFaultAfter, "fault-after", 0, "Body bytes sent before --fault close cuts the connection (default: half)")
 	_ = mockAddCmd.MarkFlagRequired("url")
+	mockFromCmd.Flags().StringVar(&mockFromMatch, "match", mock.MatchExact, "How the captured URL matches: exact, contains, or path")
+	for _, c := range []*cobra.Command{mockAddCmd, mockFromCmd} {
+		c.Flags().StringArrayVar(&mockQuery, "query", nil, "Query parameter to match: name, name=value, or name~=regex (repeatable)")
+		c.Flags().StringArrayVar(&mockReqHeader, "req-header", nil, "Request header to match: name, name=value, or name~=regex (repeatable)")
+		c.Flags().StringArrayVar(&mockJSON, "json", nil, "JSON body field to match: $.path, $.path=value, or $.path~=regex (repeatable)")
+		c.Flags().IntVar(&mockPriority, "priority", 0, "Rules with higher priority are tried first")
+	}
 
 	mockCmd.AddCommand(mockAddCmd)
 	mockCmd.AddCommand(mockListCmd)
@@ -92,14 +136,40 @@ func runMockAdd(cmd *cobra.Command, args []string) error {
 		hmap[h.Key] = h.Value
 	}
 	rule := &mock.Rule{
-		ID:          uuid.NewString(),
-		Name:        mockAddName,
-		Method:      strings.ToUpper(mockAddMethod),
-		URLPattern:  mockAddURL,
-		Status:      mockAddStatus,
-		Body:        mockAddBody,
-		ContentType: mockAddContentType,
-		Headers:     hmap,
+		ID:             uuid.NewString(),
+		Name:           mockAddName,
+		Method:         strings.ToUpper(mockAddMethod),
+		URLPattern:     mockAddURL,
+		MatchType:      mockAddMatch,
+		Status:         mockAddStatus,
+		Body:           mockAddBody,
+		ContentType:    mockAddContentType,
+		Headers:        hmap,
+		Template:       mockAddTemplate || mockAddStatusTmpl != "",
+		StatusTemplate: mockAddStatusTmpl,
+		Scenario:       mockAddScenario,
+		RequiredState:  mockAddWhenState,
+		NewState:       mockAddSetState,
+		SequenceMode:   mockAddSeqMode,
+	}
+	if mockAddLatency > 0 || mockAddJitter > 0 || mockAddThrottle > 0 || mockAddFault != "" {
+		rule.Fault = &mock.Fault{BytesPerSecond: mockAddThrottle, Abort: mockAddFault, AbortAfter: mockAddFaultAfter}
+		if mockAddLatency > 0 {
+			rule.Fault.Delay = mockAddLatency.String()
+		}
+		if mockAddJitter > 0 {
+			rule.Fault.Jitter = mockAddJitter.String()
+		}
+	}
+	for _, spec := range mockAddSequence {
+		step, err := parseStep(spec)
+		if err != nil {
+			return fmt.Errorf("--sequence: %w", err)
+		}
+		rule.Responses = append(rule.Responses, step)
+	}
+	if err := setMatchers(rule); err != nil {
+		return err
 	}
 	if err := mockStore().Add(rule); err != nil {
 		return err
@@ -109,11 +179,13 @@ func runMockAdd(cmd *cobra.Command, args []string) error {
 }
 
 func runMockList(cmd *cobra.Command, args []string) error {
-	rules := mockStore().Rules()
+	store := mockStore()
+	rules := store.Rules()
 	if len(rules) == 0 {
 		fmt.Println("No mock rules.")
 		return nil
 	}
+	hits := store.Hits()
 	for _, r := range rules {
 		method := r.Method
 		if method == "" {
@@ -127,7 +199,13 @@ func runMockList(cmd *cobra.Command, args []string) error {
 		if len(short) > 8 {
 			short = short[:8]
 		}
-		fmt.Printf("%s  %-7s  %3d  %s%s\n", short, method, r.Status, r.URLPattern, name)
+		if r.Template {
+			name += " [template]"
+		}
+		if h, ok := hits[r.ID]; ok && h.Count > 0 {
+			name += fmt.Sprintf("  hits:%d last:%s", h.Count, h.Last.Local().Format("15:04:05"))
+		}
+		fmt.Printf("%s  %-7s  %3d  %s%s\n", short, method, r.Status, describeMatch(r), name)
 	}
 	return nil
 }
@@ -159,17 +237,144 @@ func runMockFrom(cmd *cobra.Command, args []string) error {
 		ID:          uuid.NewString(),
 		Method:      c.Request.Method,
 		URLPattern:  c.Request.URL,
+		MatchType:   mockFromMatch,
 		Status:      c.Response.StatusCode,
 		Body:        string(c.Response.Body),
 		ContentType: ct,
 	}
+	switch mockFromMatch {
+	case mock.MatchExact, mock.MatchContains:
+	case mock.MatchPath:
+		rule.URLPattern = pathTemplate(c.Request.URL)
+	default:
+		return fmt.Errorf("--match must be exact, contains, or path")
+	}
+	if err := setMatchers(rule); err != nil {
+		return err
+	}
 	if err := mockStore().Add(rule); err != nil {
 		return err
 	}
-	fmt.Printf("Added mock rule %s from capture %s\n", rule.ID[:8], args[0])
+	fmt.Printf("Added mock rule %s from capture %s: %s\n", rule.ID[:8], args[0], describeMatch(rule))
 	return nil
 }
 
+// setMatchers adds the --query, --req-header, --json, and --priority flags
+// to rule and validates it.
+func setMatchers(rule *mock.Rule) error {
+	for _, f := range []struct {
+		flag string
+		args []string
+		dst  *[]mock.Matcher
+	}{
+		{"--query", mockQuery, &rule.MatchQuery},
+		{"--req-header", mockReqHeader, &rule.MatchHeaders},
+		{"--json", mockJSON, &rule.MatchJSON},
+	} {
+		for _, a := range f.args {
+			m, err := mock.ParseMatcher(a)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.flag, err)
+			}
+			*f.dst = append(*f.dst, m)
+		}
+	}
+	rule.Priority = mockPriority
+	return rule.Validate()
+}
+
+// describeMatch summarises what a rule matches for mock list.
+func describeMatch(r *mock.Rule) string {
+	s := r.URLPattern
+	if r.MatchType != "" && r.MatchType != mock.MatchContains {
+		s = r.MatchType + ":" + s
+	}
+	for _, m := range r.MatchQuery {
+		s += " query:" + m.String()
+	}
+	for _, m := range r.MatchHeaders {
+		s += " header:" + m.String()
+	}
+	for _, m := range r.MatchJSON {
+		s += " json:" + m.String()
+	}
+	if r.Priority != 0 {
+		s += fmt.Sprintf(" priority:%d", r.Priority)
+	}
+	if r.Scenario != "" {
+		s += " scenario:" + r.Scenario
+		if r.RequiredState != "" {
+			s += " when:" + r.RequiredState
+		}
+		if r.NewState != "" {
+			s += " then:" + r.NewState
+		}
+	}
+	if n := len(r.Responses); n > 0 {
+		mode := r.SequenceMode
+		if mode == "" {
+			mode = mock.SequenceLast
+		}
+		s += fmt.Sprintf(" sequence:%d,%s", n, mode)
+	}
+	if f := r.Fault; f != nil {
+		if f.Delay != "" {
+			s += " latency:" + f.Delay
+		}
+		if f.Jitter != "" {
+			s += " jitter:" + f.Jitter
+		}
+		if f.BytesPerSecond > 0 {
+			s += fmt.Sprintf(" throttle:%dB/s", f.BytesPerSecond)
+		}
+		if f.Abort != "" {
+			s += " fault:" + f.Abort
+		}
+	}
+	return s
+}
+
+// parseStep parses a --sequence response: STATUS, STATUS:BODY, or a fault
+// (close, reset, or malformed).
+func parseStep(spec string) (mock.Step, error) {
+	switch spec {
+	case mock.AbortClose, mock.AbortReset, mock.AbortMalformed:
+		return mock.Step{Fault: &mock.Fault{Abort: spec}}, nil
+	}
+	code, body, _ := strings.Cut(spec, ":")
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 100 || status > 999 {
+		return mock.Step{}, fmt.Errorf("%q: want STATUS, STATUS:BODY, close, reset, or malformed", spec)
+	}
+	return mock.Step{Status: status, Body: body}, nil
+}
+
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{24,})$`)
+
+// pathTemplate makes a path template of a captured URL, without its
+// query: numeric, UUID, and long hex segments become {id}, {id2}, and so on.
+func pathTemplate(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	segs := strings.Split(u.EscapedPath(), "/")
+	n := 0
+	for i, seg := range segs {
+		if idSegment.MatchString(seg) {
+			n++
+			segs[i] = "{id}"
+			if n > 1 {
+				segs[i] = fmt.Sprintf("{id%d}", n)
+			}
+		}
+	}
+	if u.Host == "" {
+		return strings.Join(segs, "/")
+	}
+	return u.Scheme + "://" + u.Host + strings.Join(segs, "/")
+}
+
 func runMockClear(cmd *cobra.Command, args []string) error {
 	if err := mockStore().Clear(); err != nil {
 		return err
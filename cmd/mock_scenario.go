@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+var mockScenarioCmd = &cobra.Command{
+	Use:   "scenario",
+	Short: "Show and drive mock scenarios",
+	Long:  "Scenarios are named state machines shared by mock rules (--scenario, --when-state, --set-state). Changes apply to a running snare serve immediately.",
+}
+
+var mockScenarioListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List scenarios and their current state",
+	RunE:  runMockScenarioList,
+}
+
+var mockScenarioResetCmd = &cobra.Command{
+	Use:   "reset [name...]",
+	Short: "Return scenarios to their start state and restart their response sequences (all when no name is given)",
+	RunE:  runMockScenarioReset,
+}
+
+var mockScenarioSetCmd = &cobra.Command{
+	Use:   "set <name> <state>",
+	Short: "Move a scenario to a state",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runMockScenarioSet,
+}
+
+func init() {
+	mockScenarioCmd.AddCommand(mockScenarioListCmd)
+	mockScenarioCmd.AddCommand(mockScenarioResetCmd)
+	mockScenarioCmd.AddCommand(mockScenarioSetCmd)
+	mockCmd.AddCommand(mockScenarioCmd)
+}
+
+func runMockScenarioList(cmd *cobra.Command, args []string) error {
+	states := mockStore().Scenarios()
+	if len(states) == 0 {
+		fmt.Println("No scenarios.")
+		return nil
+	}
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%-24s  %s\n", name, states[name])
+	}
+	return nil
+}
+
+func runMockScenarioReset(cmd *cobra.Command, args []string) error {
+	if err := mockStore().ResetScenarios(args...); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println("All scenarios and sequences reset.")
+	} else {
+		fmt.Println("Reset.")
+	}
+	return nil
+}
+
+func runMockScenarioSet(cmd *cobra.Command, args []string) error {
+	if err := mockStore().SetScenario(args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("%s → %s\n", args[0], args[1])
+	return nil
+}
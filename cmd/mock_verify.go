@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/muxover/snare/v2/mock"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mockVerifyFormat string
+	mockVerifyReset  bool
+)
+
+var mockVerifyCmd = &cobra.Command{
+	Use:   "verify [rule[=N|>=N|<=N]...]",
+	Short: "Check how often mock rules were hit; exits 1 if an expectation fails",
+	Long: "Check mock rule hits against expectations. A rule is named by its --name, its --url, or an ID prefix: 'login=2' expects exactly two hits, " +
+		"'search>=1' at least one, 'retry<=3' at most three, and 'logout=0' none. A rule on its own expects at least one hit, " +
+		"and with no arguments every rule does. Hits count from when the rule was added or its hits were last reset.",
+	RunE: runMockVerify,
+}
+
+func init() {
+	mockVerifyCmd.Flags().StringVar(&mockVerifyFormat, "format", "text", "Output format: text or junit")
+	mockVerifyCmd.Flags().BoolVar(&mockVerifyReset, "reset", false, "Reset the hits of the verified rules afterwards")
+	mockCmd.AddCommand(mockVerifyCmd)
+}
+
+// mockExpectation is one parsed verify argument.
+type mockExpectation struct {
+	rule *mock.Rule
+	op   string // "=", ">=", or "<="
+	n    int
+}
+
+var expectationRe = regexp.MustCompile(`^(.+?)(>=|<=|=)(\d+)$`)
+
+func (e mockExpectation) String() string {
+	switch {
+	case e.op == "=" && e.n == 0:
+		return "never"
+	case e.op == "=":
+		return fmt.Sprintf("exactly %d", e.n)
+	case e.op == ">=":
+		return fmt.Sprintf("at least %d", e.n)
+	default:
+		return fmt.Sprintf("at most %d", e.n)
+	}
+}
+
+func (e mockExpectation) met(count int) bool {
+	switch e.op {
+	case "=":
+		return count == e.n
+	case ">=":
+		return count >= e.n
+	default:
+		return count <= e.n
+	}
+}
+
+// findMockRule returns the rule with the given name or URL pattern, or
+// else the first whose ID starts with ref.
+func findMockRule(rules []*mock.Rule, ref string) *mock.Rule {
+	for _, r := range rules {
+		if r.Name == ref || r.URLPattern == ref {
+			return r
+		}
+	}
+	for _, r := range rules {
+		if strings.HasPrefix(r.ID, ref) {
+			return r
+		}
+	}
+	return nil
+}
+
+func parseMockExpectations(rules []*mock.Rule, args []string) ([]mockExpectation, error) {
+	if len(args) == 0 {
+		out := make([]mockExpectation, len(rules))
+		for i, r := range rules {
+			out[i] = mockExpectation{rule: r, op: ">=", n: 1}
+		}
+		return out, nil
+	}
+	var out []mockExpectation
+	for _, arg := range args {
+		e := mockExpectation{op: ">=", n: 1}
+		ref := arg
+		if m := expectationRe.FindStringSubmatch(arg); m != nil {
+			ref, e.op = m[1], m[2]
+			e.n, _ = strconv.Atoi(m[3])
+		}
+		if e.rule = findMockRule(rules, ref); e.rule == nil {
+			return nil, fmt.Errorf("rule not found: %s", ref)
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func mockLabel(r *mock.Rule) string {
+	short := r.ID
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	if r.Name != "" {
+		return short + " (" + r.Name + ")"
+	}
+	return short + " " + r.URLPattern
+}
+
+func runMockVerify(cmd *cobra.Command, args []string) error {
+	store := mockStore()
+	rules := store.Rules()
+	if len(rules) == 0 {
+		return fmt.Errorf("no mock rules")
+	}
+	expects, err := parseMockExpectations(rules, args)
+	if err != nil {
+		return err
+	}
+	hits := store.Hits()
+
+	failed := 0
+	var cases []assertJUnitCase
+	for _, e := range expects {
+		count := hits[e.rule.ID].Count
+		label := mockLabel(e.rule)
+		msg := fmt.Sprintf("%s called %d time(s), want %s", label, count, e)
+		tc := assertJUnitCase{Name: "mock " + label + " called " + e.String(), Classname: "snare", Time: "0.000"}
+		if !e.met(count) {
+			failed++
+			tc.Failure = &assertFailure{Message: msg, Text: msg}
+		}
+		cases = append(cases, tc)
+		if mockVerifyFormat != "junit" {
+			status := "ok  "
+			if tc.Failure != nil {
+				status = "FAIL"
+			}
+			fmt.Printf("%s  %s\n", status, msg)
+		}
+	}
+
+	if mockVerifyFormat == "junit" {
+		out := assertJUnitSuites{Suite: assertJUnitSuite{
+			Name:      "snare mock verify",
+			Tests:     len(cases),
+			Failures:  failed,
+			Time:      "0.000",
+			TestCases: cases,
+		}}
+		data, _ := xml.MarshalIndent(out, "", "  ")
+		fmt.Println(xml.Header + string(data))
+	}
+
+	if mockVerifyReset {
+		ids := make([]string, len(expects))
+		for i, e := range expects {
+			ids[i] = e.rule.ID
+		}
+		if err := store.ResetHits(ids...); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d mock expectation(s) failed", failed, len(expects))
+	}
+	return nil
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"encoding/json"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/muxover/snare/v2/capture"
@@ -13,9 +12,7 @@ import (
 
 var (
 	pipeFollow bool
-	pipeMethod string
-	pipeStatus int
-	pipeURL    string
+	pipeQuery  queryFlags
 )
 
 var pipeCmd = &cobra.Command{
@@ -26,31 +23,35 @@ var pipeCmd = &cobra.Command{
 }
 
 func init() {
-	pipeCmd.Flags().BoolVarP(&pipeFollow, "follow", "f", false, "Poll for new captures and stream them as they arrive")
-	pipeCmd.Flags().StringVar(&pipeMethod, "method", "", "Filter by HTTP method")
-	pipeCmd.Flags().IntVar(&pipeStatus, "status", 0, "Filter by response status code")
-	pipeCmd.Flags().StringVar(&pipeURL, "url", "", "Filter by URL substring")
+	pipeCmd.Flags().BoolVarP(&pipeFollow, "follow", "f", false, "Stream new captures as they arrive")
+	pipeQuery.register(pipeCmd, "method", "status", "url")
 }
 
 func runPipe(cmd *cobra.Command, args []string) error {
+	q, err := pipeQuery.compile()
+	if err != nil {
+		return err
+	}
 	store := capture.NewStore(0, config.StoreDir())
+	q = q.WithBodies(store.SearchBodies)
 	enc := json.NewEncoder(os.Stdout)
 
 	seen := map[string]bool{}
 
 	emit := func(c *capture.Capture) error {
-		if pipeMethod != "" && c.Request.Method != pipeMethod {
-			return nil
-		}
-		if pipeStatus != 0 && (c.Response == nil || c.Response.StatusCode != pipeStatus) {
-			return nil
-		}
-		if pipeURL != "" && !strings.Contains(c.Request.URL, pipeURL) {
+		if !q.Match(c) {
 			return nil
 		}
 		return enc.Encode(c)
 	}
 
+	var events <-chan capture.Event
+	if pipeFollow {
+		var stop func()
+		events, stop = store.Follow(500 * time.Millisecond)
+		defer stop()
+	}
+
 	captures := store.AllFromDisk()
 	for i := len(captures) - 1; i >= 0; i-- {
 		c := captures[i]
@@ -59,23 +60,17 @@ func runPipe(cmd *cobra.Command, args []string) error {
 			return err
 		}
 	}
-
 	if !pipeFollow {
 		return nil
 	}
 
-	for {
-		time.Sleep(500 * time.Millisecond)
-		fresh := store.AllFromDisk()
-		for i := len(fresh) - 1; i >= 0; i-- {
-			c := fresh[i]
-			if seen[c.ID] {
-				continue
-			}
-			seen[c.ID] = true
-			if err := emit(c); err != nil {
-				return err
-			}
+	for ev := range events {
+		if ev.Type != capture.EventAdded || seen[ev.ID] {
+			continue
+		}
+		if err := emit(ev.Capture); err != nil {
+			return err
 		}
 	}
+	return nil
 }
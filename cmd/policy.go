@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/muxover/snare/v2/config"
+	"github.com/muxover/snare/v2/policy"
+	"github.com/spf13/cobra"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage the per-host MITM policy",
+	Long: "Decide per host whether TLS is intercepted (mitm), tunneled untouched (passthrough), or refused (block). " +
+		"Patterns are a host, *.domain, or *; the most specific match wins and unmatched hosts are intercepted. " +
+		"Changes apply to a running serve immediately.",
+}
+
+var policyAddCmd = &cobra.Command{
+	Use:   "add [mitm|passthrough|block] [pattern...]",
+	Short: "Set the action for one or more host patterns",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runPolicyAdd,
+}
+
+var policyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List policy rules, including those from config.yaml",
+	RunE:  runPolicyList,
+}
+
+var policyRemoveCmd = &cobra.Command{
+	Use:   "remove [pattern]",
+	Short: "Remove the rule for a pattern",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPolicyRemove,
+}
+
+var policyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all rules added at runtime, including detected pinning",
+	RunE:  runPolicyClear,
+}
+
+func init() {
+	policyCmd.AddCommand(policyAddCmd)
+	policyCmd.AddCommand(policyListCmd)
+	policyCmd.AddCommand(policyRemoveCmd)
+	policyCmd.AddCommand(policyClearCmd)
+}
+
+func policyStore() *policy.Store {
+	var static []policy.Rule
+	if cfg, err := config.LoadFileConfig(); err == nil {
+		static = policyRules(cfg.MITM, cfg.Passthrough, cfg.Block)
+	}
+	return policy.NewStore(config.PolicyFile(), static, 0)
+}
+
+func runPolicyAdd(cmd *cobra.Command, args []string) error {
+	action, err := policy.ParseAction(args[0])
+	if err != nil {
+		return err
+	}
+	store := policyStore()
+	for _, p := range args[1:] {
+		if err := store.Add(policy.Rule{Pattern: p, Action: action}); err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s\n", action, p)
+	}
+	return nil
+}
+
+func runPolicyList(cmd *cobra.Command, args []string) error {
+	rules := policyStore().Rules()
+	if len(rules) == 0 {
+		fmt.Println("No policy rules; every host is intercepted.")
+		return nil
+	}
+	for _, r := range rules {
+		source := "runtime"
+		switch {
+		case r.Static:
+			source = "config"
+		case r.Auto:
+			source = "pinning detected " + r.Added.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-11s  %-30s  %s\n", r.Action, r.Pattern, source)
+	}
+	return nil
+}
+
+func runPolicyRemove(cmd *cobra.Command, args []string) error {
+	removed, err := policyStore().Remove(args[0])
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("rule not found: %s", args[0])
+	}
+	fmt.Println("Removed.")
+	return nil
+}
+
+func runPolicyClear(cmd *cobra.Command, args []string) error {
+	if err := policyStore().Clear(); err != nil {
+		return err
+	}
+	fmt.Println("All runtime policy rules cleared.")
+	return nil
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/query"
+	"github.com/spf13/cobra"
+)
+
+// queryFlags is the filter flag set shared by list, watch, grep, pipe,
+// assert, clear, export and bundle pack. The single-field flags are shorthands for query terms
+// and are combined with --query using and.
+type queryFlags struct {
+	expr      string
+	method    string
+	status    int
+	url       string
+	host      string
+	body      string
+	operation string
+	since     string
+	until     string
+	slow      int
+	tags      []string
+}
+
+// register adds --query and the named shorthand flags to cmd.
+func (q *queryFlags) register(cmd *cobra.Command, names ...string) {
+	fs := cmd.Flags()
+	fs.StringVarP(&q.expr, "query", "q", "", "Filter expression, e.g. 'host ~ api and status >= 500' (see 'snare help query')")
+	for _, name := range names {
+		switch name {
+		case "method":
+			fs.StringVar(&q.method, "method", "", "Filter by HTTP method (e.g. GET, POST)")
+		case "status":
+			fs.IntVar(&q.status, "status", 0, "Filter by response status code (e.g. 200, 404)")
+		case "url":
+			fs.StringVar(&q.url, "url", "", "Filter by URL substring")
+		case "host":
+			fs.StringVar(&q.host, "host", "", "Filter by host (URL host part)")
+		case "body":
+			fs.StringVar(&q.body, "body", "", "Filter by substring in request or response body")
+		case "operation":
+			fs.StringVar(&q.operation, "operation", "", "Filter by GraphQL operation name")
+		case "since":
+			fs.StringVar(&q.since, "since", "", "Include captures at or after this time (RFC3339 or 2006-01-02)")
+		case "until":
+			fs.StringVar(&q.until, "until", "", "Include captures at or before this time (RFC3339 or 2006-01-02)")
+		case "slow":
+			fs.IntVar(&q.slow, "slow", 0, "Show only captures slower than N milliseconds")
+		case "tag":
+			fs.StringSliceVar(&q.tags, "tag", nil, "Filter by tag (repeatable; captures must have every tag)")
+		default:
+			panic("unknown query flag " + name)
+		}
+	}
+}
+
+func (q *queryFlags) compile() (*query.Query, error) {
+	var terms []string
+	add := func(format string, args ...any) { terms = append(terms, fmt.Sprintf(format, args...)) }
+	if q.method != "" {
+		add("method == %s", strconv.Quote(q.method))
+	}
+	if q.status != 0 {
+		add("status == %d", q.status)
+	}
+	if q.url != "" {
+		add("url ~ %s", strconv.Quote(q.url))
+	}
+	if q.host != "" {
+		add("host ~ %s", strconv.Quote(q.host))
+	}
+	if q.body != "" {
+		add("body ~ %s", strconv.Quote(q.body))
+	}
+	if q.operation != "" {
+		add("operation == %s", strconv.Quote(q.operation))
+	}
+	since, err := parseSinceFlag(q.since)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseUntilFlag(q.until)
+	if err != nil {
+		return nil, err
+	}
+	if !since.IsZero() && !until.IsZero() && until.Before(since) {
+		return nil, fmt.Errorf("--until must be on or after --since")
+	}
+	if !since.IsZero() {
+		add("time >= %q", since.Format(time.RFC3339Nano))
+	}
+	if !until.IsZero() {
+		add("time <= %q", until.Format(time.RFC3339Nano))
+	}
+	if q.slow > 0 {
+		add("duration >= %d", q.slow)
+	}
+	for _, t := range q.tags {
+		add("tag == %s", strconv.Quote(t))
+	}
+	if _, err := query.Parse(q.expr); err != nil {
+		return nil, fmt.Errorf("--query: %w", err)
+	}
+	return query.And(append(terms, q.expr)...)
+}
+
+// find returns captures in store matching the flags, newest first, using
+// the store's indexes where the query allows. limit caps the result
+// (0 = no limit).
+func (q *queryFlags) find(store *capture.Store, limit int) ([]*capture.Capture, *query.Query, error) {
+	qq, err := q.compile()
+	if err != nil {
+		return nil, nil, err
+	}
+	f := qq.Hint()
+	f.Method, f.Host = strings.ToUpper(q.method), strings.ToLower(q.host)
+	if strings.TrimSpace(q.expr) == "" && q.url == "" && q.body == "" && q.operation == "" && q.slow == 0 && len(q.tags) == 0 {
+		f.Limit = limit
+	}
+	qq = qq.WithBodies(store.SearchBodies)
+	out := qq.Filter(store.Find(f))
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, qq, nil
+}
+
+var queryHelpCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Filter expression syntax for --query and the web and TUI filters",
+	Long: `Commands that filter captures accept --query (-q) with an expression such as
+
+  host ~ "api." and status >= 500 and resp.json.$.error.code == "RATE_LIMIT"
+
+Terms are combined with and, or, not (also &&, ||, !) and parentheses.
+
+Operators:
+  ==  !=      equal, not equal
+  ~   !~      contains, does not contain
+  =~          matches regular expression
+  < <= > >=   numeric for numbers, durations and times, otherwise by text
+
+A field on its own is true when it is present and non-empty (e.g. "error",
+"resp.header.set-cookie", "websocket"). Values may be quoted with " or '.
+
+Fields:
+  ` + strings.Join(query.Fields(), ", ") + `
+
+method, host, proto and operation compare case-insensitively. duration takes
+500ms / 2s or a number of milliseconds. time takes RFC3339, 2006-01-02 or a
+duration meaning that long ago (time > 1h). JSONPath supports $.key, [n],
+[-1] and [*]. session == "name" matches captures inside a named session;
+session on its own matches captures inside any session except the run-*
+sessions serve and run open by themselves.`,
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -20,13 +21,16 @@ import (
 )
 
 var (
-	recordOut     string
-	recordPort    string
-	recordBind    string
-	recordNoMITM  bool
-	recordVerbose bool
-	recordMode    string
-	recordTarget  string
+	recordOut      string
+	recordPort     string
+	recordBind     string
+	recordNoMITM   bool
+	recordVerbose  bool
+	recordMode     string
+	recordTarget   string
+	recordCA       string
+	recordInsecure []string
+	recordCerts    []string
 )
 
 var recordCmd = &cobra.Command{
@@ -43,6 +47,9 @@ func init() {
 	recordCmd.Flags().BoolVar(&recordNoMITM, "no-mitm", false, "Disable HTTPS MITM")
 	recordCmd.Flags().BoolVarP(&recordVerbose, "verbose", "v", false, "Enable debug logging")
 	recordCmd.Flags().StringVar(&recordMode, "mode", "forward", "Proxy mode: forward or reverse")
+	recordCmd.Flags().StringVar(&recordCA, "upstream-ca", "", "Verify origin certificates against this PEM bundle instead of the system roots")
+	recordCmd.Flags().StringArrayVar(&recordInsecure, "insecure-upstream", nil, "Skip origin certificate verification for this host, *.domain, or * for all (repeatable)")
+	recordCmd.Flags().StringArrayVar(&recordCerts, "client-cert", nil, "Client certificate for origins: host=cert.pem[,key.pem] or host=bundle.p12[,password] (repeatable)")
 	recordCmd.Flags().StringVar(&recordTarget, "target", "", "Reverse proxy target URL (required for --mode reverse)")
 }
 
@@ -95,7 +102,11 @@ func runRecord(cmd *cobra.Command, args []string) error {
 	}
 	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
 
-	transport, err := proxy.ProxyTransport(true, "")
+	upstreamTLS, err := upstreamTLSConfig(recordCA, recordInsecure, recordCerts)
+	if err != nil {
+		return err
+	}
+	transport, err := proxy.ProxyTransport(upstreamTLS, "")
 	if err != nil {
 		return err
 	}
@@ -103,23 +114,24 @@ func runRecord(cmd *cobra.Command, args []string) error {
 	var hostCerts *cert.HostCertCache
 	mitmEnable := !recordNoMITM && recordMode == "forward"
 	if mitmEnable {
-		ca, key, caErr := cert.LoadOrCreateCA(config.CADir())
+		ca, caErr := cert.LoadOrCreate(config.CADir())
 		if caErr != nil {
 			log.Warn("CA load failed, MITM disabled", "err", caErr)
 			mitmEnable = false
 		} else {
-			hostCerts = cert.NewHostCertCache(ca, key)
+			hostCerts = ca.HostCertCache(filepath.Join(config.CADir(), cert.LeafDir))
 		}
 	}
 
 	handler := &proxy.Handler{
-		Transport:  transport,
-		Store:      store,
-		HostCerts:  hostCerts,
-		Log:        log,
-		MitmEnable: mitmEnable,
-		Mode:       recordMode,
-		OnCapture:  cw.write,
+		Transport:   transport,
+		Store:       store,
+		HostCerts:   hostCerts,
+		Log:         log,
+		MitmEnable:  mitmEnable,
+		Mode:        recordMode,
+		OnCapture:   cw.write,
+		UpstreamTLS: upstreamTLS,
 	}
 
 	if recordMode == "reverse" {
@@ -56,7 +56,7 @@ func runReplay(cmd *cobra.Command, args []string) error {
 		}
 		for _, c := range store.AllFromDisk() {
 			if strings.Contains(c.Request.URL, replayMatch) {
-				targets = append(targets, c)
+				targets = append(targets, store.WithBodies(c))
 			}
 		}
 		if len(targets) == 0 {
@@ -67,7 +67,7 @@ func runReplay(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("provide capture id or use --match")
 		}
 		id := args[0]
-		c := store.GetByPrefix(id)
+		c := store.WithBodies(store.GetByPrefix(id))
 		if c == nil {
 			return fmt.Errorf("capture not found: %s", id)
 		}
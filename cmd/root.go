@@ -21,6 +21,7 @@ func init() {
 	rootCmd.SetVersionTemplate("snare version {{.Version}}\n")
 
 	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(watchCmd)
 	rootCmd.AddCommand(showCmd)
@@ -28,6 +29,7 @@ func init() {
 	rootCmd.AddCommand(replayCmd)
 	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(mockCmd)
+	rootCmd.AddCommand(policyCmd)
 	rootCmd.AddCommand(interceptCmd)
 	rootCmd.AddCommand(tuiCmd)
 	rootCmd.AddCommand(pipeCmd)
@@ -36,6 +38,10 @@ func init() {
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(clearCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(tagCmd)
+	rootCmd.AddCommand(noteCmd)
+	rootCmd.AddCommand(starCmd)
+	rootCmd.AddCommand(unstarCmd)
 	rootCmd.AddCommand(caCmd)
 	rootCmd.AddCommand(grepCmd)
 	rootCmd.AddCommand(curlCmd)
@@ -46,4 +52,6 @@ func init() {
 	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(fuzzCmd)
 	rootCmd.AddCommand(bundleCmd)
+	rootCmd.AddCommand(storeCmd)
+	rootCmd.AddCommand(queryHelpCmd)
 }
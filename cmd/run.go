@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/config"
+	"github.com/muxover/snare/v2/mock"
+	"github.com/muxover/snare/v2/policy"
+	"github.com/muxover/snare/v2/proxy"
+	"github.com/muxover/snare/v2/proxy/cert"
+	sess "github.com/muxover/snare/v2/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runAttach  string
+	runTag     string
+	runSession string
+	runNoMITM  bool
+	runNoProxy string
+	runVerbose bool
+)
+
+// runTagLimit caps the length of the tag derived from a command line.
+const runTagLimit = 80
+
+var runCmd = &cobra.Command{
+	Use:   "run [flags] [--] <command> [args...]",
+	Short: "Run a command with its traffic captured by snare",
+	Long: "Start a proxy on a free port, or --attach to a running 'snare serve', and run the command with HTTP_PROXY, HTTPS_PROXY, " +
+		"NO_PROXY, the CA bundle variables for OpenSSL, Python, curl, git, and Node, and JAVA_TOOL_OPTIONS set. " +
+		"Its captures are tagged with the command line, and a session named after it covers its lifetime. snare exits with the command's status.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: runRun,
+}
+
+func init() {
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.Flags().StringVar(&runAttach, "attach", "", "Use the snare serve at this address, such as 127.0.0.1:8888, instead of starting a proxy")
+	runCmd.Flags().StringVar(&runTag, "tag", "", "Tag for the command's captures (default: the command line)")
+	runCmd.Flags().StringVar(&runSession, "session", "", "Session name (default: run-<time>-<command>)")
+	runCmd.Flags().BoolVar(&runNoMITM, "no-mitm", false, "Tunnel HTTPS without MITM and leave the CA variables unset")
+	runCmd.Flags().StringVar(&runNoProxy, "no-proxy", "", "NO_PROXY for the command (default: empty, so every host goes through snare)")
+	runCmd.Flags().BoolVarP(&runVerbose, "verbose", "v", false, "Log proxy activity")
+}
+
+func runRun(cmd *cobra.Command, args []string) error {
+	code, err := runChild(args)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// runChild runs args behind a proxy and returns its exit status.
+func runChild(args []string) (int, error) {
+	tag := runTag
+	if tag == "" {
+		tag = commandTag(args)
+	}
+	if err := capture.ValidTag(tag); err != nil {
+		return 0, fmt.Errorf("--tag: %w", err)
+	}
+	// The tag reaches an attached serve as the proxy user name, which
+	// ends at the first colon.
+	if runAttach != "" && strings.Contains(tag, ":") {
+		return 0, fmt.Errorf("--tag: %q cannot contain ':' with --attach", tag)
+	}
+
+	var ca *cert.Authority
+	if !runNoMITM {
+		var err error
+		if ca, err = cert.LoadOrCreate(config.CADir()); err != nil {
+			return 0, fmt.Errorf("load CA: %w", err)
+		}
+	}
+
+	proxyURL := &url.URL{Scheme: "http"}
+	var count atomic.Int64
+	if runAttach != "" {
+		conn, err := net.DialTimeout("tcp", runAttach, 2*time.Second)
+		if err != nil {
+			return 0, fmt.Errorf("no snare serve at %s: %w", runAttach, err)
+		}
+		conn.Close()
+		// serve tags captures with the proxy user name.
+		proxyURL.Host, proxyURL.User = runAttach, url.User(tag)
+	} else {
+		srv, store, err := startRunProxy(ca, tag, &count)
+		if err != nil {
+			return 0, err
+		}
+		if store.ServeFeed() == nil {
+			defer store.CloseFeed()
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(ctx)
+		}()
+		proxyURL.Host = srv.Listener.Addr().String()
+	}
+
+	env, err := runEnv(proxyURL, ca)
+	if err != nil {
+		return 0, err
+	}
+
+	name, auto := runSession, runSession == ""
+	if auto {
+		name = "run-" + time.Now().Format("2006-01-02T15:04:05") + "-" + filepath.Base(args[0])
+	}
+	setSession(name, auto, false)
+	defer setSession(name, auto, true)
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Env = env
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	fmt.Fprintf(os.Stderr, "[snare] capturing via %s, tag %s, session %s\n", proxyURL.Redacted(), tag, name)
+
+	// The terminal sends Ctrl+C to the child too; snare stays up until the
+	// child exits so its last requests are captured.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	if err := child.Start(); err != nil {
+		return 0, err
+	}
+	go func() {
+		for s := range sigs {
+			_ = child.Process.Signal(s)
+		}
+	}()
+
+	err = child.Wait()
+	if runAttach == "" {
+		fmt.Fprintf(os.Stderr, "[snare] %d captures; see them with: snare list --tag %s\n", count.Load(), shellQuote(tag))
+	} else {
+		fmt.Fprintf(os.Stderr, "[snare] see captures with: snare list --tag %s\n", shellQuote(tag))
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code > 0 {
+			return code, nil
+		}
+		return 1, nil
+	}
+	return 0, err
+}
+
+// commandTag makes a capture tag of a command line: whitespace, commas and
+// colons become underscores, and long command lines are cut short.
+func commandTag(args []string) string {
+	line := strings.Join(args, " ")
+	tag := []rune(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ',' || r == ':' {
+			return '_'
+		}
+		return r
+	}, line))
+	if len(tag) > runTagLimit {
+		tag = tag[:runTagLimit]
+	}
+	return string(tag)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func setSession(name string, auto, end bool) {
+	sessions, err := sess.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] session: %v\n", err)
+		return
+	}
+	e := sessions[name]
+	if end {
+		e.End = time.Now()
+	} else {
+		e = sess.Entry{Start: time.Now(), Auto: auto}
+	}
+	sessions[name] = e
+	if err := sess.Save(sessions); err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] session: %v\n", err)
+	}
+}
+
+// startRunProxy starts a forward proxy on a free loopback port that saves
+// to the usual store and tags every capture.
+func startRunProxy(ca *cert.Authority, tag string, count *atomic.Int64) (*proxy.Server, *capture.Store, error) {
+	logLevel := slog.LevelWarn
+	if runVerbose {
+		logLevel = slog.LevelDebug
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	upstreamTLS, err := upstreamTLSConfig("", nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, err := proxy.ProxyTransport(upstreamTLS, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	store := capture.NewStore(1000, config.StoreDir())
+	handler := &proxy.Handler{
+		Transport:   transport,
+		Store:       store,
+		Mocks:       mock.NewStore(config.MockFile()),
+		Log:         log,
+		MitmEnable:  ca != nil,
+		Mode:        "forward",
+		OnCapture:   func(*capture.Capture) { count.Add(1) },
+		UpstreamTLS: upstreamTLS,
+		Policy:      policy.NewStore(config.PolicyFile(), nil, policy.DefaultPinThreshold),
+		Tags:        []string{tag},
+	}
+	if ca != nil {
+		handler.HostCerts = ca.HostCertCache(filepath.Join(config.CADir(), cert.LeafDir))
+	}
+	srv, err := proxy.NewServer("127.0.0.1:0", handler, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv.Start()
+	return srv, store, nil
+}
+
+// runEnv is the environment for the child: the current one with the proxy
+// and, when ca is set, CA trust variables replaced.
+func runEnv(proxyURL *url.URL, ca *cert.Authority) ([]string, error) {
+	p := proxyURL.String()
+	vars := [][2]string{
+		{"HTTP_PROXY", p}, {"HTTPS_PROXY", p}, {"http_proxy", p}, {"https_proxy", p},
+		{"NO_PROXY", runNoProxy}, {"no_proxy", runNoProxy},
+		// Node 24 and later honour the proxy variables with this set.
+		{"NODE_USE_ENV_PROXY", "1"},
+	}
+
+	host, port, _ := net.SplitHostPort(proxyURL.Host)
+	java := []string{
+		"-Dhttp.proxyHost=" + host, "-Dhttp.proxyPort=" + port,
+		"-Dhttps.proxyHost=" + host, "-Dhttps.proxyPort=" + port,
+	}
+	if runNoProxy != "" {
+		java = append(java, "-Dhttp.nonProxyHosts="+strings.ReplaceAll(runNoProxy, ",", "|"))
+	}
+
+	if ca != nil {
+		dir := filepath.Join(config.CADir(), "trust")
+		bundle := filepath.Join(dir, "run-cacert.pem")
+		if err := writeBundle(systemBundle(), bundle, ca.Root); err != nil {
+			return nil, err
+		}
+		for _, k := range []string{"SSL_CERT_FILE", "REQUESTS_CA_BUNDLE", "CURL_CA_BUNDLE", "GIT_SSL_CAINFO", "AWS_CA_BUNDLE", "PIP_CERT"} {
+			vars = append(vars, [2]string{k, bundle})
+		}
+		vars = append(vars, [2]string{"NODE_EXTRA_CA_CERTS", filepath.Join(config.CADir(), cert.CertFile)})
+
+		// Java replaces its default roots with trustStore, so start from
+		// the JDK's cacerts when there is one.
+		trustStore := filepath.Join(dir, "run-truststore.jks")
+		ks := &cert.JKS{}
+		if path, err := javaKeystore(); err == nil {
+			if parsed, err := readKeystore(path, "changeit"); err == nil {
+				ks = parsed
+			}
+		}
+		ks.SetTrustedCert(trustAlias, ca.Root)
+		if err := os.WriteFile(trustStore, ks.Marshal("changeit"), 0644); err != nil {
+			return nil, err
+		}
+		java = append(java, "-Djavax.net.ssl.trustStore="+trustStore, "-Djavax.net.ssl.trustStorePassword=changeit", "-Djavax.net.ssl.trustStoreType=JKS")
+	}
+	if existing := os.Getenv("JAVA_TOOL_OPTIONS"); existing != "" {
+		java = append([]string{existing}, java...)
+	}
+	vars = append(vars, [2]string{"JAVA_TOOL_OPTIONS", strings.Join(java, " ")})
+
+	env := os.Environ()
+	for _, kv := range vars {
+		env = unsetEnv(env, kv[0])
+		env = append(env, kv[0]+"="+kv[1])
+	}
+	return env, nil
+}
+
+// unsetEnv removes key from env.
+func unsetEnv(env []string, key string) []string {
+	out := env[:0]
+	for _, e := range env {
+		if !strings.HasPrefix(e, key+"=") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -32,6 +32,9 @@ func runSave(cmd *cobra.Command, args []string) error {
 	store := capture.NewStore(0, config.StoreDir())
 	if saveAll {
 		captures := store.ListFromDisk(saveLastN)
+		for i, c := range captures {
+			captures[i] = store.WithBodies(c)
+		}
 		if len(captures) == 0 {
 			fmt.Println("No captures to save.")
 			return nil
@@ -54,7 +57,7 @@ func runSave(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("specify capture id or use --all")
 	}
 	id := args[0]
-	c := store.GetByPrefix(id)
+	c := store.WithBodies(store.GetByPrefix(id))
 	if c == nil {
 		return fmt.Errorf("capture not found: %s", id)
 	}
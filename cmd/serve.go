@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"os/user"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -23,6 +26,7 @@ import (
 	"github.com/muxover/snare/v2/config"
 	"github.com/muxover/snare/v2/intercept"
 	"github.com/muxover/snare/v2/mock"
+	"github.com/muxover/snare/v2/policy"
 	"github.com/muxover/snare/v2/proxy"
 	"github.com/muxover/snare/v2/proxy/cert"
 	sess "github.com/muxover/snare/v2/session"
@@ -35,8 +39,13 @@ var (
 	serveBind             string
 	serveNoMITM           bool
 	serveStoreDir         string
+	serveStoreBackend     string
 	serveVerbose          bool
 	serveMaxCaptures      int
+	serveMaxAge           string
+	serveMaxStoreSize     string
+	serveMaxPerHost       int
+	serveKeepSessions     bool
 	serveUpstreamProxy    string
 	serveRewriteHost      []string
 	serveAddHeader        []string
@@ -52,6 +61,7 @@ var (
 	serveRewriteBody      []string
 	serveNoStore          bool
 	serveMaxBodySize      int64
+	serveSpillThreshold   int64
 	serveDelay            time.Duration
 	serveChaos            float64
 	serveBrowser          bool
@@ -63,8 +73,21 @@ var (
 	serveProtoFiles       []string
 	serveNoH3             bool
 	serveHooks            []string
+	serveSocksPort        string
+	serveSocksUsers       []string
+	serveUpstreamCA       string
+	serveInsecure         []string
+	serveClientCerts      []string
+	serveKeyLog           string
+	servePassthrough      []string
+	serveBlock            []string
+	serveMITMHosts        []string
+	servePinDetect        int
 )
 
+// compactInterval is how often serve enforces the retention policy.
+const compactInterval = time.Minute
+
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the proxy server",
@@ -77,8 +100,21 @@ func init() {
 	serveCmd.Flags().StringVarP(&serveBind, "bind", "b", "127.0.0.1", "Address to bind (use 0.0.0.0 for all interfaces)")
 	serveCmd.Flags().BoolVar(&serveNoMITM, "no-mitm", false, "Disable HTTPS MITM; CONNECT is tunneled only")
 	serveCmd.Flags().StringVar(&serveStoreDir, "store-dir", "", "Directory to save captures (default: SNARE_STORE or ~/.snare/captures)")
+	serveCmd.Flags().StringVar(&serveStoreBackend, "store-backend", "", "Capture store backend: json or bolt (default: detect from store dir, else json)")
 	serveCmd.Flags().BoolVarP(&serveVerbose, "verbose", "v", false, "Enable debug logging")
 	serveCmd.Flags().IntVar(&serveMaxCaptures, "max-captures", 1000, "Maximum number of captures to keep; oldest pruned")
+	serveCmd.Flags().StringVar(&serveMaxAge, "max-age", "", "Prune captures older than this (e.g. 7d, 12h)")
+	serveCmd.Flags().StringVar(&serveMaxStoreSize, "max-store-size", "", "Prune the oldest captures once the store exceeds this size (e.g. 2GB)")
+	serveCmd.Flags().IntVar(&serveMaxPerHost, "max-per-host", 0, "Keep at most this many captures per host (0 = no limit)")
+	serveCmd.Flags().BoolVar(&serveKeepSessions, "keep-sessions", false, "Never prune captures that belong to a named session")
+	serveCmd.Flags().StringVar(&serveUpstreamCA, "upstream-ca", "", "Verify origin certificates against this PEM bundle instead of the system roots")
+	serveCmd.Flags().StringArrayVar(&serveInsecure, "insecure-upstream", nil, "Skip origin certificate verification for this host, *.domain, or * for all (repeatable)")
+	serveCmd.Flags().StringArrayVar(&serveClientCerts, "client-cert", nil, "Client certificate for origins: host=cert.pem[,key.pem] or host=bundle.p12[,password] (repeatable)")
+	serveCmd.Flags().StringVar(&serveKeyLog, "keylog", "", "Append TLS session keys for both MITM legs to this file in NSS key log format (for Wireshark)")
+	serveCmd.Flags().StringArrayVar(&servePassthrough, "passthrough", nil, "Tunnel TLS to this host without MITM: host, *.domain, or * (repeatable)")
+	serveCmd.Flags().StringArrayVar(&serveBlock, "block", nil, "Refuse connections to this host: host, *.domain, or * (repeatable)")
+	serveCmd.Flags().StringArrayVar(&serveMITMHosts, "mitm", nil, "Always MITM this host, overriding a broader --passthrough pattern (repeatable)")
+	serveCmd.Flags().IntVar(&servePinDetect, "pin-detect", policy.DefaultPinThreshold, "Pass a host through after this many rejected client handshakes in 10 minutes (0 = off)")
 	serveCmd.Flags().StringVar(&serveUpstreamProxy, "upstream-proxy", "", "Forward outbound traffic through this proxy URL (http://host:port)")
 	serveCmd.Flags().StringArrayVar(&serveRewriteHost, "rewrite-host", nil, "Rewrite outbound host: from=to (repeatable)")
 	serveCmd.Flags().StringArrayVar(&serveAddHeader, "add-header", nil, "Add or override outbound header: Key: Value (repeatable)")
@@ -87,13 +123,14 @@ func init() {
 	serveCmd.Flags().StringVar(&serveIntercept, "intercept", "", "Intercept requests matching this URL pattern (use * for all)")
 	serveCmd.Flags().DurationVar(&serveInterceptTimeout, "intercept-timeout", 5*time.Minute, "Auto-drop intercepted requests after this duration")
 	serveCmd.Flags().StringVar(&serveOnCapture, "on-capture", "", "Run this shell command for each new capture; capture JSON written to stdin")
-	serveCmd.Flags().StringVar(&serveMode, "mode", "forward", "Proxy mode: forward or reverse")
+	serveCmd.Flags().StringVar(&serveMode, "mode", "forward", "Proxy mode: forward, reverse, or transparent (Linux; traffic redirected by iptables/nftables)")
 	serveCmd.Flags().StringVar(&serveTarget, "target", "", "Reverse proxy target URL (required when --mode reverse)")
 	serveCmd.Flags().StringArrayVar(&serveIgnore, "ignore", nil, "Skip capturing requests whose URL contains this substring (repeatable)")
 	serveCmd.Flags().StringArrayVar(&serveMapRemote, "map-remote", nil, "Redirect host to a different base URL: source-host=http://target (repeatable)")
 	serveCmd.Flags().StringArrayVar(&serveRewriteBody, "rewrite-body", nil, "Rewrite response bodies: regex=replacement (repeatable)")
 	serveCmd.Flags().BoolVar(&serveNoStore, "no-store", false, "Disable disk persistence (captures held in memory only)")
 	serveCmd.Flags().Int64Var(&serveMaxBodySize, "max-body-size", 0, "Truncate captured bodies at this byte limit (0 = no limit)")
+	serveCmd.Flags().Int64Var(&serveSpillThreshold, "spill-threshold", 1<<20, "Stream bodies larger than this many bytes and store them as blob files (0 = always buffer)")
 	serveCmd.Flags().DurationVar(&serveDelay, "delay", 0, "Add artificial latency to every response (e.g. 200ms, 1s)")
 	serveCmd.Flags().Float64Var(&serveChaos, "chaos", 0, "Randomly drop this percentage of requests (0-100)")
 	serveCmd.Flags().BoolVar(&serveBrowser, "browser", false, "Launch system browser with proxy pre-configured after start")
@@ -104,6 +141,8 @@ func init() {
 	serveCmd.Flags().BoolVar(&serveNoConfig, "no-config", false, "Ignore ~/.snare/config.yaml")
 	serveCmd.Flags().StringArrayVar(&serveProtoFiles, "proto", nil, "Protobuf definition file for gRPC decoding; decoded fields shown instead of raw bytes (repeatable)")
 	serveCmd.Flags().BoolVar(&serveNoH3, "no-h3", false, "Disable HTTP/3 (QUIC) server in reverse proxy mode")
+	serveCmd.Flags().StringVar(&serveSocksPort, "socks-port", "", "Also accept SOCKS5 and SOCKS4a clients on this port")
+	serveCmd.Flags().StringArrayVar(&serveSocksUsers, "socks-user", nil, "Require SOCKS clients to log in as name:password; the name tags their captures (repeatable)")
 	serveCmd.Flags().StringArrayVar(&serveHooks, "hook", nil, "JS hook file executed per request/response/capture; reloaded from disk on every request (repeatable)")
 }
 
@@ -120,8 +159,25 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 	servePort = port
 
-	if serveMode != "forward" && serveMode != "reverse" {
-		return fmt.Errorf("--mode must be forward or reverse, got %q", serveMode)
+	if serveMode != "forward" && serveMode != "reverse" && serveMode != "transparent" {
+		return fmt.Errorf("--mode must be forward, reverse, or transparent, got %q", serveMode)
+	}
+
+	if serveSocksPort != "" {
+		if serveMode == "reverse" {
+			return fmt.Errorf("--socks-port is not available in reverse mode")
+		}
+		if serveSocksPort, err = parsePort(serveSocksPort); err != nil {
+			return fmt.Errorf("--socks-port: %w", err)
+		}
+	}
+	socksUsers := make(map[string]string)
+	for _, u := range serveSocksUsers {
+		name, pass, _ := strings.Cut(u, ":")
+		if err := capture.ValidTag(name); err != nil {
+			return fmt.Errorf("--socks-user: %w", err)
+		}
+		socksUsers[name] = pass
 	}
 
 	var reverseTarget *url.URL
@@ -151,7 +207,30 @@ func runServe(cmd *cobra.Command, args []string) error {
 	if maxCap <= 0 {
 		maxCap = 1000
 	}
-	store := capture.NewStore(maxCap, storeDir)
+	if storeDir != "" && serveStoreBackend != "" && serveStoreBackend != capture.DetectBackend(storeDir) {
+		if old, err := capture.OpenBackend(storeDir, ""); err == nil {
+			if n, _ := old.Count(); n > 0 {
+				return fmt.Errorf("store dir %s holds %d captures in the %s backend; run 'snare store migrate --to %s' first", storeDir, n, old.Kind(), serveStoreBackend)
+			}
+		}
+	}
+	store, err := capture.NewStoreBackend(maxCap, storeDir, serveStoreBackend)
+	if err != nil {
+		return err
+	}
+	maxAge, err := parseAge(serveMaxAge)
+	if err != nil {
+		return fmt.Errorf("--max-age: %w", err)
+	}
+	maxStoreSize, err := parseByteSize(serveMaxStoreSize)
+	if err != nil {
+		return fmt.Errorf("--max-store-size: %w", err)
+	}
+	retention := capture.Retention{MaxAge: maxAge, MaxBytes: maxStoreSize, MaxPerHost: serveMaxPerHost}
+	if serveKeepSessions {
+		retention.Keep = sess.Keeper()
+	}
+	store.SetRetention(retention)
 
 	rewrites, err := parseHostRewriteRules(serveRewriteHost)
 	if err != nil {
@@ -179,7 +258,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 	mocks := mock.NewStore(mockFilePath)
 
-	transport, err := proxy.ProxyTransport(true, serveUpstreamProxy)
+	upstreamTLS, err := upstreamTLSConfig(serveUpstreamCA, serveInsecure, serveClientCerts)
+	if err != nil {
+		return err
+	}
+	var keyLog io.Writer
+	if serveKeyLog != "" {
+		f, err := os.OpenFile(serveKeyLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("--keylog: %w", err)
+		}
+		defer f.Close()
+		keyLog = f
+		upstreamTLS.KeyLog = f
+	}
+	transport, err := proxy.ProxyTransport(upstreamTLS, serveUpstreamProxy)
 	if err != nil {
 		return err
 	}
@@ -196,14 +289,14 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 
 	var hostCerts *cert.HostCertCache
-	mitmEnable := !serveNoMITM && serveMode == "forward"
+	mitmEnable := !serveNoMITM && serveMode != "reverse"
 	if mitmEnable {
-		ca, key, caErr := cert.LoadOrCreateCA(config.CADir())
+		ca, caErr := cert.LoadOrCreate(config.CADir())
 		if caErr != nil {
 			log.Warn("CA load failed, MITM disabled", "err", caErr)
 			mitmEnable = false
 		} else {
-			hostCerts = cert.NewHostCertCache(ca, key)
+			hostCerts = ca.HostCertCache(filepath.Join(config.CADir(), cert.LeafDir))
 		}
 	}
 
@@ -213,18 +306,10 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 
 	var webSrv *web.Server
-	var onCapture func(*capture.Capture)
-	baseOnCapture := buildOnCapture(serveOnCapture)
+	onCapture := buildOnCapture(serveOnCapture)
 	if serveWeb {
 		webSrv = &web.Server{Store: store, Mocks: mocks, Transport: transport, Intercept: interceptQueue, CADir: config.CADir(), WebPort: serveWebPort, ProxyAddr: serveBind + ":" + servePort}
-		onCapture = func(c *capture.Capture) {
-			if baseOnCapture != nil {
-				baseOnCapture(c)
-			}
-			webSrv.NotifyCapture(c)
-		}
-	} else {
-		onCapture = baseOnCapture
+		defer webSrv.WatchStore()()
 	}
 
 	var protoDecoder *proxy.ProtoDecoder
@@ -260,6 +345,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 		MapRemotes:       mapRemotes,
 		BodyRewrites:     bodyRewrites,
 		MaxBodySize:      serveMaxBodySize,
+		SpillThreshold:   serveSpillThreshold,
 		Mode:             serveMode,
 		ReverseTarget:    reverseTarget,
 		Delay:            serveDelay,
@@ -268,38 +354,59 @@ func runServe(cmd *cobra.Command, args []string) error {
 		Plugins:          servePlugins,
 		ProtoDecoder:     protoDecoder,
 		Hooks:            hooks,
+		UpstreamTLS:      upstreamTLS,
+		KeyLog:           keyLog,
+		Policy:           policy.NewStore(config.PolicyFile(), policyRules(serveMITMHosts, servePassthrough, serveBlock), servePinDetect),
 	}
 
 	addr := serveBind + ":" + servePort
-	srv, err := proxy.NewServer(addr, handler, log)
+	var (
+		srv  *proxy.Server
+		tsrv *proxy.TransparentServer
+	)
+	if serveMode == "transparent" {
+		tsrv, err = proxy.NewTransparentServer(addr, handler, log)
+	} else {
+		srv, err = proxy.NewServer(addr, handler, log)
+	}
 	if err != nil {
 		return err
 	}
 
-	if serveMode == "reverse" {
+	switch serveMode {
+	case "reverse":
 		log.Info("reverse proxy listening", "addr", addr, "target", serveTarget)
 		if !serveNoH3 && hostCerts != nil && reverseTarget != nil {
 			hostname := reverseTarget.Hostname()
 			if hostname == "" {
 				hostname = "localhost"
 			}
-			rawCert, privKey, certErr := hostCerts.GetCertificate(hostname)
+			tlsCert, certErr := hostCerts.TLSCertificate(hostname)
 			if certErr == nil {
-				tlsCfg := &tls.Config{
-					Certificates: []tls.Certificate{{
-						Certificate: [][]byte{rawCert.Raw},
-						PrivateKey:  privKey,
-					}},
-				}
+				tlsCfg := &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
 				srv.StartH3(handler, tlsCfg, log)
 			} else {
 				log.Warn("H3: cert generation failed, HTTP/3 disabled", "err", certErr)
 			}
 		}
-	} else {
+	case "transparent":
+		log.Info("transparent proxy listening", "addr", addr, "mitm", mitmEnable)
+		log.Info("redirect traffic here, e.g. iptables -t nat -A OUTPUT -p tcp --dport 443 -m owner ! --uid-owner " + currentUID() + " -j REDIRECT --to-ports " + servePort)
+	default:
 		log.Info("proxy listening", "addr", addr, "mitm", mitmEnable)
 		log.Info("set HTTP_PROXY and HTTPS_PROXY to http://" + addr)
 	}
+	var socksSrv *proxy.SocksServer
+	if serveSocksPort != "" {
+		socksAddr := serveBind + ":" + serveSocksPort
+		socksSrv, err = proxy.NewSocksServer(socksAddr, handler, socksUsers, log)
+		if err != nil {
+			return fmt.Errorf("--socks-port: %w", err)
+		}
+		log.Info("SOCKS proxy listening", "addr", socksAddr, "auth", len(socksUsers) > 0)
+		socksSrv.Start()
+		defer socksSrv.Close()
+	}
 	if storeDir != "" {
 		log.Info("captures saved to", "dir", storeDir)
 	}
@@ -308,7 +415,20 @@ func runServe(cmd *cobra.Command, args []string) error {
 			log.Info("external clients can use", "url", "http://"+ip+":"+servePort)
 		}
 	}
-	srv.Start()
+	if tsrv != nil {
+		tsrv.Start()
+		defer tsrv.Close()
+	} else {
+		srv.Start()
+	}
+
+	if err := store.ServeFeed(); err != nil {
+		log.Warn("capture feed disabled; watch and pipe --follow will poll", "err", err)
+	}
+	defer store.CloseFeed()
+	if storeDir != "" && retention.Enabled() {
+		defer store.StartCompactor(compactInterval)()
+	}
 
 	dashURL := ""
 	if serveWeb {
@@ -334,7 +454,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	runSession := "run-" + time.Now().Format("2006-01-02T15:04:05")
 	if sessions, err := sess.Load(); err == nil {
-		sessions[runSession] = sess.Entry{Start: time.Now()}
+		sessions[runSession] = sess.Entry{Start: time.Now(), Auto: true}
 		_ = sess.Save(sessions)
 		log.Info("run session started", "name", runSession)
 	}
@@ -354,9 +474,56 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if srv == nil {
+		return nil
+	}
 	return srv.Shutdown(ctx)
 }
 
+func upstreamTLSConfig(caFile string, insecure, clientCerts []string) (*proxy.UpstreamTLS, error) {
+	u := &proxy.UpstreamTLS{Insecure: insecure}
+	if caFile != "" {
+		pool, err := proxy.LoadRootCAs(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("--upstream-ca: %w", err)
+		}
+		u.RootCAs = pool
+	}
+	for _, spec := range clientCerts {
+		host, files, ok := strings.Cut(spec, "=")
+		if !ok || host == "" || files == "" {
+			return nil, fmt.Errorf("--client-cert: want host=cert.pem[,key.pem] or host=bundle.p12[,password], got %q", spec)
+		}
+		certFile, second, _ := strings.Cut(files, ",")
+		cc, err := proxy.LoadClientCert(host, certFile, second)
+		if err != nil {
+			return nil, fmt.Errorf("--client-cert: %w", err)
+		}
+		u.ClientCerts = append(u.ClientCerts, cc)
+	}
+	return u, nil
+}
+
+func policyRules(mitm, passthrough, block []string) []policy.Rule {
+	var rules []policy.Rule
+	for _, set := range []struct {
+		action   policy.Action
+		patterns []string
+	}{{policy.MITM, mitm}, {policy.Passthrough, passthrough}, {policy.Block, block}} {
+		for _, p := range set.patterns {
+			rules = append(rules, policy.Rule{Pattern: p, Action: set.action})
+		}
+	}
+	return rules
+}
+
+func currentUID() string {
+	if u, err := user.Current(); err == nil {
+		return u.Uid
+	}
+	return "0"
+}
+
 func localIPs() []string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -589,15 +756,31 @@ func applyFileConfig(cmd *cobra.Command) error {
 	set("on-capture", cfg.OnCapture)
 	set("delay", cfg.Delay)
 	set("web-port", cfg.WebPort)
+	set("socks-port", cfg.SocksPort)
+	set("upstream-ca", cfg.UpstreamCA)
+	set("keylog", cfg.KeyLog)
+	set("store-backend", cfg.StoreBackend)
+	set("max-age", cfg.MaxAge)
+	set("max-store-size", cfg.MaxStoreSize)
+	setBool("keep-sessions", cfg.KeepSessions)
 	setBool("no-mitm", cfg.NoMITM)
 	setBool("verbose", cfg.Verbose)
 	setBool("web", cfg.Web)
 	if cfg.MaxCaptures > 0 && !cmd.Flags().Changed("max-captures") {
 		_ = cmd.Flags().Set("max-captures", fmt.Sprint(cfg.MaxCaptures))
 	}
+	if cfg.MaxPerHost > 0 && !cmd.Flags().Changed("max-per-host") {
+		_ = cmd.Flags().Set("max-per-host", fmt.Sprint(cfg.MaxPerHost))
+	}
 	if cfg.MaxBodySize > 0 && !cmd.Flags().Changed("max-body-size") {
 		_ = cmd.Flags().Set("max-body-size", fmt.Sprint(cfg.MaxBodySize))
 	}
+	if cfg.PinDetect != nil && !cmd.Flags().Changed("pin-detect") {
+		_ = cmd.Flags().Set("pin-detect", fmt.Sprint(*cfg.PinDetect))
+	}
+	if cfg.SpillThreshold != nil && !cmd.Flags().Changed("spill-threshold") {
+		_ = cmd.Flags().Set("spill-threshold", fmt.Sprint(*cfg.SpillThreshold))
+	}
 	if cfg.Chaos > 0 && !cmd.Flags().Changed("chaos") {
 		_ = cmd.Flags().Set("chaos", fmt.Sprintf("%g", cfg.Chaos))
 	}
@@ -609,5 +792,11 @@ func applyFileConfig(cmd *cobra.Command) error {
 	setSlice("rewrite-body", cfg.RewriteBody)
 	setSlice("shadow", cfg.Shadow)
 	setSlice("plugin", cfg.Plugins)
+	setSlice("socks-user", cfg.SocksUsers)
+	setSlice("insecure-upstream", cfg.InsecureUpstream)
+	setSlice("client-cert", cfg.ClientCerts)
+	setSlice("passthrough", cfg.Passthrough)
+	setSlice("block", cfg.Block)
+	setSlice("mitm", cfg.MITM)
 	return nil
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/muxover/snare/v2/capture"
@@ -19,10 +20,38 @@ var showCmd = &cobra.Command{
 	RunE:  runShow,
 }
 
+const timingBarWidth = 30
+
+func printTimings(t *capture.Timings) {
+	conn := "new connection"
+	if t.Reused {
+		conn = "reused connection"
+	}
+	if t.RemoteAddr != "" {
+		conn += " to " + t.RemoteAddr
+	}
+	fmt.Println(conn)
+	total := t.Total()
+	offset := time.Duration(0)
+	for _, p := range t.Phases() {
+		bar := ""
+		if total > 0 && p.Duration > 0 {
+			lead := int(offset * timingBarWidth / total)
+			bar = strings.Repeat(" ", lead) + strings.Repeat("█", max(1, int(p.Duration*timingBarWidth/total)))
+		}
+		label := p.Name
+		if p.Name == "wait" {
+			label = "wait (TTFB)"
+		}
+		fmt.Printf("  %-12s %9s  %s\n", label, formatListLatency(p.Duration), colorCyan.Render(bar))
+		offset += p.Duration
+	}
+}
+
 func runShow(cmd *cobra.Command, args []string) error {
 	id := args[0]
 	store := capture.NewStore(0, config.StoreDir())
-	c := store.GetByPrefix(id)
+	c := store.WithBodies(store.GetByPrefix(id))
 	if c == nil {
 		return fmt.Errorf("capture not found: %s", id)
 	}
@@ -38,6 +67,9 @@ func runShow(cmd *cobra.Command, args []string) error {
 		fmt.Println(string(c.Request.Body))
 	}
 	fmt.Println("\n=== Response ===")
+	if c.Mock != "" {
+		fmt.Println("Mocked by rule", c.Mock)
+	}
 	if c.Response != nil {
 		fmt.Printf("Status: %d\n", c.Response.StatusCode)
 		for k, v := range c.Response.Headers {
@@ -53,6 +85,19 @@ func runShow(cmd *cobra.Command, args []string) error {
 		fmt.Println("Error:", c.Error)
 	}
 	fmt.Printf("\nDuration: %s\n", c.Duration)
+	if c.Timings != nil {
+		fmt.Println("\n=== Timings ===")
+		printTimings(c.Timings)
+	}
+	if c.Conn != nil {
+		fmt.Println("\n=== Connection ===")
+		if c.Conn.Client != "" {
+			fmt.Printf("client  %s → %s\n", c.Conn.Client, c.Conn.Proxy)
+		}
+		if c.Conn.Origin != "" {
+			fmt.Printf("origin  %s → %s\n", c.Conn.OriginLocal, c.Conn.Origin)
+		}
+	}
 	if c.GraphQL != nil {
 		fmt.Println("\n=== GraphQL ===")
 		if c.GraphQL.OperationName != "" {
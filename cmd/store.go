@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storeMigrateTo string
+	storeStatsTop  int
+	storeStatsJSON bool
+)
+
+var storeCmd = &cobra.Command{
+	Use:   "store",
+	Short: "Manage the capture store",
+}
+
+var storeMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert the capture store to another backend",
+	Long:  "Copy every capture in the store directory into the backend given by --to (json or bolt) and remove the old data. Stop snare serve before migrating.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storeDir := config.StoreDir()
+		from := capture.DetectBackend(storeDir)
+		n, err := capture.Migrate(storeDir, storeMigrateTo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Migrated %d captures from %s to %s in %s\n", n, from, storeMigrateTo, storeDir)
+		return nil
+	},
+}
+
+var storeStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show capture store disk usage per host",
+	RunE:  runStoreStats,
+}
+
+var storeReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the full-text search index used by grep",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storeDir := config.StoreDir()
+		n, err := capture.NewStore(0, storeDir).Reindex()
+		if err != nil {
+			return fmt.Errorf("reindexing: %w", err)
+		}
+		fmt.Printf("Indexed %d captures in %s\n", n, storeDir)
+		return nil
+	},
+}
+
+func init() {
+	storeMigrateCmd.Flags().StringVar(&storeMigrateTo, "to", capture.BackendBolt, "Target backend: json or bolt")
+	storeStatsCmd.Flags().IntVar(&storeStatsTop, "top", 20, "Show at most this many hosts (0 = all)")
+	storeStatsCmd.Flags().BoolVar(&storeStatsJSON, "json", false, "Output usage as JSON")
+	storeCmd.AddCommand(storeMigrateCmd)
+	storeCmd.AddCommand(storeStatsCmd)
+	storeCmd.AddCommand(storeReindexCmd)
+}
+
+func runStoreStats(cmd *cobra.Command, args []string) error {
+	storeDir := config.StoreDir()
+	store := capture.NewStore(0, storeDir)
+	u, err := store.Usage()
+	if err != nil {
+		return fmt.Errorf("reading store: %w", err)
+	}
+	if storeStatsTop > 0 && len(u.Hosts) > storeStatsTop {
+		u.Hosts = u.Hosts[:storeStatsTop]
+	}
+	if storeStatsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(u)
+	}
+	fmt.Printf("Store:     %s (%s)\n", storeDir, u.Backend)
+	fmt.Printf("Captures:  %d\n", u.Captures)
+	fmt.Printf("Size:      %s (%s in blobs)\n", formatBytes(u.Bytes), formatBytes(u.BlobBytes))
+	if u.Captures == 0 {
+		return nil
+	}
+	fmt.Printf("Range:     %s → %s\n", u.Oldest.Format(time.RFC3339), u.Newest.Format(time.RFC3339))
+	fmt.Println()
+	fmt.Printf("%-40s  %8s  %10s\n", "HOST", "CAPTURES", "SIZE")
+	for _, h := range u.Hosts {
+		fmt.Printf("%-40s  %8d  %10s\n", h.Host, h.Captures, formatBytes(h.Bytes))
+	}
+	return nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as 2GB, 500MB or 1048576. Units are
+// binary multiples.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range byteUnits {
+		if num, ok := strings.CutSuffix(v, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid size %q: use e.g. 2GB or 500MB", s)
+			}
+			return int64(n * float64(u.size)), nil
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use e.g. 2GB or 500MB", s)
+	}
+	return n, nil
+}
+
+func formatBytes(n int64) string {
+	for _, u := range byteUnits[:len(byteUnits)-1] {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -42,3 +44,22 @@ func parseUntilFlag(s string) (time.Time, error) {
 	}
 	return time.Time{}, fmt.Errorf("invalid --until %q: use RFC3339 or 2006-01-02", s)
 }
+
+// parseAge parses a Go duration, also accepting whole days ("7d").
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q: use e.g. 7d or 12h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q: use e.g. 7d or 12h", s)
+	}
+	return d, nil
+}
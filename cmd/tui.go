@@ -15,7 +15,7 @@ var tuiProxy string
 var tuiCmd = &cobra.Command{
 	Use:   "tui",
 	Short: "Interactive terminal UI for browsing captures",
-	Long:  "Opens a live-updating terminal UI. Browse, inspect, and replay captures with keyboard navigation. Polls the store directory every 2 s for new captures.",
+	Long:  "Opens a live-updating terminal UI. Browse, inspect, and replay captures with keyboard navigation. New captures appear as soon as snare serve saves them; without a running serve the store directory is polled every 2 s.",
 	RunE:  runTUI,
 }
 
@@ -10,6 +10,7 @@ const (
 	DefaultCADir        = ".snare"
 	DefaultMockFile     = ".snare/mocks.json"
 	DefaultInterceptDir = ".snare/intercept"
+	DefaultPolicyFile   = ".snare/policy.json"
 )
 
 func StoreDir() string {
@@ -45,6 +46,17 @@ func InterceptDir() string {
 	return DefaultInterceptDir
 }
 
+func PolicyFile() string {
+	if f := os.Getenv("SNARE_POLICY"); f != "" {
+		return f
+	}
+	home, _ := os.UserHomeDir()
+	if home != "" {
+		return filepath.Join(home, DefaultPolicyFile)
+	}
+	return DefaultPolicyFile
+}
+
 func CADir() string {
 	if d := os.Getenv("SNARE_CA"); d != "" {
 		return d
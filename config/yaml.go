@@ -13,6 +13,11 @@ type FileConfig struct {
 	NoMITM           bool     `yaml:"no_mitm"`
 	Verbose          bool     `yaml:"verbose"`
 	MaxCaptures      int      `yaml:"max_captures"`
+	StoreBackend     string   `yaml:"store_backend"`
+	MaxAge           string   `yaml:"max_age"`
+	MaxStoreSize     string   `yaml:"max_store_size"`
+	MaxPerHost       int      `yaml:"max_per_host"`
+	KeepSessions     bool     `yaml:"keep_sessions"`
 	UpstreamProxy    string   `yaml:"upstream_proxy"`
 	RewriteHost      []string `yaml:"rewrite_host"`
 	AddHeader        []string `yaml:"add_header"`
@@ -27,12 +32,23 @@ type FileConfig struct {
 	MapRemote        []string `yaml:"map_remote"`
 	RewriteBody      []string `yaml:"rewrite_body"`
 	MaxBodySize      int64    `yaml:"max_body_size"`
+	SpillThreshold   *int64   `yaml:"spill_threshold"`
 	Delay            string   `yaml:"delay"`
 	Chaos            float64  `yaml:"chaos"`
 	Shadow           []string `yaml:"shadow"`
 	Plugins          []string `yaml:"plugins"`
 	Web              bool     `yaml:"web"`
 	WebPort          string   `yaml:"web_port"`
+	SocksPort        string   `yaml:"socks_port"`
+	SocksUsers       []string `yaml:"socks_users"`
+	UpstreamCA       string   `yaml:"upstream_ca"`
+	InsecureUpstream []string `yaml:"insecure_upstream"`
+	ClientCerts      []string `yaml:"client_certs"`
+	KeyLog           string   `yaml:"keylog"`
+	Passthrough      []string `yaml:"passthrough"`
+	Block            []string `yaml:"block"`
+	MITM             []string `yaml:"mitm"`
+	PinDetect        *int     `yaml:"pin_detect"`
 }
 
 func ConfigFilePath() string {
@@ -0,0 +1,3 @@
+// Package lockfile serializes updates to files shared between snare
+// processes, such as a running proxy and the CLI.
+package lockfile
@@ -0,0 +1,78 @@
+package mock
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Abort kinds for Fault.Abort.
+const (
+	// AbortClose sends the headers and part of the body, then closes the
+	// connection (on HTTP/2 and HTTP/3, resets the stream).
+	AbortClose = "close"
+	// AbortReset resets the TCP connection without responding.
+	AbortReset = "reset"
+	// AbortMalformed sends a response no HTTP parser accepts.
+	AbortMalformed = "malformed"
+)
+
+// Fault makes a rule misbehave, so client resilience can be tested against
+// one endpoint at a time.
+type Fault struct {
+	// Delay is waited before responding, plus up to Jitter more at random.
+	// Both are durations such as "250ms".
+	Delay  string `json:"delay,omitempty"`
+	Jitter string `json:"jitter,omitempty"`
+	// BytesPerSecond throttles delivery of the body.
+	BytesPerSecond int `json:"bytes_per_second,omitempty"`
+	// Abort is AbortClose, AbortReset, or AbortMalformed.
+	Abort string `json:"abort,omitempty"`
+	// AbortAfter is how many body bytes AbortClose sends; 0 sends half.
+	AbortAfter int `json:"abort_after,omitempty"`
+}
+
+// Validate checks the durations and abort kind.
+func (f *Fault) Validate() error {
+	for _, d := range []string{f.Delay, f.Jitter} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v < 0 {
+			return fmt.Errorf("fault: invalid duration %q", d)
+		}
+	}
+	switch f.Abort {
+	case "", AbortClose, AbortReset, AbortMalformed:
+	default:
+		return fmt.Errorf("fault: unknown abort %q (want close, reset, or malformed)", f.Abort)
+	}
+	if f.BytesPerSecond < 0 || f.AbortAfter < 0 {
+		return fmt.Errorf("fault: negative rate or byte count")
+	}
+	return nil
+}
+
+// Wait returns how long to wait before responding.
+func (f *Fault) Wait() time.Duration {
+	if f == nil {
+		return 0
+	}
+	d, _ := time.ParseDuration(f.Delay)
+	if j, _ := time.ParseDuration(f.Jitter); j > 0 {
+		d += rand.N(j + 1)
+	}
+	return d
+}
+
+// BodyLimit returns how many of size body bytes to send before closing the
+// connection, or size when the body is sent in full.
+func (f *Fault) BodyLimit(size int) int {
+	if f == nil || f.Abort != AbortClose {
+		return size
+	}
+	if f.AbortAfter > 0 {
+		return min(f.AbortAfter, size)
+	}
+	return size / 2
+}
@@ -0,0 +1,73 @@
+package mock
+
+import "time"
+
+// maxHitCaptures is how many capture IDs a rule's hits keep, newest last.
+const maxHitCaptures = 100
+
+// Hits records how a rule has been used since its hits were last reset.
+type Hits struct {
+	Count int       `json:"count"`
+	Last  time.Time `json:"last,omitzero"`
+	// Captures are the IDs of the captures that recorded the matched
+	// requests, up to the last 100.
+	Captures []string `json:"captures,omitempty"`
+}
+
+func (s *Store) hit(id, captureID string) {
+	if s.state.Hits == nil {
+		s.state.Hits = map[string]*Hits{}
+	}
+	h := s.state.Hits[id]
+	if h == nil {
+		h = &Hits{}
+		s.state.Hits[id] = h
+	}
+	h.Count++
+	h.Last = time.Now()
+	if captureID != "" {
+		h.Captures = append(h.Captures, captureID)
+		if n := len(h.Captures); n > maxHitCaptures {
+			h.Captures = h.Captures[n-maxHitCaptures:]
+		}
+	}
+}
+
+// Hits returns the hits of every rule that has matched, by rule ID.
+func (s *Store) Hits() map[string]Hits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.loadState()
+	out := make(map[string]Hits, len(s.state.Hits))
+	for id, h := range s.state.Hits {
+		out[id] = *h
+	}
+	return out
+}
+
+// ResetHits clears the hits of the rules with the given IDs, or of every
+// rule when none are given.
+func (s *Store) ResetHits(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateState(func() bool {
+		if len(ids) == 0 {
+			s.state.Hits = nil
+		}
+		for _, id := range ids {
+			delete(s.state.Hits, id)
+		}
+		return true
+	})
+}
+
+// forget drops the state kept for a removed rule.
+func (s *Store) forget(id string) error {
+	return s.updateState(func() bool {
+		_, hit := s.state.Hits[id]
+		_, pos := s.state.Positions[id]
+		delete(s.state.Hits, id)
+		delete(s.state.Positions, id)
+		return hit || pos
+	})
+}
@@ -0,0 +1,50 @@
+package mock
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mocks.json")
+	s := NewStore(path)
+	_ = s.Add(&Rule{ID: "users", URLPattern: "/users", Responses: []Step{{Status: 500}, {Status: 200}}})
+	_ = s.Add(&Rule{ID: "orders", URLPattern: "/orders", Status: 200})
+
+	s.MatchCapture(httptest.NewRequest("GET", "http://api.test/users", nil), "cap-1")
+	s.MatchCapture(httptest.NewRequest("GET", "http://api.test/users", nil), "cap-2")
+	s.Match(httptest.NewRequest("GET", "http://api.test/users", nil))
+
+	// Another store on the same file stands in for `snare mock verify`.
+	cli := NewStore(path)
+	hits := cli.Hits()
+	if h := hits["users"]; h.Count != 3 || h.Last.IsZero() || !slices.Equal(h.Captures, []string{"cap-1", "cap-2"}) {
+		t.Errorf("users hits = %+v", h)
+	}
+	if h, ok := hits["orders"]; ok {
+		t.Errorf("orders hits = %+v", h)
+	}
+
+	if err := cli.ResetScenarios(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.Hits()["users"].Count; got != 3 {
+		t.Errorf("hits after scenario reset = %d", got)
+	}
+	if err := cli.ResetHits("users"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Hits()["users"].Count; got != 0 {
+		t.Errorf("hits after reset = %d", got)
+	}
+
+	s.Match(httptest.NewRequest("GET", "http://api.test/orders", nil))
+	if _, err := s.Remove("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := cli.Hits()["orders"]; ok {
+		t.Errorf("removed rule still has hits %+v", h)
+	}
+}
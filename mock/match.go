@@ -0,0 +1,303 @@
+package mock
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/muxover/snare/v2/query"
+)
+
+// URL match types for Rule.MatchType. An empty MatchType is MatchContains,
+// which is how rules behaved before match types existed.
+const (
+	MatchContains = "contains"
+	MatchExact    = "exact"
+	MatchGlob     = "glob"
+	MatchRegex    = "regex"
+	MatchPath     = "path"
+)
+
+// maxMatchBody caps how much of a request body JSON matchers read.
+const maxMatchBody = 1 << 20
+
+// Matcher tests one request value: a query parameter, a header, or a
+// JSONPath into a JSON body. It passes when any value equals Value or
+// matches Regex; with neither set, the value only has to be present.
+type Matcher struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Regex string `json:"regex,omitempty"`
+}
+
+// ParseMatcher parses "name", "name=value", or "name~=regex".
+func ParseMatcher(s string) (Matcher, error) {
+	var m Matcher
+	i := strings.IndexByte(s, '=')
+	switch {
+	case i < 0:
+		m.Name = s
+	case i > 0 && s[i-1] == '~':
+		m.Name, m.Regex = s[:i-1], s[i+1:]
+	default:
+		m.Name, m.Value = s[:i], s[i+1:]
+	}
+	if m.Name == "" {
+		return m, fmt.Errorf("matcher %q has no name", s)
+	}
+	if m.Regex != "" {
+		if _, err := compile("regex", m.Regex); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+func (m Matcher) String() string {
+	switch {
+	case m.Regex != "":
+		return m.Name + "~=" + m.Regex
+	case m.Value != "":
+		return m.Name + "=" + m.Value
+	}
+	return m.Name
+}
+
+func (m Matcher) test(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if m.Regex == "" && m.Value == "" {
+		return true
+	}
+	var re *regexp.Regexp
+	if m.Regex != "" {
+		var err error
+		if re, err = compile("regex", m.Regex); err != nil {
+			return false
+		}
+	}
+	for _, v := range values {
+		if re != nil && re.MatchString(v) || re == nil && v == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// validStatus reports whether code can be written as an HTTP status;
+// net/http panics on anything outside 100-999.
+func validStatus(code int) bool {
+	return code >= 100 && code <= 999
+}
+
+// Validate checks the match type and status codes and compiles the rule's
+// patterns and templates.
+func (r *Rule) Validate() error {
+	switch r.MatchType {
+	case "", MatchContains, MatchExact:
+	case MatchGlob, MatchRegex, MatchPath:
+		if _, err := compile(r.MatchType, r.URLPattern); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown match type %q (want contains, exact, glob, regex, or path)", r.MatchType)
+	}
+	for _, m := range append(append([]Matcher{}, r.MatchQuery...), r.MatchHeaders...) {
+		if m.Regex != "" {
+			if _, err := compile("regex", m.Regex); err != nil {
+				return err
+			}
+		}
+	}
+	for _, m := range r.MatchJSON {
+		if _, err := query.CompileJSONPath(m.Name); err != nil {
+			return err
+		}
+		if m.Regex != "" {
+			if _, err := compile("regex", m.Regex); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Status != 0 && !validStatus(r.Status) {
+		return fmt.Errorf("status %d is not between 100 and 999", r.Status)
+	}
+	for _, st := range r.Responses {
+		if st.Status != 0 && !validStatus(st.Status) {
+			return fmt.Errorf("response status %d is not between 100 and 999", st.Status)
+		}
+	}
+	if (r.RequiredState != "" || r.NewState != "") && r.Scenario == "" {
+		return fmt.Errorf("a required or new state needs a scenario")
+	}
+	switch r.SequenceMode {
+	case "", SequenceLast, SequenceCycle:
+	default:
+		return fmt.Errorf("unknown sequence mode %q (want last or cycle)", r.SequenceMode)
+	}
+	for _, f := range r.faults() {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Template {
+		return r.ValidateTemplates()
+	}
+	return nil
+}
+
+func (r *Rule) faults() []*Fault {
+	var out []*Fault
+	if r.Fault != nil {
+		out = append(out, r.Fault)
+	}
+	for _, st := range r.Responses {
+		if st.Fault != nil {
+			out = append(out, st.Fault)
+		}
+	}
+	return out
+}
+
+func (r *Rule) matchURL(req *http.Request) bool {
+	switch r.MatchType {
+	case "", MatchContains:
+		return strings.Contains(req.URL.String(), r.URLPattern)
+	case MatchExact:
+		return urlTarget(req, r.URLPattern) == r.URLPattern
+	case MatchRegex:
+		re, err := compile(r.MatchType, r.URLPattern)
+		return err == nil && re.MatchString(fullURL(req, true))
+	case MatchGlob, MatchPath:
+		re, err := compile(r.MatchType, r.URLPattern)
+		return err == nil && re.MatchString(urlTarget(req, r.URLPattern))
+	}
+	return false
+}
+
+// urlTarget is the part of the request URL an anchored pattern is compared
+// with: the path when pattern starts with "/", otherwise the full URL. The
+// query is included only when pattern has one.
+func urlTarget(req *http.Request, pattern string) string {
+	withQuery := strings.Contains(pattern, "?")
+	if strings.HasPrefix(pattern, "/") {
+		s := req.URL.EscapedPath()
+		if withQuery && req.URL.RawQuery != "" {
+			s += "?" + req.URL.RawQuery
+		}
+		return s
+	}
+	return fullURL(req, withQuery)
+}
+
+// fullURL is the absolute request URL, also for the origin-form requests
+// reverse and MITM modes see.
+func fullURL(req *http.Request, withQuery bool) string {
+	scheme, host := req.URL.Scheme, req.URL.Host
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+	if host == "" {
+		host = req.Host
+	}
+	s := scheme + "://" + host + req.URL.EscapedPath()
+	if withQuery && req.URL.RawQuery != "" {
+		s += "?" + req.URL.RawQuery
+	}
+	return s
+}
+
+var compiled sync.Map // kind + "\x00" + pattern → *regexp.Regexp
+
+// compile turns a regex, glob, or path template into a regexp. Globs and
+// templates are anchored: in a glob * matches within a path segment and **
+// across segments; in a template {name} matches one segment.
+func compile(kind, pattern string) (*regexp.Regexp, error) {
+	key := kind + "\x00" + pattern
+	if re, ok := compiled.Load(key); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	expr := pattern
+	switch kind {
+	case MatchGlob:
+		var b strings.Builder
+		b.WriteString("^")
+		for s := pattern; s != ""; {
+			switch {
+			case strings.HasPrefix(s, "**"):
+				b.WriteString(".*")
+				s = s[2:]
+			case s[0] == '*':
+				b.WriteString("[^/]*")
+				s = s[1:]
+			default:
+				n := strings.IndexByte(s, '*')
+				if n < 0 {
+					n = len(s)
+				}
+				b.WriteString(regexp.QuoteMeta(s[:n]))
+				s = s[n:]
+			}
+		}
+		expr = b.String() + "$"
+	case MatchPath:
+		var b strings.Builder
+		b.WriteString("^")
+		for s := pattern; s != ""; {
+			open := strings.IndexByte(s, '{')
+			if open < 0 {
+				b.WriteString(regexp.QuoteMeta(s))
+				break
+			}
+			end := strings.IndexByte(s[open:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("missing } in path template %q", pattern)
+			}
+			name := s[open+1 : open+end]
+			if !paramName.MatchString(name) {
+				return nil, fmt.Errorf("invalid parameter {%s} in path template %q", name, pattern)
+			}
+			b.WriteString(regexp.QuoteMeta(s[:open]))
+			b.WriteString("(?P<" + name + ">[^/?]+)")
+			s = s[open+end+1:]
+		}
+		expr = b.String() + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	compiled.Store(key, re)
+	return re, nil
+}
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// peekedBody is a request body whose head has been read for matching.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+	head []byte
+}
+
+// requestBody returns up to maxMatchBody bytes of the request body and
+// leaves req.Body readable from the start.
+func requestBody(req *http.Request) []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if p, ok := req.Body.(*peekedBody); ok {
+		return p.head
+	}
+	head, _ := io.ReadAll(io.LimitReader(req.Body, maxMatchBody))
+	req.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(head), req.Body), Closer: req.Body, head: head}
+	return head
+}
@@ -0,0 +1,125 @@
+package mock
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRuleURLMatch(t *testing.T) {
+	tests := []struct {
+		matchType, pattern, url string
+		want                    bool
+	}{
+		{"", "/users/1", "http://api.test/users/10", true},
+		{MatchExact, "/users/1", "http://api.test/users/10", false},
+		{MatchExact, "/users/1", "http://api.test/users/1?page=2", true},
+		{MatchExact, "/users/1?page=2", "http://api.test/users/1?page=3", false},
+		{MatchExact, "http://api.test/users/1", "http://api.test/users/1", true},
+		{MatchGlob, "/users/*", "http://api.test/users/1", true},
+		{MatchGlob, "/users/*", "http://api.test/users/1/orders", false},
+		{MatchGlob, "/users/**", "http://api.test/users/1/orders", true},
+		{MatchGlob, "https://*.test/**", "https://api.test/x", true},
+		{MatchRegex, `/users/\d+$`, "http://api.test/users/42", true},
+		{MatchRegex, `/users/\d+$`, "http://api.test/users/me", false},
+		{MatchPath, "/users/{id}", "http://api.test/users/42", true},
+		{MatchPath, "/users/{id}", "http://api.test/users/42/orders", false},
+		{MatchPath, "/users/{id}/orders/{order}", "http://api.test/users/42/orders/7?x=1", true},
+	}
+	for _, tt := range tests {
+		r := &Rule{URLPattern: tt.pattern, MatchType: tt.matchType}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("%s %q: %v", tt.matchType, tt.pattern, err)
+		}
+		if got := r.Matches(httptest.NewRequest("GET", tt.url, nil)); got != tt.want {
+			t.Errorf("%s %q against %s = %v, want %v", tt.matchType, tt.pattern, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRuleMatchers(t *testing.T) {
+	r := &Rule{URLPattern: "/orders", MatchType: MatchExact}
+	for _, spec := range []struct {
+		dst *[]Matcher
+		s   string
+	}{
+		{&r.MatchQuery, "dry_run"},
+		{&r.MatchHeaders, "X-Tenant=acme"},
+		{&r.MatchJSON, "$.items[0].sku~=^SKU-"},
+		{&r.MatchJSON, "$.total=12.5"},
+	} {
+		m, err := ParseMatcher(spec.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*spec.dst = append(*spec.dst, m)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	const body = `{"items":[{"sku":"SKU-1"}],"total":12.5}`
+	req := httptest.NewRequest("POST", "http://shop.test/orders?dry_run=1", strings.NewReader(body))
+	req.Header.Set("X-Tenant", "acme")
+	if !r.Matches(req) {
+		t.Fatal("request should match")
+	}
+	if got, _ := io.ReadAll(req.Body); string(got) != body {
+		t.Errorf("body after matching = %q", got)
+	}
+
+	req = httptest.NewRequest("POST", "http://shop.test/orders?dry_run=1", strings.NewReader(`{"items":[{"sku":"X"}],"total":12.5}`))
+	req.Header.Set("X-Tenant", "acme")
+	if r.Matches(req) {
+		t.Error("sku regex should not match")
+	}
+	req = httptest.NewRequest("POST", "http://shop.test/orders", strings.NewReader(body))
+	req.Header.Set("X-Tenant", "acme")
+	if r.Matches(req) {
+		t.Error("missing query parameter should not match")
+	}
+}
+
+func TestStoreMatchPriority(t *testing.T) {
+	s := NewStore("")
+	_ = s.Add(&Rule{ID: "any", URLPattern: "/users/"})
+	_ = s.Add(&Rule{ID: "one", URLPattern: "/users/{id}", MatchType: MatchPath, Priority: 10})
+	_ = s.Add(&Rule{ID: "later", URLPattern: "/users/", Priority: 10})
+
+	if got := s.Match(httptest.NewRequest("GET", "http://api.test/users/7", nil)); got == nil || got.ID != "one" {
+		t.Errorf("got %v, want the first priority 10 rule", got)
+	}
+	if got := s.Match(httptest.NewRequest("GET", "http://api.test/users/", nil)); got == nil || got.ID != "later" {
+		t.Errorf("got %v, want the priority 10 contains rule", got)
+	}
+}
+
+func TestStoreReloadDropsOmittedFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mocks.json")
+	s := NewStore(path)
+	_ = s.Add(&Rule{ID: "r", URLPattern: "/a", Method: "POST", Priority: 5})
+	if err := os.WriteFile(path, []byte(`[{"id":"r","url_pattern":"/a"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rules := s.Rules()
+	if len(rules) != 1 || rules[0].Method != "" || rules[0].Priority != 0 {
+		t.Fatalf("reloaded rule kept old fields: %+v", rules[0])
+	}
+}
+
+func TestValidateRejectsBadPatterns(t *testing.T) {
+	for _, r := range []*Rule{
+		{URLPattern: "/users/{id", MatchType: MatchPath},
+		{URLPattern: "/users/{1d}", MatchType: MatchPath},
+		{URLPattern: "(", MatchType: MatchRegex},
+		{URLPattern: "/x", MatchType: "fuzzy"},
+		{URLPattern: "/x", MatchJSON: []Matcher{{Name: "items"}}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v: want an error", r)
+		}
+	}
+}
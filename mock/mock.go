@@ -2,40 +2,102 @@ package mock
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+
+	"github.com/muxover/snare/v2/query"
 )
 
 type Rule struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name,omitempty"`
-	Method      string            `json:"method,omitempty"`
-	URLPattern  string            `json:"url_pattern"`
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Method     string `json:"method,omitempty"`
+	URLPattern string `json:"url_pattern"`
+	// MatchType says how URLPattern is compared with the request URL:
+	// contains (the default), exact, glob, regex, or path.
+	MatchType    string    `json:"match_type,omitempty"`
+	MatchQuery   []Matcher `json:"match_query,omitempty"`
+	MatchHeaders []Matcher `json:"match_headers,omitempty"`
+	// MatchJSON matchers are named by a JSONPath into the request body.
+	MatchJSON []Matcher `json:"match_json,omitempty"`
+	// Priority orders matching rules, highest first; rules with the same
+	// priority keep their order in the file.
+	Priority    int               `json:"priority,omitempty"`
 	Status      int               `json:"status"`
 	Headers     map[string]string `json:"headers,omitempty"`
 	Body        string            `json:"body,omitempty"`
 	ContentType string            `json:"content_type,omitempty"`
+	// Template renders Body, header values, and StatusTemplate as Go
+	// templates over the request; see TemplateData.
+	Template       bool   `json:"template,omitempty"`
+	StatusTemplate string `json:"status_template,omitempty"`
+	// Scenario names a state machine shared by rules. A rule with
+	// RequiredState only matches while its scenario is in that state, and
+	// one with NewState moves the scenario there when it matches.
+	Scenario      string `json:"scenario,omitempty"`
+	RequiredState string `json:"required_state,omitempty"`
+	NewState      string `json:"new_state,omitempty"`
+	// Responses, when set, are served in order, one per match; after the
+	// last, SequenceMode "last" repeats it and "cycle" starts again.
+	Responses    []Step `json:"responses,omitempty"`
+	SequenceMode string `json:"sequence_mode,omitempty"`
+	// Fault adds latency, throttling, or a broken response.
+	Fault *Fault `json:"fault,omitempty"`
 }
 
 func (r *Rule) Matches(req *http.Request) bool {
 	if r.Method != "" && !strings.EqualFold(req.Method, r.Method) {
 		return false
 	}
-	return strings.Contains(req.URL.String(), r.URLPattern)
+	if !r.matchURL(req) {
+		return false
+	}
+	if len(r.MatchQuery) > 0 {
+		q := req.URL.Query()
+		for _, m := range r.MatchQuery {
+			if !m.test(q[m.Name]) {
+				return false
+			}
+		}
+	}
+	for _, m := range r.MatchHeaders {
+		values := req.Header.Values(m.Name)
+		if strings.EqualFold(m.Name, "Host") {
+			values = []string{req.Host}
+		}
+		if !m.test(values) {
+			return false
+		}
+	}
+	if len(r.MatchJSON) > 0 {
+		body := requestBody(req)
+		for _, m := range r.MatchJSON {
+			path, err := query.CompileJSONPath(m.Name)
+			if err != nil || !m.test(path.Select(body)) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 type Store struct {
-	mu    sync.RWMutex
-	path  string
-	rules []*Rule
+	mu        sync.RWMutex
+	path      string
+	rules     []*Rule
+	statePath string
+	state     state
 }
 
 func NewStore(path string) *Store {
-	s := &Store{path: path}
+	s := &Store{path: path, statePath: statePath(path)}
 	_ = s.load()
+	_ = s.loadState()
 	return s
 }
 
@@ -63,7 +125,10 @@ func (s *Store) Remove(id string) (bool, error) {
 	for i, r := range s.rules {
 		if r.ID == id || strings.HasPrefix(r.ID, id) {
 			s.rules = append(s.rules[:i], s.rules[i+1:]...)
-			return true, s.save()
+			if err := s.save(); err != nil {
+				return true, err
+			}
+			return true, s.forget(r.ID)
 		}
 	}
 	return false, nil
@@ -73,21 +138,61 @@ func (s *Store) Clear() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.rules = nil
+	if err := s.updateState(func() bool {
+		s.state = state{}
+		return true
+	}); err != nil {
+		return err
+	}
 	return s.save()
 }
 
+// Match returns the rule to answer req with, recording a hit and advancing
+// its scenario and response sequence. A rule with a sequence comes back as
+// a copy holding the current step.
 func (s *Store) Match(req *http.Request) *Rule {
+	return s.MatchCapture(req, "")
+}
+
+// MatchCapture is Match for a request recorded as capture captureID, which
+// is listed in the rule's hits.
+func (s *Store) MatchCapture(req *http.Request, captureID string) *Rule {
 	s.mu.Lock()
 	_ = s.load()
+	rules := slices.Clone(s.rules)
 	s.mu.Unlock()
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, r := range s.rules {
+
+	// Matching may read the request body, so it runs without the lock.
+	var matched []*Rule
+	for _, r := range rules {
 		if r.Matches(req) {
-			return r
+			matched = append(matched, r)
 		}
 	}
-	return nil
+	if len(matched) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out *Rule
+	err := s.updateState(func() bool {
+		var best *Rule
+		for _, r := range matched {
+			if (best == nil || r.Priority > best.Priority) && s.inState(r) {
+				best = r
+			}
+		}
+		if best == nil {
+			return false
+		}
+		out = s.advance(best, captureID)
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] failed to update mock state: %v\n", err)
+	}
+	return out
 }
 
 func (s *Store) load() error {
@@ -101,7 +206,14 @@ func (s *Store) load() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &s.rules)
+	// Decode into fresh rules so fields the file omits do not keep the
+	// values of the rules loaded before.
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	s.rules = rules
+	return nil
 }
 
 func (s *Store) save() error {
@@ -0,0 +1,248 @@
+package mock
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/muxover/snare/v2/internal/lockfile"
+)
+
+// StartState is the state every scenario begins in and returns to on reset.
+const StartState = "Started"
+
+// Sequence modes for Rule.SequenceMode. The default is SequenceLast.
+const (
+	SequenceLast  = "last"
+	SequenceCycle = "cycle"
+)
+
+// Step is one response in a rule's sequence. It replaces the rule's status
+// and body; headers are added to the rule's, and an empty ContentType keeps
+// the rule's. A zero Status keeps the rule's status.
+type Step struct {
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	// Fault replaces the rule's fault for this step.
+	Fault *Fault `json:"fault,omitempty"`
+}
+
+// state is what changes as rules match: scenario states, how far each
+// rule's sequence has got, and rule hits. It is kept in its own file next
+// to the rules so `snare mock scenario` and the web API can change it under
+// a running proxy.
+type state struct {
+	Scenarios map[string]string `json:"scenarios,omitempty"`
+	// Positions maps a rule ID to the number of times its sequence has
+	// been served.
+	Positions map[string]int   `json:"positions,omitempty"`
+	Hits      map[string]*Hits `json:"hits,omitempty"`
+}
+
+func statePath(rulesPath string) string {
+	if rulesPath == "" {
+		return ""
+	}
+	return strings.TrimSuffix(rulesPath, filepath.Ext(rulesPath)) + ".state.json"
+}
+
+// inState reports whether r's scenario is in the state r requires.
+func (s *Store) inState(r *Rule) bool {
+	return r.Scenario == "" || r.RequiredState == "" || s.scenario(r.Scenario) == r.RequiredState
+}
+
+func (s *Store) scenario(name string) string {
+	if st, ok := s.state.Scenarios[name]; ok {
+		return st
+	}
+	return StartState
+}
+
+// advance records a hit on r and moves its scenario and sequence on, then
+// returns the rule to respond with: r itself, or a copy carrying the
+// current step. The caller saves the state, see updateState.
+func (s *Store) advance(r *Rule, captureID string) *Rule {
+	out := r
+	s.hit(r.ID, captureID)
+	if n := len(r.Responses); n > 0 {
+		pos := s.state.Positions[r.ID]
+		i := min(pos, n-1)
+		if r.SequenceMode == SequenceCycle {
+			i = pos % n
+		}
+		out = r.withStep(r.Responses[i])
+		if s.state.Positions == nil {
+			s.state.Positions = map[string]int{}
+		}
+		if pos < n || r.SequenceMode == SequenceCycle {
+			s.state.Positions[r.ID] = pos + 1
+		}
+	}
+	if r.Scenario != "" && r.NewState != "" && s.scenario(r.Scenario) != r.NewState {
+		if s.state.Scenarios == nil {
+			s.state.Scenarios = map[string]string{}
+		}
+		s.state.Scenarios[r.Scenario] = r.NewState
+	}
+	return out
+}
+
+func (r *Rule) withStep(st Step) *Rule {
+	c := *r
+	if st.Status != 0 {
+		c.Status = st.Status
+	}
+	c.Body = st.Body
+	if st.ContentType != "" {
+		c.ContentType = st.ContentType
+	}
+	if st.Fault != nil {
+		c.Fault = st.Fault
+	}
+	if len(st.Headers) > 0 {
+		c.Headers = maps.Clone(r.Headers)
+		if c.Headers == nil {
+			c.Headers = map[string]string{}
+		}
+		maps.Copy(c.Headers, st.Headers)
+	}
+	return &c
+}
+
+// Scenarios returns the current state of every scenario the rules use or
+// that has been set.
+func (s *Store) Scenarios() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	_ = s.loadState()
+	out := map[string]string{}
+	for _, r := range s.rules {
+		if r.Scenario != "" {
+			out[r.Scenario] = s.scenario(r.Scenario)
+		}
+	}
+	maps.Copy(out, s.state.Scenarios)
+	return out
+}
+
+// SetScenario moves a scenario to state.
+func (s *Store) SetScenario(name, st string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateState(func() bool {
+		if s.state.Scenarios == nil {
+			s.state.Scenarios = map[string]string{}
+		}
+		s.state.Scenarios[name] = st
+		return true
+	})
+}
+
+// ResetScenarios returns the named scenarios to StartState and restarts the
+// sequences of their rules. With no names, every scenario and sequence is
+// reset. Hits are kept.
+func (s *Store) ResetScenarios(names ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	return s.updateState(func() bool {
+		if len(names) == 0 {
+			s.state.Scenarios, s.state.Positions = nil, nil
+		}
+		for _, name := range names {
+			delete(s.state.Scenarios, name)
+			for _, r := range s.rules {
+				if r.Scenario == name {
+					delete(s.state.Positions, r.ID)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// updateState reloads the state file, lets fn change the state and saves it
+// when fn returns true. The state file is locked throughout, so the proxy,
+// the CLI and the web API sharing it do not lose each other's updates.
+func (s *Store) updateState(fn func() bool) error {
+	if s.statePath == "" {
+		fn()
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockfile.Lock(s.statePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.loadState(); err != nil {
+		return err
+	}
+	if !fn() {
+		return nil
+	}
+	return s.saveState()
+}
+
+func (s *Store) loadState() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.statePath)
+	if os.IsNotExist(err) {
+		s.state = state{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	s.state = st
+	return nil
+}
+
+func (s *Store) saveState() error {
+	if s.statePath == "" {
+		return nil
+	}
+	if len(s.state.Scenarios) == 0 && len(s.state.Positions) == 0 && len(s.state.Hits) == 0 {
+		err := os.Remove(s.statePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Readers do not take the lock, so the file is replaced whole.
+	f, err := os.CreateTemp(filepath.Dir(s.statePath), filepath.Base(s.statePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), s.statePath); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package mock
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func statuses(s *Store, method, url string, n int) []int {
+	var out []int
+	for range n {
+		r := s.Match(httptest.NewRequest(method, url, nil))
+		if r == nil {
+			out = append(out, 0)
+			continue
+		}
+		out = append(out, r.Respond(httptest.NewRequest(method, url, nil)).Status)
+	}
+	return out
+}
+
+func TestSequence(t *testing.T) {
+	s := NewStore("")
+	_ = s.Add(&Rule{ID: "retry", URLPattern: "/flaky", Status: 200, Responses: []Step{{Status: 503}, {Status: 503}, {Status: 200, Body: "ok"}}})
+	_ = s.Add(&Rule{ID: "cycle", URLPattern: "/cycle", SequenceMode: SequenceCycle, Responses: []Step{{Status: 201}, {Status: 202}}})
+
+	if got := statuses(s, "GET", "http://api.test/flaky", 4); !slices.Equal(got, []int{503, 503, 200, 200}) {
+		t.Errorf("last mode = %v", got)
+	}
+	if got := statuses(s, "GET", "http://api.test/cycle", 3); !slices.Equal(got, []int{201, 202, 201}) {
+		t.Errorf("cycle mode = %v", got)
+	}
+	if err := s.ResetScenarios(); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(s, "GET", "http://api.test/flaky", 1); !slices.Equal(got, []int{503}) {
+		t.Errorf("after reset = %v", got)
+	}
+}
+
+func TestScenarioSharedThroughFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mocks.json")
+	proxy := NewStore(path)
+	_ = proxy.Add(&Rule{ID: "anon", URLPattern: "/me", Scenario: "auth", RequiredState: StartState, Status: 401})
+	_ = proxy.Add(&Rule{ID: "login", Method: "POST", URLPattern: "/login", Scenario: "auth", NewState: "in", Status: 204})
+	_ = proxy.Add(&Rule{ID: "me", URLPattern: "/me", Scenario: "auth", RequiredState: "in", Status: 200})
+
+	if got := statuses(proxy, "GET", "http://api.test/me", 1); !slices.Equal(got, []int{401}) {
+		t.Errorf("before login = %v", got)
+	}
+	statuses(proxy, "POST", "http://api.test/login", 1)
+	if got := statuses(proxy, "GET", "http://api.test/me", 1); !slices.Equal(got, []int{200}) {
+		t.Errorf("after login = %v", got)
+	}
+
+	// A second store on the same file stands in for `snare mock scenario`.
+	cli := NewStore(path)
+	if got := cli.Scenarios()["auth"]; got != "in" {
+		t.Errorf("scenario state seen by another store = %q", got)
+	}
+	if err := cli.ResetScenarios("auth"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(proxy, "GET", "http://api.test/me", 1); !slices.Equal(got, []int{401}) {
+		t.Errorf("after reset = %v", got)
+	}
+	if err := cli.SetScenario("auth", "in"); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(proxy, "GET", "http://api.test/me", 1); !slices.Equal(got, []int{200}) {
+		t.Errorf("after set = %v", got)
+	}
+}
+
+func TestSequenceSharedConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mocks.json")
+	var steps []Step
+	for i := range 40 {
+		steps = append(steps, Step{Status: 200 + i})
+	}
+	_ = NewStore(path).Add(&Rule{ID: "seq", URLPattern: "/seq", Responses: steps})
+
+	// Two stores on one file stand in for the proxy and a second process.
+	stores := []*Store{NewStore(path), NewStore(path)}
+	got := make(chan int, len(steps))
+	var wg sync.WaitGroup
+	for i := range len(steps) {
+		wg.Go(func() {
+			got <- statuses(stores[i%2], "GET", "http://api.test/seq", 1)[0]
+		})
+	}
+	wg.Wait()
+	close(got)
+	var all []int
+	for st := range got {
+		all = append(all, st)
+	}
+	slices.Sort(all)
+	for i, st := range all {
+		if st != 200+i {
+			t.Fatalf("steps served = %v", all)
+		}
+	}
+	if n := NewStore(path).Hits()["seq"].Count; n != len(steps) {
+		t.Fatalf("hits = %d", n)
+	}
+}
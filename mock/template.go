@@ -0,0 +1,281 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/muxover/snare/v2/query"
+)
+
+// Response is what a rule answers one request with.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// TemplateData is dot in a rule's response templates.
+type TemplateData struct {
+	Method string
+	URL    string
+	Path   string
+	// Params holds the {name} parameters of a path template rule.
+	Params map[string]string
+	// Query and Headers hold the first value of each parameter and header;
+	// header names are canonical, as in X-Request-Id.
+	Query   map[string]string
+	Headers map[string]string
+	Body    string
+	// JSON is the parsed request body, or nil when it is not JSON.
+	JSON any
+
+	body []byte
+}
+
+// Respond builds the rule's response to req. For a Template rule the body,
+// header values, and StatusTemplate are rendered with req's data; a
+// template that fails renders as a 500 naming the error, so a broken stub
+// shows up in the client rather than silently.
+func (r *Rule) Respond(req *http.Request) *Response {
+	resp := &Response{Status: r.Status, Header: make(http.Header), Body: []byte(r.Body)}
+	ct := r.ContentType
+	if ct == "" {
+		ct = "application/json"
+	}
+	resp.Header.Set("Content-Type", ct)
+	for k, v := range r.Headers {
+		resp.Header.Set(k, v)
+	}
+	if r.Template {
+		if err := r.render(req, resp); err != nil {
+			return &Response{
+				Status: http.StatusInternalServerError,
+				Header: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+				Body:   []byte(fmt.Sprintf("snare: mock rule %s: %v\n", r.ID, err)),
+			}
+		}
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	return resp
+}
+
+func (r *Rule) render(req *http.Request, resp *Response) error {
+	data := newTemplateData(req, r.PathParams(req))
+	body, err := execute(r.Body, data)
+	if err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	resp.Body = []byte(body)
+	for k, v := range r.Headers {
+		s, err := execute(v, data)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		resp.Header.Set(k, s)
+	}
+	if r.StatusTemplate != "" {
+		s, err := execute(r.StatusTemplate, data)
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		if resp.Status, err = strconv.Atoi(strings.TrimSpace(s)); err != nil {
+			return fmt.Errorf("status %q is not a number", s)
+		}
+		if !validStatus(resp.Status) {
+			return fmt.Errorf("status %d is not between 100 and 999", resp.Status)
+		}
+	}
+	return nil
+}
+
+// PathParams returns the {name} parameters of a path template rule.
+func (r *Rule) PathParams(req *http.Request) map[string]string {
+	params := map[string]string{}
+	if r.MatchType != MatchPath {
+		return params
+	}
+	re, err := compile(MatchPath, r.URLPattern)
+	if err != nil {
+		return params
+	}
+	m := re.FindStringSubmatch(urlTarget(req, r.URLPattern))
+	for i, name := range re.SubexpNames() {
+		if name != "" && i < len(m) {
+			params[name] = m[i]
+		}
+	}
+	return params
+}
+
+func newTemplateData(req *http.Request, params map[string]string) *TemplateData {
+	d := &TemplateData{
+		Method:  req.Method,
+		URL:     fullURL(req, true),
+		Path:    req.URL.Path,
+		Params:  params,
+		Query:   map[string]string{},
+		Headers: map[string]string{},
+		body:    requestBody(req),
+	}
+	for k, v := range req.URL.Query() {
+		d.Query[k] = v[0]
+	}
+	for k, v := range req.Header {
+		d.Headers[k] = v[0]
+	}
+	d.Headers["Host"] = req.Host
+	d.Body = string(d.body)
+	// Numbers stay json.Number so large IDs echo back exactly.
+	dec := json.NewDecoder(bytes.NewReader(d.body))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) == nil {
+		d.JSON = v
+	}
+	return d
+}
+
+// ValidateTemplates parses the rule's templates.
+func (r *Rule) ValidateTemplates() error {
+	srcs := []string{r.Body, r.StatusTemplate}
+	for _, v := range r.Headers {
+		srcs = append(srcs, v)
+	}
+	for _, st := range r.Responses {
+		srcs = append(srcs, st.Body)
+		for _, v := range st.Headers {
+			srcs = append(srcs, v)
+		}
+	}
+	for _, src := range srcs {
+		if _, err := parseTemplate(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var templates sync.Map // source → *template.Template
+
+func parseTemplate(src string) (*template.Template, error) {
+	if t, ok := templates.Load(src); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("mock").Funcs(templateFuncs).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(src, t)
+	return t, nil
+}
+
+func execute(src string, data *TemplateData) (string, error) {
+	if !strings.Contains(src, "{{") {
+		return src, nil
+	}
+	t, err := parseTemplate(src)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var (
+	firstNames = []string{"Ada", "Alan", "Grace", "Linus", "Margaret", "Ken", "Barbara", "Dennis", "Frances", "Edsger"}
+	lastNames  = []string{"Lovelace", "Turing", "Hopper", "Torvalds", "Hamilton", "Thompson", "Liskov", "Ritchie", "Allen", "Dijkstra"}
+	cities     = []string{"Lisbon", "Nairobi", "Osaka", "Toronto", "Berlin", "Lima", "Oslo", "Auckland", "Austin", "Pune"}
+	words      = []string{"alpha", "bravo", "delta", "echo", "falcon", "harbor", "lumen", "nova", "orbit", "quartz"}
+)
+
+var templateFuncs = template.FuncMap{
+	"uuid":      uuid.NewString,
+	"now":       func() time.Time { return time.Now().UTC() },
+	"timestamp": func() string { return time.Now().UTC().Format(time.RFC3339) },
+	"unix":      func() int64 { return time.Now().Unix() },
+	"unixMilli": func() int64 { return time.Now().UnixMilli() },
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.IntN(max-min+1)
+	},
+	"randString": func(n int) string {
+		const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = letters[rand.IntN(len(letters))]
+		}
+		return string(b)
+	},
+	"pick": func(items ...any) any {
+		if len(items) == 0 {
+			return nil
+		}
+		return items[rand.IntN(len(items))]
+	},
+	"fakeName":      func() string { return pick(firstNames) + " " + pick(lastNames) },
+	"fakeFirstName": func() string { return pick(firstNames) },
+	"fakeLastName":  func() string { return pick(lastNames) },
+	"fakeEmail": func() string {
+		return strings.ToLower(pick(firstNames)+"."+pick(lastNames)) + "@example.com"
+	},
+	"fakeCity": func() string { return pick(cities) },
+	"fakeWord": func() string { return pick(words) },
+	// json encodes a value, so {{json .JSON}} echoes the request body.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// jsonPath selects from the request body like query expressions do:
+	// {{jsonPath . "$.items[0].sku"}}.
+	"jsonPath": func(d *TemplateData, path string) (string, error) {
+		p, err := query.CompileJSONPath(path)
+		if err != nil {
+			return "", err
+		}
+		if v := p.Select(d.body); len(v) > 0 {
+			return v[0], nil
+		}
+		return "", nil
+	},
+	// set returns a copy of a JSON object with key set:
+	// {{json (set .JSON "id" uuid)}}.
+	"set": func(obj any, key string, value any) (map[string]any, error) {
+		out := map[string]any{}
+		if obj != nil {
+			m, ok := obj.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("set: %T is not a JSON object", obj)
+			}
+			maps.Copy(out, m)
+		}
+		out[key] = value
+		return out, nil
+	},
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+func pick(items []string) string {
+	return items[rand.IntN(len(items))]
+}
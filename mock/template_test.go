@@ -0,0 +1,91 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRespondTemplate(t *testing.T) {
+	r := &Rule{
+		ID:             "orders",
+		Method:         "POST",
+		URLPattern:     "/shops/{shop}/orders",
+		MatchType:      MatchPath,
+		Template:       true,
+		Body:           `{{json (set .JSON "id" uuid)}}`,
+		StatusTemplate: `{{if .Query.dry_run}}200{{else}}201{{end}}`,
+		Headers: map[string]string{
+			"Location":     "/shops/{{.Params.shop}}/orders/{{jsonPath . \"$.items[0].sku\"}}",
+			"X-Request-Id": `{{index .Headers "X-Request-Id"}}`,
+		},
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "http://api.test/shops/s1/orders", strings.NewReader(`{"items":[{"sku":"A-1"}]}`))
+	req.Header.Set("X-Request-Id", "abc")
+	if !r.Matches(req) {
+		t.Fatal("rule should match")
+	}
+	resp := r.Respond(req)
+	if resp.Status != 201 {
+		t.Errorf("status = %d, want 201", resp.Status)
+	}
+	if got := resp.Header.Get("Location"); got != "/shops/s1/orders/A-1" {
+		t.Errorf("Location = %q", got)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "abc" {
+		t.Errorf("X-Request-Id = %q", got)
+	}
+	var order struct {
+		ID    string           `json:"id"`
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(resp.Body, &order); err != nil {
+		t.Fatalf("body %s: %v", resp.Body, err)
+	}
+	if _, err := uuid.Parse(order.ID); err != nil || len(order.Items) != 1 {
+		t.Errorf("body = %s", resp.Body)
+	}
+
+	req = httptest.NewRequest("POST", "http://api.test/shops/s1/orders?dry_run=1", strings.NewReader(`{}`))
+	if got := r.Respond(req).Status; got != 200 {
+		t.Errorf("dry run status = %d, want 200", got)
+	}
+
+	req = httptest.NewRequest("POST", "http://api.test/shops/s1/orders", strings.NewReader(`{"customer":9007199254740993}`))
+	if body := string(r.Respond(req).Body); !strings.Contains(body, `"customer":9007199254740993`) {
+		t.Errorf("large integer not echoed exactly: %s", body)
+	}
+}
+
+func TestRespondStaticAndErrors(t *testing.T) {
+	static := &Rule{ID: "static", Body: "{{not a template}}"}
+	resp := static.Respond(httptest.NewRequest("GET", "http://api.test/", nil))
+	if resp.Status != 200 || string(resp.Body) != "{{not a template}}" || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("static rule = %d %q %v", resp.Status, resp.Body, resp.Header)
+	}
+
+	for _, st := range []string{"42", "1000"} {
+		r := &Rule{ID: "range", Template: true, StatusTemplate: st}
+		if resp := r.Respond(httptest.NewRequest("GET", "http://api.test/", nil)); resp.Status != 500 {
+			t.Errorf("status template %s = %d, want 500", st, resp.Status)
+		}
+	}
+	if err := (&Rule{URLPattern: "/x", Status: 1000}).Validate(); err == nil {
+		t.Error("status 1000 passed validation")
+	}
+
+	broken := &Rule{ID: "broken", Template: true, StatusTemplate: "{{.Method}}"}
+	resp = broken.Respond(httptest.NewRequest("GET", "http://api.test/", nil))
+	if resp.Status != 500 || !strings.Contains(string(resp.Body), "broken") {
+		t.Errorf("broken rule = %d %q", resp.Status, resp.Body)
+	}
+	if err := (&Rule{Template: true, Body: "{{.Nope"}).Validate(); err == nil {
+		t.Error("Validate should reject a malformed template")
+	}
+}
@@ -0,0 +1,113 @@
+// Package pcapng writes snare captures as a pcapng file of synthetic,
+// already decrypted TCP flows that Wireshark and other pcap tools can read.
+package pcapng
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+	linkEthernet   = 1
+
+	optEnd        = 0
+	optComment    = 1
+	optUserAppl   = 4
+	optIfName     = 2
+	optIfTSResol  = 9
+	tsResolMicros = 6
+)
+
+var le = binary.LittleEndian
+
+// Writer writes a single-section, single-interface pcapng stream of
+// Ethernet frames with microsecond timestamps.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter writes the section and interface headers to w.
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: bufio.NewWriter(w)}
+
+	var shb []byte
+	shb = le.AppendUint32(shb, byteOrderMagic)
+	shb = le.AppendUint16(shb, 1) // major version
+	shb = le.AppendUint16(shb, 0) // minor version
+	shb = le.AppendUint64(shb, ^uint64(0))
+	shb = appendOption(shb, optUserAppl, []byte("snare"))
+	shb = le.AppendUint32(shb, optEnd)
+	pw.block(blockSHB, shb)
+
+	var idb []byte
+	idb = le.AppendUint16(idb, linkEthernet)
+	idb = le.AppendUint16(idb, 0)
+	idb = le.AppendUint32(idb, 0) // no snap length
+	idb = appendOption(idb, optIfName, []byte("snare"))
+	idb = appendOption(idb, optIfTSResol, []byte{tsResolMicros})
+	idb = le.AppendUint32(idb, optEnd)
+	pw.block(blockIDB, idb)
+	return pw
+}
+
+// WritePacket writes one Enhanced Packet Block, with comment attached
+// when it is not empty.
+func (pw *Writer) WritePacket(ts time.Time, frame []byte, comment string) error {
+	us := uint64(ts.UnixMicro())
+	var epb []byte
+	epb = le.AppendUint32(epb, 0) // interface
+	epb = le.AppendUint32(epb, uint32(us>>32))
+	epb = le.AppendUint32(epb, uint32(us))
+	epb = le.AppendUint32(epb, uint32(len(frame)))
+	epb = le.AppendUint32(epb, uint32(len(frame)))
+	epb = append(epb, frame...)
+	epb = pad(epb)
+	if comment != "" {
+		epb = appendOption(epb, optComment, []byte(comment))
+		epb = le.AppendUint32(epb, optEnd)
+	}
+	pw.block(blockEPB, epb)
+	return pw.err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (pw *Writer) Flush() error {
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *Writer) block(typ uint32, body []byte) {
+	if pw.err != nil {
+		return
+	}
+	total := uint32(12 + len(body))
+	var b []byte
+	b = le.AppendUint32(b, typ)
+	b = le.AppendUint32(b, total)
+	b = append(b, body...)
+	b = le.AppendUint32(b, total)
+	_, pw.err = pw.w.Write(b)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = le.AppendUint16(b, code)
+	b = le.AppendUint16(b, uint16(len(value)))
+	return pad(append(b, value...))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
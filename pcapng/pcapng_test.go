@@ -0,0 +1,154 @@
+package pcapng
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// streams parses a pcapng file written by WriteCaptures and reassembles
+// the payload each side sent, per client port.
+func streams(t *testing.T, data []byte) (map[uint16][2][]byte, []string) {
+	t.Helper()
+	out := map[uint16][2][]byte{}
+	var comments []string
+	var last time.Time
+	for len(data) > 0 {
+		typ, total := le.Uint32(data), le.Uint32(data[4:])
+		if total < 12 || int(total) > len(data) || le.Uint32(data[total-4:]) != total {
+			t.Fatalf("bad block length %d", total)
+		}
+		body := data[8 : total-4]
+		data = data[total:]
+		if typ != blockEPB {
+			continue
+		}
+		us := int64(le.Uint32(body[4:]))<<32 | int64(le.Uint32(body[8:]))
+		ts := time.UnixMicro(us)
+		if ts.Before(last) {
+			t.Fatal("packets out of order")
+		}
+		last = ts
+		n := le.Uint32(body[12:])
+		frame := body[20 : 20+n]
+		if opts := body[20+(n+3)/4*4:]; len(opts) > 4 && le.Uint16(opts) == optComment {
+			comments = append(comments, string(opts[4:4+le.Uint16(opts[2:])]))
+		}
+		ip := frame[14:]
+		if checksum(ip[:20], 0) != 0 {
+			t.Fatal("bad IPv4 checksum")
+		}
+		tcp := ip[20:binary.BigEndian.Uint16(ip[2:])]
+		pseudo := append(append([]byte{}, ip[12:20]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+		if checksum(tcp, sum(pseudo)) != 0 {
+			t.Fatal("bad TCP checksum")
+		}
+		src, dst := binary.BigEndian.Uint16(tcp), binary.BigEndian.Uint16(tcp[2:])
+		payload := tcp[20:]
+		if dst == 80 {
+			s := out[src]
+			s[0] = append(s[0], payload...)
+			out[src] = s
+		} else {
+			s := out[dst]
+			s[1] = append(s[1], payload...)
+			out[dst] = s
+		}
+	}
+	return out, comments
+}
+
+func TestWriteCaptures(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	big := bytes.Repeat([]byte("x"), 5000)
+	captures := []*capture.Capture{
+		{
+			ID: "h1", Timestamp: start, Duration: 20 * time.Millisecond,
+			Request: capture.RequestSnapshot{Method: "POST", URL: "https://api.example.com/items?x=1",
+				Headers: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"a":1}`)},
+			Response: &capture.ResponseSnapshot{StatusCode: 200,
+				Headers: http.Header{"Content-Encoding": {"gzip"}, "Content-Length": {"12"}}, Body: big},
+			Conn: &capture.ConnInfo{Client: "192.0.2.10:51000", Origin: "198.51.100.7:443"},
+		},
+		{
+			ID: "h2", Timestamp: start.Add(5 * time.Millisecond), Protocol: "h2", Duration: 10 * time.Millisecond,
+			Request:  capture.RequestSnapshot{Method: "GET", URL: "https://api.example.com/h2", Headers: http.Header{"Accept": {"*/*"}}},
+			Response: &capture.ResponseSnapshot{StatusCode: 404, Headers: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("missing")},
+		},
+		{
+			ID: "ws", Timestamp: start.Add(time.Second), Duration: time.Millisecond,
+			Request:  capture.RequestSnapshot{Method: "GET", URL: "http://chat.example.com/ws", Headers: http.Header{"Upgrade": {"websocket"}}},
+			Response: &capture.ResponseSnapshot{StatusCode: 101, Headers: http.Header{"Upgrade": {"websocket"}}},
+			WebSocket: &capture.WebSocketCapture{Frames: []capture.WSFrame{
+				{Timestamp: start.Add(2 * time.Second), Direction: "c2s", Opcode: 1, Payload: []byte("hello")},
+				{Timestamp: start.Add(3 * time.Second), Direction: "s2c", Opcode: 1, Payload: []byte("world")},
+			}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteCaptures(&buf, captures); err != nil {
+		t.Fatal(err)
+	}
+	got, comments := streams(t, buf.Bytes())
+	if len(got) != 3 || len(comments) != 3 || !strings.HasPrefix(comments[0], "snare h1 POST https://api.example.com/items") {
+		t.Fatalf("streams = %d, comments = %q", len(got), comments)
+	}
+
+	// HTTP/1.1: the stored, decoded body with a matching Content-Length.
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(got[49152][0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if req.URL.String() != "/items?x=1" || req.Host != "api.example.com" || string(body) != `{"a":1}` {
+		t.Fatalf("request = %s %s %q", req.Host, req.URL, body)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(got[49152][1])), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, big) {
+		t.Fatalf("response headers = %v, body length %d", resp.Header, len(body))
+	}
+
+	// HTTP/2: the server's HEADERS and DATA on stream 1.
+	fr := http2.NewFramer(nil, bytes.NewReader(got[49153][1]))
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	var status, data string
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			break
+		}
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			status = f.PseudoValue("status")
+		case *http2.DataFrame:
+			data += string(f.Data())
+		}
+	}
+	if !bytes.HasPrefix(got[49153][0], []byte(http2.ClientPreface)) || status != "404" || data != "missing" {
+		t.Fatalf("h2 status = %q, data = %q", status, data)
+	}
+
+	// WebSocket: the upgrade, then a masked client frame.
+	client := got[49154][0]
+	i := bytes.Index(client, []byte("\r\n\r\n"))
+	frame := client[i+4:]
+	if frame[0] != 0x81 || frame[1] != 0x80|5 || len(frame) != 2+4+5 {
+		t.Fatalf("client frame = %x", frame)
+	}
+	if !bytes.HasSuffix(got[49154][1], []byte{0x81, 5, 'w', 'o', 'r', 'l', 'd'}) {
+		t.Fatalf("server bytes = %q", got[49154][1])
+	}
+}
@@ -0,0 +1,320 @@
+package pcapng
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// WriteCaptures writes each capture to w as its own plaintext TCP
+// connection to port 80, with packets in timestamp order. HTTP/2 and
+// HTTP/3 captures use HTTP/2 framing with prior knowledge, WebSocket
+// captures an HTTP/1.1 upgrade followed by their frames. The SYN of each
+// connection carries the capture ID and URL as a packet comment. Bodies
+// must already be loaded.
+func WriteCaptures(w io.Writer, captures []*capture.Capture) error {
+	var packets []packet
+	for i, c := range captures {
+		packets = append(packets, render(c, i)...)
+	}
+	sort.SliceStable(packets, func(a, b int) bool { return packets[a].ts.Before(packets[b].ts) })
+	pw := NewWriter(w)
+	for _, p := range packets {
+		if err := pw.WritePacket(p.ts, p.frame, p.comment); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+func render(c *capture.Capture, i int) []packet {
+	client, server := endpoints(c, i)
+	f := newFlow(client, server)
+	t := phases(c)
+	f.open(t.open, t.established, fmt.Sprintf("snare %s %s %s", c.ID, c.Request.Method, c.Request.URL))
+
+	last := t.end
+	switch {
+	case c.WebSocket != nil:
+		f.send(t.request, t.request, true, http1Request(c))
+		if c.Response != nil {
+			f.send(t.response, t.response, false, http1ResponseHead(c.Response.StatusCode, c.Response.Headers))
+		}
+		for _, fr := range c.WebSocket.Frames {
+			f.send(fr.Timestamp, fr.Timestamp, fr.Direction == "c2s", wsFrame(fr, fr.Direction == "c2s"))
+			last = later(last, fr.Timestamp)
+		}
+	case c.Protocol == "h2" || c.Protocol == "h3":
+		last = renderH2(f, c, t)
+	default:
+		f.send(t.request, t.request.Add(t.send), true, http1Request(c))
+		if c.Response == nil {
+			break
+		}
+		if frames := sseFrames(c); frames != nil {
+			h := c.Response.Headers.Clone()
+			h.Del("Content-Length")
+			h.Set("Connection", "close")
+			f.send(t.response, t.response, false, http1ResponseHead(c.Response.StatusCode, h))
+			for _, fr := range frames {
+				f.send(fr.Timestamp, fr.Timestamp, false, sseEvent(fr))
+				last = later(last, fr.Timestamp)
+			}
+			break
+		}
+		body := c.Response.Body
+		head := http1ResponseHead(c.Response.StatusCode, fixedLength(c.Response.Headers, body))
+		f.send(t.response, t.end, false, append(head, body...))
+	}
+	f.close(last)
+	return f.packets
+}
+
+// endpoints keeps the client and origin addresses the capture recorded
+// when both are of one family, and gives every capture its own client port.
+func endpoints(c *capture.Capture, i int) (netip.AddrPort, netip.AddrPort) {
+	client, server := netip.AddrFrom4([4]byte{10, 0, 0, 1}), netip.AddrFrom4([4]byte{10, 0, 0, 2})
+	var clientAddr, originAddr string
+	if c.Conn != nil {
+		clientAddr, originAddr = c.Conn.Client, c.Conn.Origin
+	}
+	if originAddr == "" && c.Timings != nil {
+		originAddr = c.Timings.RemoteAddr
+	}
+	ca, err1 := netip.ParseAddrPort(clientAddr)
+	oa, err2 := netip.ParseAddrPort(originAddr)
+	if err1 == nil && err2 == nil && ca.Addr().Unmap().Is4() == oa.Addr().Unmap().Is4() {
+		client, server = ca.Addr().Unmap(), oa.Addr().Unmap()
+	}
+	return netip.AddrPortFrom(client, uint16(49152+i%16384)), netip.AddrPortFrom(server, 80)
+}
+
+type timeline struct {
+	open, established, request, response, end time.Time
+	send                                      time.Duration
+}
+
+// phases places the connection, request, and response on the capture's
+// recorded timings, or on its start and duration when it has none.
+func phases(c *capture.Capture) timeline {
+	start := c.Timestamp
+	if tm := c.Timings; tm != nil {
+		open := start.Add(tm.Blocked + tm.DNS)
+		established := open.Add(tm.Connect + tm.TLS)
+		response := established.Add(tm.Send + tm.Wait)
+		return timeline{open, established, established, response, response.Add(tm.Receive), tm.Send}
+	}
+	end := start.Add(c.Duration)
+	return timeline{start, start, start, end, end, 0}
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func http1Request(c *capture.Capture) []byte {
+	target, host := "/", ""
+	if u, err := url.Parse(c.Request.URL); err == nil {
+		target, host = u.RequestURI(), u.Host
+		if c.Request.Method == http.MethodConnect {
+			target = u.Host
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", c.Request.Method, target, host)
+	h := c.Request.Headers
+	if c.WebSocket == nil {
+		h = fixedLength(h, c.Request.Body)
+	}
+	_ = h.WriteSubset(&b, map[string]bool{"Host": true})
+	b.WriteString("\r\n")
+	if c.WebSocket == nil {
+		b.Write(c.Request.Body)
+	}
+	return b.Bytes()
+}
+
+func http1ResponseHead(status int, h http.Header) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = h.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// fixedLength returns headers describing body as stored: decoded, and
+// sent with a Content-Length.
+func fixedLength(h http.Header, body []byte) http.Header {
+	out := h.Clone()
+	if out == nil {
+		out = http.Header{}
+	}
+	out.Del("Content-Encoding")
+	out.Del("Transfer-Encoding")
+	out.Del("Content-Length")
+	if len(body) > 0 {
+		out.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return out
+}
+
+// sseFrames returns the events of a streamed response whose body was not
+// kept, or nil.
+func sseFrames(c *capture.Capture) []capture.SSEFrame {
+	if c.SSE == nil || len(c.SSE.Frames) == 0 || len(c.Response.Body) > 0 {
+		return nil
+	}
+	return c.SSE.Frames
+}
+
+func sseEvent(fr capture.SSEFrame) []byte {
+	var b strings.Builder
+	if fr.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", fr.ID)
+	}
+	if fr.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", fr.Event)
+	}
+	for _, line := range strings.Split(fr.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// wsFrame encodes a single unfragmented frame, masked as clients must.
+func wsFrame(fr capture.WSFrame, masked bool) []byte {
+	b := []byte{0x80 | byte(fr.Opcode&0x0f)}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(fr.Payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n < 1<<16:
+		b = append(b, maskBit|126, byte(n>>8), byte(n))
+	default:
+		b = append(b, maskBit|127)
+		for shift := 56; shift >= 0; shift -= 8 {
+			b = append(b, byte(uint64(n)>>shift))
+		}
+	}
+	if !masked {
+		return append(b, fr.Payload...)
+	}
+	key := [4]byte{0x5e, 0x1f, 0x0a, 0x3c}
+	b = append(b, key[:]...)
+	for i, p := range fr.Payload {
+		b = append(b, p^key[i%4])
+	}
+	return b
+}
+
+// hopHeaders may not appear in HTTP/2.
+var hopHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "host"}
+
+func renderH2(f *flow, c *capture.Capture, t timeline) time.Time {
+	scheme, authority, path := "https", "", "/"
+	if u, err := url.Parse(c.Request.URL); err == nil {
+		scheme, authority, path = u.Scheme, u.Host, u.RequestURI()
+	}
+
+	var out bytes.Buffer
+	out.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(&out, nil)
+	_ = fr.WriteSettings()
+	writeH2Headers(fr, len(c.Request.Body) == 0, [][2]string{
+		{":method", c.Request.Method}, {":scheme", scheme}, {":authority", authority}, {":path", path},
+	}, fixedLength(c.Request.Headers, c.Request.Body))
+	writeH2Data(fr, c.Request.Body, true)
+	f.send(t.request, t.request.Add(t.send), true, out.Bytes())
+
+	last := t.end
+	if c.Response == nil {
+		return last
+	}
+	out.Reset()
+	_ = fr.WriteSettings()
+	_ = fr.WriteSettingsAck()
+	status := [][2]string{{":status", strconv.Itoa(c.Response.StatusCode)}}
+	if frames := sseFrames(c); frames != nil {
+		h := c.Response.Headers.Clone()
+		h.Del("Content-Length")
+		writeH2Headers(fr, false, status, h)
+		f.send(t.response, t.response, false, out.Bytes())
+		for i, ev := range frames {
+			out.Reset()
+			writeH2Data(fr, sseEvent(ev), i == len(frames)-1)
+			f.send(ev.Timestamp, ev.Timestamp, false, out.Bytes())
+			last = later(last, ev.Timestamp)
+		}
+		return last
+	}
+	body := c.Response.Body
+	writeH2Headers(fr, len(body) == 0, status, fixedLength(c.Response.Headers, body))
+	writeH2Data(fr, body, true)
+	f.send(t.response, t.end, false, out.Bytes())
+	return last
+}
+
+// writeH2Headers writes a HEADERS frame on stream 1, continued in
+// CONTINUATION frames when the block is larger than one frame. Each side
+// sends one header block per connection, so each gets a fresh encoder.
+func writeH2Headers(fr *http2.Framer, endStream bool, pseudo [][2]string, h http.Header) {
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, kv := range pseudo {
+		_ = enc.WriteField(hpack.HeaderField{Name: kv[0], Value: kv[1]})
+	}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := strings.ToLower(k)
+		if slices.Contains(hopHeaders, name) {
+			continue
+		}
+		for _, v := range h[k] {
+			_ = enc.WriteField(hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	b := block.Bytes()
+	const maxFrame = 16384
+	first := b[:min(len(b), maxFrame)]
+	b = b[len(first):]
+	_ = fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: first, EndStream: endStream, EndHeaders: len(b) == 0})
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxFrame)]
+		b = b[len(chunk):]
+		_ = fr.WriteContinuation(1, len(b) == 0, chunk)
+	}
+}
+
+// writeH2Data writes body as DATA frames on stream 1. An empty body
+// writes nothing; its HEADERS frame ends the stream instead.
+func writeH2Data(fr *http2.Framer, body []byte, endStream bool) {
+	const maxFrame = 16384
+	for len(body) > 0 {
+		chunk := body[:min(len(body), maxFrame)]
+		body = body[len(chunk):]
+		_ = fr.WriteData(1, endStream && len(body) == 0, chunk)
+	}
+}
@@ -0,0 +1,163 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	mss = 1460
+)
+
+var (
+	clientMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+type packet struct {
+	ts      time.Time
+	frame   []byte
+	comment string
+}
+
+// flow synthesises the packets of one TCP connection.
+type flow struct {
+	client, server netip.AddrPort
+	cseq, sseq     uint32
+	ipID           uint16
+	packets        []packet
+}
+
+func newFlow(client, server netip.AddrPort) *flow {
+	return &flow{client: client, server: server, cseq: 1000, sseq: 5000}
+}
+
+// open writes the three-way handshake, the SYN at ts carrying comment.
+func (f *flow) open(ts, established time.Time, comment string) {
+	f.segment(ts, true, tcpSYN, nil, comment)
+	f.cseq++
+	f.segment(established, false, tcpSYN|tcpACK, nil, "")
+	f.sseq++
+	f.segment(established, true, tcpACK, nil, "")
+}
+
+// send writes data from one side in MSS-sized segments spread evenly
+// from start to end, and the peer's acknowledgement.
+func (f *flow) send(start, end time.Time, fromClient bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	n := (len(data) + mss - 1) / mss
+	for i := 0; i < n; i++ {
+		chunk := data[i*mss : min((i+1)*mss, len(data))]
+		ts := start
+		if n > 1 && end.After(start) {
+			ts = start.Add(end.Sub(start) * time.Duration(i) / time.Duration(n-1))
+		}
+		flags := byte(tcpACK)
+		if i == n-1 {
+			flags |= tcpPSH
+		}
+		f.segment(ts, fromClient, flags, chunk, "")
+		if fromClient {
+			f.cseq += uint32(len(chunk))
+		} else {
+			f.sseq += uint32(len(chunk))
+		}
+	}
+	f.segment(end, !fromClient, tcpACK, nil, "")
+}
+
+// close writes the client's FIN and the server's reply at ts.
+func (f *flow) close(ts time.Time) {
+	f.segment(ts, true, tcpFIN|tcpACK, nil, "")
+	f.cseq++
+	f.segment(ts, false, tcpFIN|tcpACK, nil, "")
+	f.sseq++
+	f.segment(ts, true, tcpACK, nil, "")
+}
+
+func (f *flow) segment(ts time.Time, fromClient bool, flags byte, payload []byte, comment string) {
+	src, dst, srcMAC, dstMAC := f.client, f.server, clientMAC, serverMAC
+	seq, ack := f.cseq, f.sseq
+	if !fromClient {
+		src, dst, srcMAC, dstMAC = f.server, f.client, serverMAC, clientMAC
+		seq, ack = f.sseq, f.cseq
+	}
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	var frame []byte
+	frame = append(frame, dstMAC...)
+	frame = append(frame, srcMAC...)
+	if src.Addr().Is4() {
+		frame = binary.BigEndian.AppendUint16(frame, 0x0800)
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		binary.BigEndian.PutUint16(ip[4:], f.ipID)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64
+		ip[9] = 6
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		copy(ip[12:], s[:])
+		copy(ip[16:], d[:])
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo := append(append(append([]byte{}, s[:]...), d[:]...), 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+		binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+		frame = append(append(frame, ip...), tcp...)
+	} else {
+		frame = binary.BigEndian.AppendUint16(frame, 0x86DD)
+		ip := make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		copy(ip[8:], s[:])
+		copy(ip[24:], d[:])
+		pseudo := append(append(append([]byte{}, s[:]...), d[:]...), 0, 0, byte(len(tcp)>>8), byte(len(tcp)), 0, 0, 0, 6)
+		binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+		frame = append(append(frame, ip...), tcp...)
+	}
+	f.ipID++
+	f.packets = append(f.packets, packet{ts: ts, frame: frame, comment: comment})
+}
+
+// sum adds b as big-endian 16-bit words.
+func sum(b []byte) uint32 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+// checksum is the Internet checksum of b on top of a partial sum.
+func checksum(b []byte, partial uint32) uint16 {
+	s := partial + sum(b)
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
@@ -0,0 +1,250 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action is what the proxy does with a TLS connection to a host.
+type Action string
+
+const (
+	MITM        Action = "mitm"
+	Passthrough Action = "passthrough"
+	Block       Action = "block"
+)
+
+// ParseAction accepts mitm, passthrough, or block.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(strings.ToLower(s)); a {
+	case MITM, Passthrough, Block:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown action %q (want mitm, passthrough, or block)", s)
+}
+
+type Rule struct {
+	Pattern string    `json:"pattern"`
+	Action  Action    `json:"action"`
+	Auto    bool      `json:"auto,omitempty"`
+	Added   time.Time `json:"added,omitempty"`
+	// Static rules come from flags or config.yaml rather than the policy file.
+	Static bool `json:"-"`
+}
+
+// Match reports whether host matches pattern: an exact host name,
+// "*.example.com" for its subdomains, or "*" for every host.
+func Match(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	switch {
+	case pattern == "*" || pattern == host:
+		return true
+	case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+		return true
+	}
+	return false
+}
+
+// specificity ranks matching patterns: exact hosts, then longer
+// wildcards, then "*".
+func specificity(pattern string) int {
+	if !strings.HasPrefix(pattern, "*") {
+		return 1 << 16
+	}
+	return len(pattern)
+}
+
+const (
+	// DefaultPinThreshold is how many rejected handshakes within pinWindow
+	// make a host passthrough.
+	DefaultPinThreshold = 3
+	pinWindow           = 10 * time.Minute
+)
+
+// Store holds the per-host MITM policy. Runtime rules live in a JSON file
+// that is re-read whenever it changes, so `snare policy` edits apply to a
+// running proxy. A nil *Store intercepts every host.
+type Store struct {
+	mu           sync.Mutex
+	path         string
+	static       []Rule
+	rules        []Rule
+	pinThreshold int
+	failures     map[string][]time.Time
+	// modTime and size identify the version of the file in rules.
+	modTime time.Time
+	size    int64
+}
+
+// NewStore returns a policy backed by path with static rules from flags
+// or config. pinThreshold is the number of client handshake failures that
+// mark a host as pinned; 0 turns detection off.
+func NewStore(path string, static []Rule, pinThreshold int) *Store {
+	s := &Store{path: path, pinThreshold: pinThreshold, failures: make(map[string][]time.Time)}
+	for _, r := range static {
+		r.Static = true
+		s.static = append(s.static, r)
+	}
+	_ = s.load()
+	return s
+}
+
+// Rules returns the runtime rules followed by the static ones.
+func (s *Store) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	out := make([]Rule, 0, len(s.rules)+len(s.static))
+	out = append(out, s.rules...)
+	return append(out, s.static...)
+}
+
+// Add saves a runtime rule, replacing any rule with the same pattern.
+func (s *Store) Add(r Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	s.put(r)
+	return s.save()
+}
+
+func (s *Store) put(r Rule) {
+	if r.Added.IsZero() {
+		r.Added = time.Now()
+	}
+	for i := range s.rules {
+		if strings.EqualFold(s.rules[i].Pattern, r.Pattern) {
+			s.rules[i] = r
+			return
+		}
+	}
+	s.rules = append(s.rules, r)
+}
+
+// Remove deletes the runtime rule for pattern.
+func (s *Store) Remove(pattern string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	for i, r := range s.rules {
+		if strings.EqualFold(r.Pattern, pattern) {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// Clear deletes every runtime rule.
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = nil
+	return s.save()
+}
+
+// Decide returns the action for host. The most specific matching pattern
+// wins, and a runtime rule beats a static one with the same pattern.
+// Hosts no rule matches are intercepted.
+func (s *Store) Decide(host string) Action {
+	if s == nil {
+		return MITM
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.load()
+	action, best := MITM, -1
+	for _, set := range [][]Rule{s.rules, s.static} {
+		for _, r := range set {
+			if n := specificity(r.Pattern); n > best && Match(r.Pattern, host) {
+				action, best = r.Action, n
+			}
+		}
+	}
+	return action
+}
+
+// HandshakeFailed records that a client rejected snare's certificate for
+// host. Once that has happened pinThreshold times within ten minutes, the
+// host is saved as an automatic passthrough rule and HandshakeFailed
+// returns true.
+func (s *Store) HandshakeFailed(host string) bool {
+	if s == nil || s.pinThreshold <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	recent := s.failures[host][:0]
+	for _, t := range s.failures[host] {
+		if now.Sub(t) < pinWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < s.pinThreshold {
+		s.failures[host] = recent
+		return false
+	}
+	delete(s.failures, host)
+	_ = s.load()
+	s.put(Rule{Pattern: strings.ToLower(host), Action: Passthrough, Auto: true, Added: now})
+	if err := s.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[snare] policy save: %v\n", err)
+	}
+	return true
+}
+
+// load re-reads the policy file unless it is unchanged since the last
+// load or save.
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+	fi, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.rules, s.modTime, s.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	s.rules, s.modTime, s.size = rules, fi.ModTime(), fi.Size()
+	return nil
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	s := NewStore(path, []Rule{
+		{Pattern: "*", Action: Passthrough},
+		{Pattern: "*.example.com", Action: MITM},
+		{Pattern: "pinned.example.com", Action: Passthrough},
+	}, 0)
+	for host, want := range map[string]Action{
+		"other.org":            Passthrough,
+		"api.example.com":      MITM,
+		"PINNED.example.com":   Passthrough,
+		"example.com":          Passthrough,
+		"deep.api.example.com": MITM,
+	} {
+		if got := s.Decide(host); got != want {
+			t.Errorf("Decide(%q) = %s, want %s", host, got, want)
+		}
+	}
+
+	// A rule written by another process applies on the next decision and
+	// beats a static rule with the same pattern.
+	other := NewStore(path, nil, 0)
+	if err := other.Add(Rule{Pattern: "*.example.com", Action: Block}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Decide("api.example.com"); got != Block {
+		t.Fatalf("runtime rule not applied: %s", got)
+	}
+	if _, err := other.Remove("*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Decide("api.example.com"); got != MITM {
+		t.Fatalf("removed rule still applied: %s", got)
+	}
+
+	// An unchanged file is not parsed again.
+	if err := other.Add(Rule{Pattern: "cached.example.com", Action: Block}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Decide("cached.example.com"); got != Block {
+		t.Fatalf("Decide(cached) = %s", got)
+	}
+	fi, _ := os.Stat(path)
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, bytes.Replace(data, []byte(`"block"`), []byte(`"mitm!"`), 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, fi.ModTime(), fi.ModTime())
+	if got := s.Decide("cached.example.com"); got != Block {
+		t.Fatalf("unchanged policy file re-read: %s", got)
+	}
+
+	var nilStore *Store
+	if nilStore.Decide("x") != MITM {
+		t.Fatal("nil store should intercept")
+	}
+}
+
+func TestPinningDetection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	s := NewStore(path, nil, 3)
+	for i := 0; i < 2; i++ {
+		if s.HandshakeFailed("app.example.com") {
+			t.Fatal("passthrough added too early")
+		}
+	}
+	if !s.HandshakeFailed("app.example.com") {
+		t.Fatal("expected passthrough after three failures")
+	}
+	if s.Decide("app.example.com") != Passthrough || s.Decide("web.example.com") != MITM {
+		t.Fatal("wrong decisions after detection")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("detected rule not saved: %v", err)
+	}
+	rules := NewStore(path, nil, 0).Rules()
+	if len(rules) != 1 || !rules[0].Auto {
+		t.Fatalf("rules = %+v", rules)
+	}
+
+	if NewStore("", nil, 0).HandshakeFailed("x") {
+		t.Fatal("detection should be off with a zero threshold")
+	}
+}
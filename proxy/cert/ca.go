@@ -1,94 +1,335 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 const (
-	DefaultCADir = ".snare"
-	CertFile     = "ca.pem"
-	KeyFile      = "ca-key.pem"
+	DefaultCADir         = ".snare"
+	CertFile             = "ca.pem"
+	KeyFile              = "ca-key.pem"
+	IntermediateFile     = "intermediate.pem"
+	IntermediateKeyFile  = "intermediate-key.pem"
+	LeafDir              = "certs"
+	ArchiveDir           = "ca-archive"
+	rootValidity         = 10 * 365 * 24 * time.Hour
+	intermediateValidity = 2 * 365 * 24 * time.Hour
 )
 
-func LoadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+// Options shape a new CA.
+type Options struct {
+	// KeyType is "ecdsa" (P-256, the default) or "rsa". Host certificates
+	// use the same algorithm as the CA that signs them.
+	KeyType string
+	// RSABits is the RSA key size; 0 means 2048.
+	RSABits int
+	// Intermediate issues host certificates from an intermediate CA, so
+	// the root key can be moved offline.
+	Intermediate bool
+	// PermittedDomains, when set, limits the CA to these domains and their
+	// subdomains with a critical name constraints extension.
+	PermittedDomains []string
+}
+
+// Authority is the CA snare issues host certificates from.
+type Authority struct {
+	Root *x509.Certificate
+	// Cert and Key sign host certificates: the intermediate when there is
+	// one, otherwise the root.
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// Chain holds the certificates sent after each host certificate.
+	Chain []*x509.Certificate
+}
+
+// LoadOrCreateCA returns the certificate and key that sign host
+// certificates, creating a default CA in dir if it has none.
+func LoadOrCreateCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	a, err := LoadOrCreate(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.Cert, a.Key, nil
+}
+
+// LoadOrCreate loads the CA in dir, creating a default one if dir has no
+// root certificate. A root whose key has been moved offline still loads
+// when an intermediate is present.
+func LoadOrCreate(dir string) (*Authority, error) {
 	if dir == "" {
 		dir = DefaultCADir
 	}
-	certPath := filepath.Join(dir, CertFile)
-	keyPath := filepath.Join(dir, KeyFile)
-
-	certPEM, err := os.ReadFile(certPath)
-	if err == nil {
-		keyPEM, kerr := os.ReadFile(keyPath)
-		if kerr == nil {
-			return parseCA(certPEM, keyPEM)
+	a, err := Load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, statErr := os.Stat(filepath.Join(dir, CertFile)); os.IsNotExist(statErr) {
+			return Create(dir, Options{})
 		}
 	}
+	return a, err
+}
 
+// Load reads the CA in dir.
+func Load(dir string) (*Authority, error) {
+	root, err := readCert(filepath.Join(dir, CertFile))
+	if err != nil {
+		return nil, err
+	}
+	a := &Authority{Root: root, Cert: root}
+	inter, err := readCert(filepath.Join(dir, IntermediateFile))
+	switch {
+	case err == nil:
+		a.Cert = inter
+		a.Chain = []*x509.Certificate{inter}
+		a.Key, err = readKey(filepath.Join(dir, IntermediateKeyFile))
+	case os.IsNotExist(err):
+		a.Key, err = readKey(filepath.Join(dir, KeyFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load CA key: %w", err)
+	}
+	return a, nil
+}
+
+// Create writes a new CA to dir, replacing any files already there.
+func Create(dir string, opts Options) (*Authority, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err := newKey(opts.KeyType, opts.RSABits)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	tmpl := caTemplate("Proxy Root CA", rootValidity, opts.PermittedDomains)
+	root, err := sign(tmpl, tmpl, key, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCert(filepath.Join(dir, CertFile), root); err != nil {
+		return nil, err
+	}
+	if err := writeKey(filepath.Join(dir, KeyFile), key); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(filepath.Join(dir, IntermediateFile))
+	_ = os.Remove(filepath.Join(dir, IntermediateKeyFile))
+	a := &Authority{Root: root, Cert: root, Key: key}
+	if opts.Intermediate {
+		return a, a.issueIntermediate(dir, key, opts)
+	}
+	return a, nil
+}
+
+// NewIntermediate replaces the intermediate CA in dir with a new one signed
+// by the root, which needs the root key back in place.
+func NewIntermediate(dir string, opts Options) (*Authority, error) {
+	root, err := readCert(filepath.Join(dir, CertFile))
+	if err != nil {
+		return nil, err
+	}
+	rootKey, err := LoadRootKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PermittedDomains == nil {
+		opts.PermittedDomains = root.PermittedDNSDomains
+	}
+	a := &Authority{Root: root}
+	return a, a.issueIntermediate(dir, rootKey, opts)
+}
+
+// LoadRootKey reads the root key in dir, which may have been moved offline
+// when host certificates come from an intermediate.
+func LoadRootKey(dir string) (crypto.Signer, error) {
+	key, err := readKey(filepath.Join(dir, KeyFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("root key not found at %s; restore it to continue", filepath.Join(dir, KeyFile))
+	}
+	return key, err
+}
+
+func (a *Authority) issueIntermediate(dir string, rootKey crypto.Signer, opts Options) error {
+	key, err := newKey(opts.KeyType, opts.RSABits)
+	if err != nil {
+		return err
+	}
+	tmpl := caTemplate("Proxy Intermediate CA", intermediateValidity, opts.PermittedDomains)
+	tmpl.MaxPathLenZero = true
+	inter, err := sign(tmpl, a.Root, key, rootKey)
+	if err != nil {
+		return err
+	}
+	if err := writeCert(filepath.Join(dir, IntermediateFile), inter); err != nil {
+		return err
+	}
+	if err := writeKey(filepath.Join(dir, IntermediateKeyFile), key); err != nil {
+		return err
+	}
+	a.Cert, a.Key, a.Chain = inter, key, []*x509.Certificate{inter}
+	return nil
+}
+
+// Archive moves the CA files and saved host certificates in dir to a new
+// timestamped directory under ArchiveDir and returns its path.
+func Archive(dir string) (string, error) {
+	dest := filepath.Join(dir, ArchiveDir, time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return "", err
+	}
+	for _, name := range []string{CertFile, KeyFile, IntermediateFile, IntermediateKeyFile, LeafDir} {
+		err := os.Rename(filepath.Join(dir, name), filepath.Join(dest, name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return dest, nil
+}
+
+// Fingerprint is the SHA-256 digest of a certificate, as colon-separated hex.
+func Fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func caTemplate(name string, validity time.Duration, domains []string) *x509.Certificate {
 	tmpl := &x509.Certificate{
-		SerialNumber: serial,
+		SerialNumber: serialNumber(),
 		Subject: pkix.Name{
 			Organization: []string{"Proxy CA"},
-			CommonName:   "Proxy Root CA",
+			CommonName:   name,
 		},
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		NotAfter:              time.Now().Add(validity),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
-	if err != nil {
-		return nil, nil, err
+	if len(domains) > 0 {
+		tmpl.PermittedDNSDomains = domains
+		tmpl.PermittedDNSDomainsCritical = true
+		// Without an IP constraint, a name-constrained CA could still
+		// sign for any IP address.
+		tmpl.ExcludedIPRanges = []*net.IPNet{
+			{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		}
 	}
-	cert, err := x509.ParseCertificate(certDER)
+	return tmpl
+}
+
+func serialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func sign(tmpl, parent *x509.Certificate, key, parentKey crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
-		return nil, nil, err
+	return x509.ParseCertificate(der)
+}
+
+func newKey(keyType string, bits int) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case "", "ecdsa", "ec":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		if bits == 0 {
+			bits = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
 	}
-	keyDER, _ := x509.MarshalECPrivateKey(key)
-	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
-		return nil, nil, err
+	return nil, fmt.Errorf("unknown key type %q (want ecdsa or rsa)", keyType)
+}
+
+// keyType names the algorithm of key for newKey.
+func keyType(key crypto.Signer) (string, int) {
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return "rsa", k.N.BitLen()
 	}
-	return cert, key, nil
+	return "ecdsa", 0
 }
 
-func parseCA(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	block, _ := pem.Decode(certPEM)
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
 	if block == nil {
-		return nil, nil, fmt.Errorf("no PEM in ca cert")
+		return nil, fmt.Errorf("no PEM in %s", path)
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM in %s", path)
+	}
+	return parseKey(block)
+}
+
+func parseKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
 	}
-	kblock, _ := pem.Decode(keyPEM)
-	if kblock == nil {
-		return nil, nil, fmt.Errorf("no PEM in ca key")
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
 	}
-	key, err := x509.ParseECPrivateKey(kblock.Bytes)
+	return signer, nil
+}
+
+func writeCert(path string, c *x509.Certificate) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}), 0644)
+}
+
+func writeKey(path string, key crypto.Signer) error {
+	block, err := keyBlock(key)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+}
+
+// keyBlock keeps ECDSA keys in the SEC 1 form earlier versions wrote.
+func keyBlock(key crypto.Signer) (*pem.Block, error) {
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		der, err := x509.MarshalECPrivateKey(k)
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, err
 	}
-	return cert, key, nil
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, err
 }
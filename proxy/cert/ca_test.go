@@ -0,0 +1,119 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestIntermediateCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := Create(dir, Options{KeyType: "rsa", Intermediate: true, PermittedDomains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root key can go offline once the intermediate exists.
+	if err := os.Remove(filepath.Join(dir, KeyFile)); err != nil {
+		t.Fatal(err)
+	}
+	ca, err = LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDir := filepath.Join(dir, LeafDir)
+	tlsCert, err := ca.HostCertCache(leafDir).TLSCertificate("api.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tlsCert.PrivateKey.(*rsa.PrivateKey); !ok || len(tlsCert.Certificate) != 2 {
+		t.Fatalf("key %T, chain of %d", tlsCert.PrivateKey, len(tlsCert.Certificate))
+	}
+	roots, inters := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(ca.Root)
+	inters.AddCert(ca.Cert)
+	if _, err := tlsCert.Leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inters, DNSName: "api.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.HostCertCache("").TLSCertificate("example.org"); err == nil {
+		t.Fatal("issued a certificate outside the name constraints")
+	}
+	if _, err := ca.HostCertCache("").TLSCertificate("10.0.0.1"); err == nil || len(ca.Cert.ExcludedIPRanges) != 2 {
+		t.Fatalf("name-constrained CA signs for IP addresses: %v %v", err, ca.Cert.ExcludedIPRanges)
+	}
+
+	// A restart reuses the saved host certificate.
+	again, err := ca.HostCertCache(leafDir).TLSCertificate("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Leaf.Equal(tlsCert.Leaf) {
+		t.Fatal("host certificate reissued after restart")
+	}
+	// A saved certificate is only reused for the host it names.
+	cache := ca.HostCertCache(leafDir)
+	data, err := os.ReadFile(cache.path("api.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.path("www.example.com"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other, err := cache.TLSCertificate("www.example.com")
+	if err != nil || other.Leaf.VerifyHostname("www.example.com") != nil {
+		t.Fatalf("reused another host's certificate: %v", err)
+	}
+
+	if _, err := NewIntermediate(dir, Options{}); err == nil {
+		t.Fatal("new intermediate without the root key")
+	}
+}
+
+func TestRotateAndExport(t *testing.T) {
+	dir := t.TempDir()
+	old, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := old.HostCertCache(filepath.Join(dir, LeafDir)).GetCertificate("a.test"); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{CertFile, KeyFile, LeafDir} {
+		if _, err := os.Stat(filepath.Join(archive, name)); err != nil {
+			t.Fatalf("archive: %v", err)
+		}
+	}
+	ca, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(ca.Root) == Fingerprint(old.Root) {
+		t.Fatal("rotation kept the old root")
+	}
+	if leaf, _, err := ca.HostCertCache(filepath.Join(dir, LeafDir)).GetCertificate("a.test"); err != nil || leaf.CheckSignatureFrom(ca.Root) != nil {
+		t.Fatalf("host certificate not from the new root: %v", err)
+	}
+
+	data, err := EncodePKCS12(ca.Key, []*x509.Certificate{ca.Root}, "snare", "pässword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, c, err := pkcs12.Decode(data, "pässword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal(ca.Root) || !ca.Key.Public().(*ecdsa.PublicKey).Equal(&key.(*ecdsa.PrivateKey).PublicKey) {
+		t.Fatal("p12 does not round-trip")
+	}
+	if _, _, err := pkcs12.Decode(data, "wrong"); err == nil {
+		t.Fatal("p12 decoded with the wrong password")
+	}
+}
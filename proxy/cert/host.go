@@ -1,31 +1,42 @@
 package cert
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"math/big"
+	"encoding/pem"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	leafValidity = 365 * 24 * time.Hour
+	// leafRenewal is how close to expiry a saved host certificate is
+	// reissued instead of reused.
+	leafRenewal = 30 * 24 * time.Hour
+)
+
 type HostCertCache struct {
 	mu    sync.RWMutex
 	cache map[string]*cachedCert
 	ca    *x509.Certificate
-	key   *ecdsa.PrivateKey
+	key   crypto.Signer
+	chain [][]byte
+	dir   string
 }
 
 type cachedCert struct {
 	cert *x509.Certificate
-	key  *ecdsa.PrivateKey
+	key  crypto.Signer
 }
 
-func NewHostCertCache(ca *x509.Certificate, key *ecdsa.PrivateKey) *HostCertCache {
+func NewHostCertCache(ca *x509.Certificate, key crypto.Signer) *HostCertCache {
 	return &HostCertCache{
 		cache: make(map[string]*cachedCert),
 		ca:    ca,
@@ -33,7 +44,19 @@ func NewHostCertCache(ca *x509.Certificate, key *ecdsa.PrivateKey) *HostCertCach
 	}
 }
 
-func (h *HostCertCache) GetCertificate(host string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+// HostCertCache returns a cache that issues from a, sends a's chain after
+// each host certificate, and saves host certificates in dir so a restart
+// reuses them. An empty dir keeps them in memory only.
+func (a *Authority) HostCertCache(dir string) *HostCertCache {
+	h := NewHostCertCache(a.Cert, a.Key)
+	for _, c := range a.Chain {
+		h.chain = append(h.chain, c.Raw)
+	}
+	h.dir = dir
+	return h
+}
+
+func (h *HostCertCache) GetCertificate(host string) (*x509.Certificate, crypto.Signer, error) {
 	host = normalizeHost(host)
 	h.mu.RLock()
 	if c, ok := h.cache[host]; ok {
@@ -47,14 +70,28 @@ func (h *HostCertCache) GetCertificate(host string) (*x509.Certificate, *ecdsa.P
 	if c, ok := h.cache[host]; ok {
 		return c.cert, c.key, nil
 	}
-	cert, key, err := h.issue(host)
+	cert, key, err := h.load(host)
 	if err != nil {
-		return nil, nil, err
+		cert, key, err = h.issue(host)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.save(host, cert, key)
 	}
 	h.cache[host] = &cachedCert{cert: cert, key: key}
 	return cert, key, nil
 }
 
+// TLSCertificate returns the certificate for host followed by the CA chain.
+func (h *HostCertCache) TLSCertificate(host string) (*tls.Certificate, error) {
+	leaf, key, err := h.GetCertificate(host)
+	if err != nil {
+		return nil, err
+	}
+	chain := append([][]byte{leaf.Raw}, h.chain...)
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
 func normalizeHost(host string) string {
 	host = strings.TrimSpace(host)
 	if idx := strings.Index(host, ":"); idx != -1 {
@@ -63,34 +100,116 @@ func normalizeHost(host string) string {
 	return strings.ToLower(host)
 }
 
-func (h *HostCertCache) issue(host string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func (h *HostCertCache) issue(host string) (*x509.Certificate, crypto.Signer, error) {
+	if !h.permitted(host) {
+		return nil, nil, fmt.Errorf("%s is outside the CA's name constraints %v", host, h.ca.PermittedDNSDomains)
+	}
+	key, err := newKey(keyType(h.key))
 	if err != nil {
 		return nil, nil, err
 	}
-	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	tmpl := &x509.Certificate{
-		SerialNumber: serial,
+		SerialNumber: serialNumber(),
 		Subject: pkix.Name{
 			Organization: []string{"Proxy"},
 			CommonName:   host,
 		},
 		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(365 * 24 * time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
 		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:    []string{host},
 	}
 	if ip := net.ParseIP(host); ip != nil {
 		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, h.ca, &key.PublicKey, h.key)
+	cert, err := sign(tmpl, h.ca, key, h.key)
 	if err != nil {
 		return nil, nil, err
 	}
-	cert, err := x509.ParseCertificate(certDER)
+	return cert, key, nil
+}
+
+// permitted reports whether the CA's name constraints allow host. A CA
+// constrained to DNS domains signs for no IP addresses.
+func (h *HostCertCache) permitted(host string) bool {
+	if len(h.ca.PermittedDNSDomains) == 0 {
+		return true
+	}
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	for _, d := range h.ca.PermittedDNSDomains {
+		d = strings.ToLower(d)
+		if strings.HasPrefix(d, ".") {
+			if strings.HasSuffix(host, d) {
+				return true
+			}
+		} else if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HostCertCache) path(host string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, host)
+	return filepath.Join(h.dir, name+".pem")
+}
+
+// load reads a saved certificate for host, if it was signed by the current
+// CA for host and is not close to expiry.
+func (h *HostCertCache) load(host string) (*x509.Certificate, crypto.Signer, error) {
+	if h.dir == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(h.path(host))
 	if err != nil {
 		return nil, nil, err
 	}
+	var cert *x509.Certificate
+	var key crypto.Signer
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert, err = x509.ParseCertificate(block.Bytes)
+		} else {
+			key, err = parseKey(block)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cert == nil || key == nil {
+		return nil, nil, fmt.Errorf("incomplete host certificate for %s", host)
+	}
+	if err := cert.CheckSignatureFrom(h.ca); err != nil {
+		return nil, nil, err
+	}
+	// File names are sanitized, so different hosts can share one.
+	if err := cert.VerifyHostname(host); err != nil {
+		return nil, nil, err
+	}
+	if time.Until(cert.NotAfter) < leafRenewal {
+		return nil, nil, fmt.Errorf("host certificate for %s expires %s", host, cert.NotAfter)
+	}
 	return cert, key, nil
 }
+
+func (h *HostCertCache) save(host string, cert *x509.Certificate, key crypto.Signer) {
+	if h.dir == "" {
+		return
+	}
+	block, err := keyBlock(key)
+	if err != nil || os.MkdirAll(h.dir, 0700) != nil {
+		return
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	data = append(data, pem.EncodeToMemory(block)...)
+	_ = os.WriteFile(h.path(host), data, 0600)
+}
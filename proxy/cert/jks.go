@@ -0,0 +1,189 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic       = 0xFEEDFEED
+	jksVersion     = 2
+	jksPrivateKey  = 1
+	jksTrustedCert = 2
+)
+
+// JKS is a Java KeyStore, the format of Java's cacerts. Entries other than
+// the trusted certificates added here are kept byte for byte. The zero JKS
+// is an empty keystore.
+type JKS struct {
+	entries []jksEntry
+}
+
+type jksEntry struct {
+	tag   uint32
+	alias string
+	// body is everything after the alias: timestamp and key or certificate.
+	body []byte
+}
+
+// ParseJKS reads a version 2 keystore and checks its integrity digest
+// against password.
+func ParseJKS(data []byte, password string) (*JKS, error) {
+	if len(data) > 0 && data[0] == 0x30 {
+		return nil, errors.New("keystore is PKCS#12, not JKS")
+	}
+	if len(data) < 12+sha1.Size {
+		return nil, errors.New("keystore too short")
+	}
+	content, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !bytes.Equal(jksDigest(content, password), digest) {
+		return nil, errors.New("keystore password incorrect or file corrupted")
+	}
+	r := bytes.NewReader(content)
+	var head struct{ Magic, Version, Count uint32 }
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil {
+		return nil, err
+	}
+	if head.Magic != jksMagic || head.Version != jksVersion {
+		return nil, fmt.Errorf("not a version %d JKS keystore", jksVersion)
+	}
+	ks := &JKS{}
+	for i := uint32(0); i < head.Count; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, err
+		}
+		alias, err := readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		start := len(content) - r.Len()
+		if _, err := r.Seek(8, io.SeekCurrent); err != nil { // timestamp
+			return nil, err
+		}
+		switch tag {
+		case jksPrivateKey:
+			if err := skipBlob(r); err != nil {
+				return nil, err
+			}
+			var n uint32
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return nil, err
+			}
+			for ; n > 0; n-- {
+				if err := skipCert(r); err != nil {
+					return nil, err
+				}
+			}
+		case jksTrustedCert:
+			if err := skipCert(r); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown keystore entry type %d", tag)
+		}
+		end := len(content) - r.Len()
+		ks.entries = append(ks.entries, jksEntry{tag: tag, alias: alias, body: content[start:end]})
+	}
+	return ks, nil
+}
+
+// Aliases lists the entry names.
+func (ks *JKS) Aliases() []string {
+	out := make([]string, len(ks.entries))
+	for i, e := range ks.entries {
+		out[i] = e.alias
+	}
+	return out
+}
+
+// SetTrustedCert adds c under alias, replacing any entry with that name.
+func (ks *JKS) SetTrustedCert(alias string, c *x509.Certificate) {
+	alias = strings.ToLower(alias)
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, time.Now().UnixMilli())
+	writeUTF(&body, "X.509")
+	binary.Write(&body, binary.BigEndian, uint32(len(c.Raw)))
+	body.Write(c.Raw)
+	ks.Remove(alias)
+	ks.entries = append(ks.entries, jksEntry{tag: jksTrustedCert, alias: alias, body: body.Bytes()})
+}
+
+// Remove deletes the entry named alias.
+func (ks *JKS) Remove(alias string) bool {
+	for i, e := range ks.entries {
+		if strings.EqualFold(e.alias, alias) {
+			ks.entries = append(ks.entries[:i], ks.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal encodes the keystore with an integrity digest keyed by password.
+func (ks *JKS) Marshal(password string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{jksMagic, jksVersion, uint32(len(ks.entries))})
+	for _, e := range ks.entries {
+		binary.Write(&b, binary.BigEndian, e.tag)
+		writeUTF(&b, e.alias)
+		b.Write(e.body)
+	}
+	return append(b.Bytes(), jksDigest(b.Bytes(), password)...)
+}
+
+// jksDigest is SHA-1 over the password as UTF-16, a fixed phrase, and the
+// keystore contents, as Java's JKS implementation computes it.
+func jksDigest(content []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+func readUTF(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeUTF(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+}
+
+func skipBlob(r *bytes.Reader) error {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if int64(n) > int64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := r.Seek(int64(n), io.SeekCurrent)
+	return err
+}
+
+func skipCert(r *bytes.Reader) error {
+	if _, err := readUTF(r); err != nil { // certificate type
+		return err
+	}
+	return skipBlob(r)
+}
@@ -0,0 +1,46 @@
+package cert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestJKS(t *testing.T) {
+	root, _, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A keystore holding a private key entry, which must survive edits.
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{jksMagic, jksVersion, 1, jksPrivateKey})
+	writeUTF(&b, "server")
+	binary.Write(&b, binary.BigEndian, int64(1700000000000))
+	binary.Write(&b, binary.BigEndian, uint32(3))
+	b.Write([]byte{1, 2, 3})
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	writeUTF(&b, "X.509")
+	binary.Write(&b, binary.BigEndian, uint32(len(root.Raw)))
+	b.Write(root.Raw)
+	original := append(b.Bytes(), jksDigest(b.Bytes(), "changeit")...)
+
+	if _, err := ParseJKS(original, "wrong"); err == nil {
+		t.Fatal("parsed with the wrong password")
+	}
+	ks, err := ParseJKS(original, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.SetTrustedCert("Snare", root)
+	ks, err = ParseJKS(ks.Marshal("changeit"), "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ks.Aliases(), []string{"server", "snare"}) {
+		t.Fatalf("aliases = %v", ks.Aliases())
+	}
+	if !ks.Remove("snare") || !bytes.Equal(ks.Marshal("changeit"), original) {
+		t.Fatal("removing the added entry did not restore the keystore")
+	}
+}
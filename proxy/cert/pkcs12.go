@@ -0,0 +1,279 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+// PKCS#12 as in RFC 7292, limited to what EncodePKCS12 writes: a
+// 3DES-encrypted key bag, plain certificate bags, and an HMAC-SHA1 MAC.
+// Those are the algorithms every reader accepts, including Windows, macOS,
+// Java keytool, and OpenSSL 3 without -legacy.
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidShroudedKeyBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA1And3DE = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const pkcs12Iterations = 2048
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix12Algorithm
+	Digest    []byte
+}
+
+type pkix12Algorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue  `asn1:"tag:0,explicit"`
+	Attributes []bagAttribute `asn1:"set,omitempty"`
+}
+
+type bagAttribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix12Algorithm
+	EncryptedData []byte
+}
+
+// EncodePKCS12 bundles key with certs, the first of which must be key's
+// certificate, protected by password. name is shown as the entry's
+// friendly name.
+func EncodePKCS12(key crypto.Signer, certs []*x509.Certificate, name, password string) ([]byte, error) {
+	pw := bmpString(password)
+	keyID := certs[0].SubjectKeyId
+	if len(keyID) == 0 {
+		sum := sha1.Sum(certs[0].Raw)
+		keyID = sum[:]
+	}
+	leafAttrs, err := bagAttributes(name, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bags []safeBag
+	for i, c := range certs {
+		der, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicit(der)}
+		if i == 0 {
+			bag.Attributes = leafAttrs
+		}
+		bags = append(bags, bag)
+	}
+	certContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := pbeEncrypt(pkcs8, pw, salt)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix12Algorithm{Algorithm: oidPBEWithSHA1And3DE, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]safeBag{{ID: oidShroudedKeyBag, Value: explicit(keyInfo), Attributes: leafAttrs}})
+	if err != nil {
+		return nil, err
+	}
+
+	var safes []contentInfo
+	for _, contents := range [][]byte{certContents, keyContents} {
+		octets, err := asn1.Marshal(contents)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, contentInfo{ContentType: oidData, Content: explicit(octets)})
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+	macSalt, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pbkdf(pw, macSalt, 3, 20))
+	mac.Write(authSafe)
+	authOctets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicit(authOctets)},
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: pkix12Algorithm{Algorithm: oidSHA1, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+func bagAttributes(name string, keyID []byte) ([]bagAttribute, error) {
+	id, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []bagAttribute{{ID: oidLocalKeyID, Value: set(id)}}
+	if name != "" {
+		bmp := bmpString(name)
+		friendly, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmp[:len(bmp)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, bagAttribute{ID: oidFriendlyName, Value: set(friendly)})
+	}
+	return attrs, nil
+}
+
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// set wraps an attribute value in the SET attributes are defined with.
+func set(der []byte) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// bmpString is password as big-endian UTF-16 with a trailing NUL, the
+// form the PKCS#12 key derivation hashes.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return append(b, 0, 0)
+}
+
+func pbeEncrypt(data, password, salt []byte) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(pbkdf(password, salt, 1, 24))
+	if err != nil {
+		return nil, err
+	}
+	pad := block.BlockSize() - len(data)%block.BlockSize()
+	for i := 0; i < pad; i++ {
+		data = append(data, byte(pad))
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, pbkdf(password, salt, 2, 8)).CryptBlocks(out, data)
+	return out, nil
+}
+
+// pbkdf is the PKCS#12 key derivation function (RFC 7292 appendix B) with
+// SHA-1: id 1 derives an encryption key, 2 an IV, and 3 a MAC key.
+func pbkdf(password, salt []byte, id byte, size int) []byte {
+	const v = 64 // SHA-1 block size
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(in)
+		a := h.Sum(nil)
+		for i := 1; i < pkcs12Iterations; i++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+		b := new(big.Int).SetBytes(fill(a)[:v])
+		for j := 0; j < len(in); j += v {
+			n := new(big.Int).SetBytes(in[j : j+v])
+			n.Add(n, b).Add(n, one)
+			nb := n.Bytes()
+			if len(nb) > v {
+				nb = nb[len(nb)-v:]
+			}
+			clear(in[j : j+v])
+			copy(in[j+v-len(nb):j+v], nb)
+		}
+	}
+	return out[:size]
+}
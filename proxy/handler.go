@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -18,6 +20,7 @@ import (
 	"os/exec"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +29,7 @@ import (
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/intercept"
 	"github.com/muxover/snare/v2/mock"
+	"github.com/muxover/snare/v2/policy"
 	"github.com/muxover/snare/v2/proxy/cert"
 )
 
@@ -47,6 +51,7 @@ type Handler struct {
 	MapRemotes       []MapRemoteRule
 	BodyRewrites     []BodyRewrite
 	MaxBodySize      int64
+	SpillThreshold   int64
 	Mode             string
 	ReverseTarget    *url.URL
 	Delay            time.Duration
@@ -55,6 +60,15 @@ type Handler struct {
 	Plugins          []string
 	ProtoDecoder     *ProtoDecoder
 	Hooks            *HookEngine
+	UpstreamTLS      *UpstreamTLS
+	Policy           *policy.Store
+	// KeyLog receives NSS key log lines for the client side of MITM'd
+	// connections; UpstreamTLS.KeyLog covers the origin side.
+	KeyLog io.Writer
+	// Tags are added to every capture.
+	Tags []string
+
+	clientTags []string
 }
 
 type HostRewrite struct {
@@ -77,7 +91,39 @@ type BodyRewrite struct {
 	Replacement []byte
 }
 
+// forClient returns a copy of h that tags every capture it records with
+// the name of an authenticated client. An empty or unusable name returns h.
+func (h *Handler) forClient(name string) *Handler {
+	if capture.ValidTag(name) != nil {
+		return h
+	}
+	hc := *h
+	hc.clientTags = append(slices.Clone(h.clientTags), name)
+	return &hc
+}
+
+// proxyUser returns the user name of a Basic Proxy-Authorization header.
+// snare does not authenticate HTTP proxy clients, so the name only tags
+// their captures, as SOCKS user names do.
+func proxyUser(req *http.Request) string {
+	auth, ok := strings.CutPrefix(req.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth))
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(raw), ":")
+	return user
+}
+
 func (h *Handler) addCapture(c *capture.Capture) {
+	for _, t := range slices.Concat(h.Tags, h.clientTags) {
+		if !c.HasTag(t) {
+			c.Tags = append(c.Tags, t)
+		}
+	}
 	if h.Store != nil {
 		h.Store.Add(c)
 	}
@@ -202,6 +248,9 @@ func (h *Handler) capSlice(b []byte) []byte {
 func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	defer func() {
 		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
 			h.Log.Error("handler panic", "err", err)
 			http.Error(rw, "proxy error", http.StatusInternalServerError)
 		}
@@ -224,6 +273,10 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if user := proxyUser(req); user != "" {
+		req.Header.Del("Proxy-Authorization")
+		h = h.forClient(user)
+	}
 	if req.Method == http.MethodConnect {
 		h.serveCONNECT(rw, req)
 		return
@@ -232,19 +285,14 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
-	if h.Mocks != nil {
-		if rule := h.Mocks.Match(req); rule != nil {
-			h.serveMock(rw, req, rule)
-			return
-		}
+	if rule, c := h.matchMock(req, req.URL.String()); rule != nil {
+		h.serveMock(rw, req, rule, c)
+		return
 	}
 
 	start := time.Now()
 	capID := uuid.New().String()
 
-	bodyBuf, _ := io.ReadAll(req.Body)
-	req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
-
 	u := req.URL
 	if u.Scheme == "" {
 		u.Scheme = "http"
@@ -255,6 +303,16 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	ignored := h.isIgnored(u.String())
 
+	var bodyBuf []byte
+	var reqSpill *capture.Spill
+	streamReq := h.streamsRequest(req, ignored)
+	if streamReq {
+		req.Body, reqSpill = h.tapBody(req.Body, ignored)
+	} else {
+		bodyBuf, _ = io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
+	}
+
 	if !ignored && h.Intercept != nil && intercept.MatchesPattern(req, h.InterceptMatch) {
 		newBody, dropped := h.holdAndApply(req, capID, start, u.String(), bodyBuf)
 		if dropped {
@@ -272,6 +330,9 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	outReq.Header = req.Header.Clone()
 	outReq.Host = req.Host
+	if streamReq {
+		outReq.Body, outReq.ContentLength, outReq.GetBody = req.Body, req.ContentLength, nil
+	}
 	h.applyOutboundMods(outReq)
 
 	if !ignored && h.Hooks != nil {
@@ -305,8 +366,10 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	outReq, trace := traceRequest(req, outReq)
 	resp, err := h.Transport.RoundTrip(outReq)
 	duration := time.Since(start)
+	bodyBuf, reqBlob := h.finishSpill(reqSpill, bodyBuf, req.Header.Get("Content-Encoding"))
 	if err != nil {
 		if ignored {
 			http.Error(rw, err.Error(), http.StatusBadGateway)
@@ -323,12 +386,16 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  reqProto(req),
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     req.URL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(reqCaptureBody),
+				Method:   req.Method,
+				URL:      req.URL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(reqCaptureBody),
+				BodyBlob: reqBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(time.Now()),
+			Conn:     trace.conn(),
+			TLS:      verifyFailure(err, outReq.URL.Hostname()),
 			Error:    err.Error(),
 		})
 		h.Log.Info("captured (error)", "method", req.Method, "url", req.URL.String(), "err", err)
@@ -352,12 +419,16 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  reqProto(req),
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     outReq.URL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				Method:   req.Method,
+				URL:      outReq.URL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				BodyBlob: reqBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(time.Now()),
+			Conn:     trace.conn(),
+			TLS:      h.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 		}
 		c.GraphQL = detectGraphQL(outReq.Header.Get("Content-Type"), reqCaptureBody)
 		if len(h.Shadows) > 0 {
@@ -370,15 +441,28 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if len(h.BodyRewrites) > 0 {
-		respBody = h.applyBodyRewrites(respBody)
-	}
+	var respBody []byte
+	var respBlob *capture.BlobRef
+	var received time.Time
+	streaming := h.streamsResponse(outReq, resp, ignored)
+	if streaming {
+		var sp *capture.Spill
+		resp.Body, sp = h.tapBody(resp.Body, ignored)
+		h.relayResponse(rw, resp)
+		received = time.Now()
+		respBody, respBlob = h.finishSpill(sp, nil, resp.Header.Get("Content-Encoding"))
+	} else {
+		respBody, _ = io.ReadAll(resp.Body)
+		received = time.Now()
+		if len(h.BodyRewrites) > 0 {
+			respBody = h.applyBodyRewrites(respBody)
+		}
 
-	if !ignored && h.Hooks != nil {
-		status := resp.StatusCode
-		respBody = h.Hooks.RunOnResponse(outReq.Method, outReq.URL.String(), &status, resp.Header, respBody)
-		resp.StatusCode = status
+		if !ignored && h.Hooks != nil {
+			status := resp.StatusCode
+			respBody = h.Hooks.RunOnResponse(outReq.Method, outReq.URL.String(), &status, resp.Header, respBody)
+			resp.StatusCode = status
+		}
 	}
 
 	captureBody := decompressBody(respBody, resp.Header.Get("Content-Encoding"))
@@ -394,17 +478,22 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  reqProto(req),
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     outReq.URL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				Method:   req.Method,
+				URL:      outReq.URL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				BodyBlob: reqBlob,
 			},
 			Response: &capture.ResponseSnapshot{
 				StatusCode: resp.StatusCode,
 				Headers:    respHeaders,
 				Body:       capture.BodyBytes(h.capSlice(captureBody)),
+				BodyBlob:   respBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(received),
+			Conn:     trace.conn(),
+			TLS:      h.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 		}
 		c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 		if isGRPC(outReq.Header) || isGRPC(resp.Header) {
@@ -421,6 +510,9 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 	if len(h.Shadows) > 0 {
 		go h.fireShadows(req.Method, outReq.URL.String(), req.Header, bodyBuf)
 	}
+	if streaming {
+		return
+	}
 	if h.Delay > 0 {
 		time.Sleep(h.Delay)
 	}
@@ -434,24 +526,29 @@ func (h *Handler) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
-	if h.Mocks != nil {
-		if rule := h.Mocks.Match(req); rule != nil {
-			h.serveMock(rw, req, rule)
-			return
-		}
+	outURL := *h.ReverseTarget
+	outURL.Path = req.URL.Path
+	outURL.RawQuery = req.URL.RawQuery
+
+	if rule, c := h.matchMock(req, outURL.String()); rule != nil {
+		h.serveMock(rw, req, rule, c)
+		return
 	}
 
 	start := time.Now()
 	capID := uuid.New().String()
 
-	bodyBuf, _ := io.ReadAll(req.Body)
-
-	outURL := *h.ReverseTarget
-	outURL.Path = req.URL.Path
-	outURL.RawQuery = req.URL.RawQuery
-
 	ignored := h.isIgnored(outURL.String())
 
+	var bodyBuf []byte
+	var reqSpill *capture.Spill
+	streamReq := h.streamsRequest(req, ignored)
+	if streamReq {
+		req.Body, reqSpill = h.tapBody(req.Body, ignored)
+	} else {
+		bodyBuf, _ = io.ReadAll(req.Body)
+	}
+
 	if !ignored && h.Intercept != nil && intercept.MatchesPattern(req, h.InterceptMatch) {
 		newBody, dropped := h.holdAndApply(req, capID, start, outURL.String(), bodyBuf)
 		if dropped {
@@ -468,6 +565,9 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 	}
 	outReq.Header = req.Header.Clone()
 	outReq.Host = h.ReverseTarget.Host
+	if streamReq {
+		outReq.Body, outReq.ContentLength, outReq.GetBody = req.Body, req.ContentLength, nil
+	}
 	h.applyOutboundMods(outReq)
 
 	if !ignored && h.Hooks != nil {
@@ -497,13 +597,15 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	outReq, trace := traceRequest(req, outReq)
 	resp, err := h.Transport.RoundTrip(outReq)
 	duration := time.Since(start)
+	bodyBuf, reqBlob := h.finishSpill(reqSpill, bodyBuf, req.Header.Get("Content-Encoding"))
 	if err != nil {
 		if !ignored {
 			h.addCapture(&capture.Capture{ID: capID, Timestamp: start, Protocol: reqProto(req),
 				Request:  capture.RequestSnapshot{Method: req.Method, URL: outURL.String(), Headers: outReq.Header.Clone()},
-				Duration: duration, Error: err.Error(),
+				Duration: duration, Timings: trace.timings(time.Now()), Conn: trace.conn(), TLS: verifyFailure(err, outReq.URL.Hostname()), Error: err.Error(),
 			})
 		}
 		http.Error(rw, err.Error(), http.StatusBadGateway)
@@ -526,12 +628,16 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  reqProto(req),
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     outURL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				Method:   req.Method,
+				URL:      outURL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				BodyBlob: reqBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(time.Now()),
+			Conn:     trace.conn(),
+			TLS:      h.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 		}
 		c.GraphQL = detectGraphQL(outReq.Header.Get("Content-Type"), reqCaptureBody)
 		if len(h.Shadows) > 0 {
@@ -544,15 +650,28 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if len(h.BodyRewrites) > 0 {
-		respBody = h.applyBodyRewrites(respBody)
-	}
+	var respBody []byte
+	var respBlob *capture.BlobRef
+	var received time.Time
+	streaming := h.streamsResponse(outReq, resp, ignored)
+	if streaming {
+		var sp *capture.Spill
+		resp.Body, sp = h.tapBody(resp.Body, ignored)
+		h.relayResponse(rw, resp)
+		received = time.Now()
+		respBody, respBlob = h.finishSpill(sp, nil, resp.Header.Get("Content-Encoding"))
+	} else {
+		respBody, _ = io.ReadAll(resp.Body)
+		received = time.Now()
+		if len(h.BodyRewrites) > 0 {
+			respBody = h.applyBodyRewrites(respBody)
+		}
 
-	if !ignored && h.Hooks != nil {
-		status := resp.StatusCode
-		respBody = h.Hooks.RunOnResponse(outReq.Method, outURL.String(), &status, resp.Header, respBody)
-		resp.StatusCode = status
+		if !ignored && h.Hooks != nil {
+			status := resp.StatusCode
+			respBody = h.Hooks.RunOnResponse(outReq.Method, outURL.String(), &status, resp.Header, respBody)
+			resp.StatusCode = status
+		}
 	}
 
 	captureBody := decompressBody(respBody, resp.Header.Get("Content-Encoding"))
@@ -568,17 +687,22 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  reqProto(req),
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     outURL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				Method:   req.Method,
+				URL:      outURL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+				BodyBlob: reqBlob,
 			},
 			Response: &capture.ResponseSnapshot{
 				StatusCode: resp.StatusCode,
 				Headers:    respHeaders,
 				Body:       capture.BodyBytes(h.capSlice(captureBody)),
+				BodyBlob:   respBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(received),
+			Conn:     trace.conn(),
+			TLS:      h.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 		}
 		c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 		if isGRPC(outReq.Header) || isGRPC(resp.Header) {
@@ -595,6 +719,9 @@ func (h *Handler) serveReverse(rw http.ResponseWriter, req *http.Request) {
 	if len(h.Shadows) > 0 {
 		go h.fireShadows(req.Method, outURL.String(), req.Header, bodyBuf)
 	}
+	if streaming {
+		return
+	}
 	if h.Delay > 0 {
 		time.Sleep(h.Delay)
 	}
@@ -626,7 +753,7 @@ func (h *Handler) tryServeHTTPWebSocket(rw http.ResponseWriter, outReq *http.Req
 	var oc net.Conn
 	var err error
 	if strings.EqualFold(outReq.URL.Scheme, "https") {
-		oc, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1", "http/1.0"}})
+		oc, err = tls.DialWithDialer(dialer, "tcp", addr, h.UpstreamTLS.Config(host, "http/1.1", "http/1.0"))
 	} else {
 		oc, err = dialer.Dial("tcp", addr)
 	}
@@ -714,6 +841,18 @@ func (h *Handler) tryServeHTTPWebSocket(rw http.ResponseWriter, outReq *http.Req
 }
 
 func (h *Handler) serveCONNECT(rw http.ResponseWriter, req *http.Request) {
+	hostname := req.Host
+	if idx := strings.Index(hostname, ":"); idx != -1 {
+		hostname = hostname[:idx]
+	}
+	switch h.Policy.Decide(hostname) {
+	case policy.Block:
+		http.Error(rw, h.blocked(hostname, req.Host), http.StatusForbidden)
+		return
+	case policy.Passthrough:
+		h.tunnelCONNECT(rw, req)
+		return
+	}
 	if h.isIgnored(req.Host) {
 		h.tunnelCONNECT(rw, req)
 		return
@@ -752,15 +891,19 @@ func (h *Handler) mitmCONNECT(rw http.ResponseWriter, req *http.Request) {
 		host = host + ":443"
 	}
 
-	originConn, err := tls.Dial("tcp", host, &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"http/1.1"},
-	})
+	rawConn, setup, err := dialOrigin(host)
 	if err != nil {
 		h.Log.Error("mitm dial origin", "host", host, "err", err)
 		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
+	serverName, _, _ := net.SplitHostPort(host)
+	originConn, err := h.handshakeOrigin(rawConn, serverName, setup)
+	if err != nil {
+		h.Log.Error("mitm dial origin", "host", host, "err", err)
+		http.Error(rw, h.originFailure(host, serverName, setup, err), http.StatusBadGateway)
+		return
+	}
 	defer originConn.Close()
 
 	rw.WriteHeader(http.StatusOK)
@@ -778,35 +921,133 @@ func (h *Handler) mitmCONNECT(rw http.ResponseWriter, req *http.Request) {
 	if idx := strings.Index(hostname, ":"); idx != -1 {
 		hostname = hostname[:idx]
 	}
-	tlsCert, tlsKey, err := h.HostCerts.GetCertificate(hostname)
+	tlsClientConn, err := h.clientTLS(clientConn, hostname)
 	if err != nil {
-		h.Log.Error("get cert", "host", hostname, "err", err)
+		h.clientHandshakeFailed(hostname, err)
 		return
 	}
+	h.mitmServe(tlsClientConn, originConn, hostname, setup)
+}
+
+// blocked records a connection the MITM policy refused and returns the
+// message to give the client.
+func (h *Handler) blocked(hostname, target string) string {
+	msg := fmt.Sprintf("snare: %s is blocked by the MITM policy", hostname)
+	h.Log.Info("blocked", "host", target)
+	h.addCapture(&capture.Capture{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now(),
+		Request:   capture.RequestSnapshot{Method: http.MethodConnect, URL: "https://" + target},
+		Error:     msg,
+	})
+	return msg
+}
+
+// clientHandshakeFailed logs a failed client handshake and, when the
+// client refused snare's certificate, counts it towards marking the host
+// as pinned.
+func (h *Handler) clientHandshakeFailed(hostname string, err error) {
+	h.Log.Error("client TLS handshake", "host", hostname, "err", err)
+	if rejectedCertificate(err) && h.Policy.HandshakeFailed(hostname) {
+		h.Log.Warn("client keeps rejecting snare's certificate, likely pinning; passing host through", "host", hostname)
+	}
+}
+
+// rejectedCertificate reports whether a client ended the handshake with
+// a certificate alert, as clients that pin their origin's certificate do.
+func rejectedCertificate(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "remote error" && strings.Contains(op.Err.Error(), "certificate")
+}
+
+// dialOrigin opens the TCP connection to a MITM'd origin, timing the
+// connect into a new phaseTrace.
+func dialOrigin(host string) (net.Conn, *phaseTrace, error) {
+	setup := &phaseTrace{connStart: time.Now()}
+	rawConn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	setup.connDone = time.Now()
+	setup.remote, setup.local = rawConn.RemoteAddr().String(), rawConn.LocalAddr().String()
+	return rawConn, setup, nil
+}
+
+// handshakeOrigin starts TLS with the origin over rawConn. rawConn is
+// closed if the handshake fails.
+func (h *Handler) handshakeOrigin(rawConn net.Conn, serverName string, setup *phaseTrace) (*tls.Conn, error) {
+	originConn := tls.Client(rawConn, h.UpstreamTLS.Config(serverName, "http/1.1"))
+	setup.tlsStart = time.Now()
+	if err := originConn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	setup.tlsDone = time.Now()
+	return originConn, nil
+}
+
+// originFailure records a failed origin handshake as an error capture
+// when the origin's certificate was rejected, and returns the message to
+// give the client.
+func (h *Handler) originFailure(host, serverName string, setup *phaseTrace, err error) string {
+	info := verifyFailure(err, serverName)
+	if info == nil {
+		return err.Error()
+	}
+	msg := fmt.Sprintf("snare: certificate of %s failed verification: %s", host, info.VerifyError)
+	h.addCapture(&capture.Capture{
+		ID:        uuid.New().String(),
+		Timestamp: setup.connStart,
+		Request:   capture.RequestSnapshot{Method: http.MethodConnect, URL: "https://" + host},
+		Timings:   setup.timings(time.Now()),
+		Conn:      setup.conn(),
+		TLS:       info,
+		Error:     msg,
+	})
+	return msg
+}
+
+// clientTLS terminates the client's TLS with a certificate for the SNI it
+// sends, or for hostname when it sends none.
+func (h *Handler) clientTLS(clientConn net.Conn, hostname string) (*tls.Conn, error) {
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{{
-			Certificate: [][]byte{tlsCert.Raw},
-			PrivateKey:  tlsKey,
-		}},
-		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = hostname
+			}
+			tlsCert, err := h.HostCerts.TLSCertificate(name)
+			if err != nil {
+				h.Log.Error("get cert", "host", name, "err", err)
+				return nil, err
+			}
+			return tlsCert, nil
+		},
+		NextProtos:   []string{"h2", "http/1.1"},
+		KeyLogWriter: h.KeyLog,
 	}
 	tlsClientConn := tls.Server(clientConn, tlsConfig)
 	if err := tlsClientConn.Handshake(); err != nil {
-		h.Log.Error("client TLS handshake", "err", err)
-		return
+		return nil, err
 	}
+	return tlsClientConn, nil
+}
 
-	clientProto := tlsClientConn.ConnectionState().NegotiatedProtocol
-	if clientProto == "h2" {
-		h.mitmHTTP2(tlsClientConn, originConn, hostname, req)
+// mitmServe relays the requests on a terminated client connection to the
+// origin, over HTTP/2 when the client negotiated it.
+func (h *Handler) mitmServe(tlsClientConn *tls.Conn, originConn *tls.Conn, hostname string, setup *phaseTrace) {
+	if tlsClientConn.ConnectionState().NegotiatedProtocol == "h2" {
+		h.mitmHTTP2(tlsClientConn, hostname)
 		return
 	}
-	h.mitmHTTP1(tlsClientConn, originConn, hostname, req)
+	h.mitmHTTP1(tlsClientConn, originConn, hostname, setup)
 }
 
-func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname string, _ *http.Request) {
+func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname string, setup *phaseTrace) {
 	originReader := bufio.NewReader(originConn)
 	clientReader := bufio.NewReader(clientConn)
+	originState := originConn.ConnectionState()
+	originTLS := h.originTLS(&originState, hostname)
 
 	for {
 		req, err := http.ReadRequest(clientReader)
@@ -828,9 +1069,9 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 			continue
 		}
 
-		if !ignored && h.Mocks != nil {
-			if rule := h.Mocks.Match(req); rule != nil {
-				if writeMockH1(clientConn, req, rule, h.Log) {
+		if !ignored {
+			if rule, c := h.matchMock(req, req.URL.String()); rule != nil {
+				if h.writeMockH1(clientConn, req, rule, c) {
 					continue
 				}
 			}
@@ -838,8 +1079,15 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 
 		start := time.Now()
 		capID := uuid.New().String()
-		bodyBuf, _ := io.ReadAll(req.Body)
-		req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
+		var bodyBuf []byte
+		var reqSpill *capture.Spill
+		streamReq := h.streamsRequest(req, ignored)
+		if streamReq {
+			req.Body, reqSpill = h.tapBody(req.Body, ignored)
+		} else {
+			bodyBuf, _ = io.ReadAll(req.Body)
+			req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
+		}
 
 		if !ignored && h.Intercept != nil && intercept.MatchesPattern(req, h.InterceptMatch) {
 			newBody, dropped := h.holdAndApply(req, capID, start, req.URL.String(), bodyBuf)
@@ -882,24 +1130,40 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 			}
 		}
 
-		reqBytes, _ := httputil.DumpRequest(req, false)
-		if _, err := originConn.Write(reqBytes); err != nil {
+		trace := &phaseTrace{
+			start: start, gotConn: time.Now(), reused: setup == nil,
+			remote: originConn.RemoteAddr().String(), local: originConn.LocalAddr().String(),
+			client: clientConn.RemoteAddr().String(), listener: clientConn.LocalAddr().String(),
+		}
+		if setup != nil {
+			trace.connStart, trace.connDone, trace.tlsStart, trace.tlsDone = setup.connStart, setup.connDone, setup.tlsStart, setup.tlsDone
+			setup = nil
+		}
+		if streamReq {
+			err = req.Write(originConn)
+		} else {
+			reqBytes, _ := httputil.DumpRequest(req, false)
+			if _, err = originConn.Write(reqBytes); err == nil && len(bodyBuf) > 0 {
+				_, _ = originConn.Write(bodyBuf)
+			}
+		}
+		trace.wrote = time.Now()
+		bodyBuf, reqBlob := h.finishSpill(reqSpill, bodyBuf, req.Header.Get("Content-Encoding"))
+		if err != nil {
 			if !ignored {
-				h.addCapture(&capture.Capture{ID: capID, Timestamp: start, Error: err.Error()})
+				h.addCapture(&capture.Capture{ID: capID, Timestamp: start, Timings: trace.timings(time.Now()), Conn: trace.conn(), TLS: originTLS, Error: err.Error()})
 			}
 			return
 		}
-		if len(bodyBuf) > 0 {
-			_, _ = originConn.Write(bodyBuf)
-		}
 
 		resp, err := http.ReadResponse(originReader, req)
 		if err != nil {
 			if !ignored {
-				h.addCapture(&capture.Capture{ID: capID, Timestamp: start, Error: err.Error()})
+				h.addCapture(&capture.Capture{ID: capID, Timestamp: start, Timings: trace.timings(time.Now()), Conn: trace.conn(), TLS: originTLS, Error: err.Error()})
 			}
 			return
 		}
+		trace.firstByte = time.Now()
 		duration := time.Since(start)
 
 		resp.Header.Del("Alt-Svc")
@@ -917,12 +1181,16 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 				Timestamp: start,
 				Protocol:  "h1",
 				Request: capture.RequestSnapshot{
-					Method:  req.Method,
-					URL:     req.URL.String(),
-					Headers: reqHeaders,
-					Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+					Method:   req.Method,
+					URL:      req.URL.String(),
+					Headers:  reqHeaders,
+					Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+					BodyBlob: reqBlob,
 				},
 				Duration: duration,
+				Timings:  trace.timings(time.Now()),
+				Conn:     trace.conn(),
+				TLS:      originTLS,
 			}
 			c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 			fmt.Fprintf(clientConn, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
@@ -945,17 +1213,37 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 			return
 		}
 
-		respBody, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		var respBody []byte
+		var respBlob *capture.BlobRef
+		var received time.Time
+		streaming := h.streamsResponse(req, resp, ignored)
+		if streaming {
+			var sp *capture.Spill
+			resp.Body, sp = h.tapBody(resp.Body, ignored)
+			if h.Delay > 0 {
+				time.Sleep(h.Delay)
+			}
+			werr := resp.Write(clientConn)
+			resp.Body.Close()
+			received = time.Now()
+			respBody, respBlob = h.finishSpill(sp, nil, resp.Header.Get("Content-Encoding"))
+			if werr != nil {
+				return
+			}
+		} else {
+			respBody, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			received = time.Now()
 
-		if len(h.BodyRewrites) > 0 {
-			respBody = h.applyBodyRewrites(respBody)
-		}
+			if len(h.BodyRewrites) > 0 {
+				respBody = h.applyBodyRewrites(respBody)
+			}
 
-		if !ignored && h.Hooks != nil {
-			status := resp.StatusCode
-			respBody = h.Hooks.RunOnResponse(req.Method, req.URL.String(), &status, resp.Header, respBody)
-			resp.StatusCode = status
+			if !ignored && h.Hooks != nil {
+				status := resp.StatusCode
+				respBody = h.Hooks.RunOnResponse(req.Method, req.URL.String(), &status, resp.Header, respBody)
+				resp.StatusCode = status
+			}
 		}
 
 		if !ignored {
@@ -974,17 +1262,22 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 				Timestamp: start,
 				Protocol:  proto,
 				Request: capture.RequestSnapshot{
-					Method:  req.Method,
-					URL:     req.URL.String(),
-					Headers: reqHeaders,
-					Body:    capture.BodyBytes(h.capSlice(reqCaptureBody)),
+					Method:   req.Method,
+					URL:      req.URL.String(),
+					Headers:  reqHeaders,
+					Body:     capture.BodyBytes(h.capSlice(reqCaptureBody)),
+					BodyBlob: reqBlob,
 				},
 				Response: &capture.ResponseSnapshot{
 					StatusCode: resp.StatusCode,
 					Headers:    respHeaders,
 					Body:       capture.BodyBytes(h.capSlice(captureBody)),
+					BodyBlob:   respBlob,
 				},
 				Duration: duration,
+				Timings:  trace.timings(received),
+				Conn:     trace.conn(),
+				TLS:      originTLS,
 			}
 			c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 			if proto == "ws" {
@@ -1007,6 +1300,9 @@ func (h *Handler) mitmHTTP1(clientConn net.Conn, originConn *tls.Conn, hostname
 			h.Log.Info("captured", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "id", capID[:8])
 		}
 
+		if streaming {
+			continue
+		}
 		if h.Delay > 0 {
 			time.Sleep(h.Delay)
 		}
@@ -1062,56 +1358,160 @@ func writeDropH1(conn net.Conn) error {
 	return err
 }
 
-func writeMockH1(conn net.Conn, req *http.Request, rule *mock.Rule, log *slog.Logger) bool {
-	status := rule.Status
-	if status == 0 {
-		status = http.StatusOK
-	}
-	body := []byte(rule.Body)
-	ct := rule.ContentType
-	if ct == "" {
-		ct = "application/json"
+func (h *Handler) writeMockH1(conn net.Conn, req *http.Request, rule *mock.Rule, c *capture.Capture) bool {
+	mr := rule.Respond(req)
+	if rule.Fault != nil {
+		// A broken connection still counts as handled: the next read on
+		// it fails and ends the loop instead of forwarding the request.
+		if _, err := writeMockFaultH1(conn, mr, rule.Fault); err != nil {
+			h.Log.Debug("write mock h1", "err", err)
+		}
+		h.Log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", mr.Status, "rule", rule.ID[:8], "fault", faultName(rule.Fault))
+		h.recordMock(c, rule, sentMock(mr, rule.Fault))
+		return true
 	}
 	resp := &http.Response{
-		StatusCode:    status,
+		StatusCode:    mr.Status,
 		Proto:         "HTTP/1.1",
 		ProtoMajor:    1,
 		ProtoMinor:    1,
-		Header:        make(http.Header),
-		Body:          io.NopCloser(bytes.NewReader(body)),
-		ContentLength: int64(len(body)),
+		Header:        mr.Header,
+		Body:          io.NopCloser(bytes.NewReader(mr.Body)),
+		ContentLength: int64(len(mr.Body)),
 		Request:       req,
 	}
-	resp.Header.Set("Content-Type", ct)
-	for k, v := range rule.Headers {
-		resp.Header.Set(k, v)
-	}
+	h.recordMock(c, rule, mr)
 	if err := resp.Write(conn); err != nil {
-		log.Error("write mock h1", "err", err)
+		h.Log.Error("write mock h1", "err", err)
 		return false
 	}
-	log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", status, "rule", rule.ID[:8])
+	h.Log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", mr.Status, "rule", rule.ID[:8])
 	return true
 }
 
-func (h *Handler) serveMock(rw http.ResponseWriter, req *http.Request, rule *mock.Rule) {
-	ct := rule.ContentType
-	if ct == "" {
-		ct = "application/json"
+func (h *Handler) serveMock(rw http.ResponseWriter, req *http.Request, rule *mock.Rule, c *capture.Capture) {
+	mr := rule.Respond(req)
+	f := rule.Fault
+	if f == nil {
+		h.recordMock(c, rule, mr)
+		for k, v := range mr.Header {
+			rw.Header()[k] = v
+		}
+		rw.WriteHeader(mr.Status)
+		_, _ = rw.Write(mr.Body)
+		h.Log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", mr.Status, "rule", rule.ID[:8])
+		return
 	}
-	for k, v := range rule.Headers {
-		rw.Header().Set(k, v)
+
+	h.Log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", mr.Status, "rule", rule.ID[:8], "fault", faultName(f))
+	if d := f.Wait(); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-req.Context().Done():
+			if c != nil {
+				c.Error = req.Context().Err().Error()
+			}
+			h.recordMock(c, rule, nil)
+			return
+		}
 	}
-	if rw.Header().Get("Content-Type") == "" {
-		rw.Header().Set("Content-Type", ct)
+	h.recordMock(c, rule, sentMock(mr, f))
+	if f.Abort == mock.AbortReset || f.Abort == mock.AbortMalformed {
+		if hj, ok := rw.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				breakConn(conn, f.Abort)
+				return
+			}
+		}
+		// HTTP/2 and HTTP/3 cannot be hijacked; reset the stream instead.
+		panic(http.ErrAbortHandler)
+	}
+	for k, v := range mr.Header {
+		rw.Header()[k] = v
+	}
+	rw.Header().Set("Content-Length", strconv.Itoa(len(mr.Body)))
+	rw.WriteHeader(mr.Status)
+	flush := func() {}
+	if fl, ok := rw.(http.Flusher); ok {
+		flush = fl.Flush
+	}
+	if cut, _ := sendMockBody(rw, flush, mr.Body, f); cut {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// matchMock returns the mock rule that answers req, if any, with a capture
+// to record the exchange under rawURL. The capture is nil when rawURL is
+// ignored.
+func (h *Handler) matchMock(req *http.Request, rawURL string) (*mock.Rule, *capture.Capture) {
+	if h.Mocks == nil {
+		return nil, nil
+	}
+	var c *capture.Capture
+	capID := ""
+	if !h.isIgnored(rawURL) {
+		capID = uuid.New().String()
+		c = &capture.Capture{ID: capID, Timestamp: time.Now(), Protocol: reqProto(req)}
+	}
+	rule := h.Mocks.MatchCapture(req, capID)
+	if rule == nil || c == nil {
+		return rule, nil
+	}
+	// Read the body now: once the mock is written it may be unreadable.
+	body, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request = capture.RequestSnapshot{
+		Method:  req.Method,
+		URL:     rawURL,
+		Headers: req.Header.Clone(),
+		Body:    capture.BodyBytes(h.capSlice(body)),
+	}
+	return rule, c
+}
+
+// recordMock stores the capture of a mocked exchange. mr is what was sent,
+// or nil when the fault sent no response.
+func (h *Handler) recordMock(c *capture.Capture, rule *mock.Rule, mr *mock.Response) {
+	if c == nil {
+		return
+	}
+	c.Duration = time.Since(c.Timestamp)
+	c.Mock = rule.ID
+	if mr != nil {
+		c.Response = &capture.ResponseSnapshot{
+			StatusCode: mr.Status,
+			Headers:    mr.Header.Clone(),
+			Body:       capture.BodyBytes(h.capSlice(mr.Body)),
+		}
+	}
+	if f := rule.Fault; f != nil && f.Abort != "" && c.Error == "" {
+		c.Error = "mock fault: " + f.Abort
+	}
+	h.addCapture(c)
+}
+
+// sentMock returns the part of mr a fault lets through: nil for a reset
+// or malformed response, and the body up to the cut for close.
+func sentMock(mr *mock.Response, f *mock.Fault) *mock.Response {
+	switch f.Abort {
+	case mock.AbortReset, mock.AbortMalformed:
+		return nil
+	case mock.AbortClose:
+		cut := *mr
+		cut.Body = mr.Body[:f.BodyLimit(len(mr.Body))]
+		return &cut
+	}
+	return mr
+}
+
+func faultName(f *mock.Fault) string {
+	if f.Abort != "" {
+		return f.Abort
 	}
-	status := rule.Status
-	if status == 0 {
-		status = http.StatusOK
+	if f.BytesPerSecond > 0 {
+		return "throttle"
 	}
-	rw.WriteHeader(status)
-	_, _ = rw.Write([]byte(rule.Body))
-	h.Log.Info("mocked", "method", req.Method, "url", req.URL.String(), "status", status, "rule", rule.ID[:8])
+	return "delay"
 }
 
 func (h *Handler) applyOutboundMods(req *http.Request) {
@@ -33,18 +33,22 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if m.parent.Mocks != nil {
-		if rule := m.parent.Mocks.Match(req); rule != nil {
-			m.parent.serveMock(rw, req, rule)
-			return
-		}
+	if rule, c := m.parent.matchMock(req, req.URL.String()); rule != nil {
+		m.parent.serveMock(rw, req, rule, c)
+		return
 	}
 
 	start := time.Now()
 	capID := uuid.New().String()
 
-	bodyBuf, _ := io.ReadAll(req.Body)
-	req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
+	var bodyBuf []byte
+	var reqSpill *capture.Spill
+	if m.parent.streamsRequest(req, false) {
+		req.Body, reqSpill = m.parent.tapBody(req.Body, false)
+	} else {
+		bodyBuf, _ = io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(bodyBuf))
+	}
 
 	if m.parent.Intercept != nil && intercept.MatchesPattern(req, m.parent.InterceptMatch) {
 		newBody, dropped := m.parent.holdAndApply(req, capID, start, req.URL.String(), bodyBuf)
@@ -59,6 +63,9 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	outReq, _ := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(bodyBuf))
 	outReq.Header = req.Header.Clone()
 	outReq.Host = m.hostname
+	if reqSpill != nil {
+		outReq.Body, outReq.ContentLength, outReq.GetBody = req.Body, req.ContentLength, nil
+	}
 	m.parent.applyOutboundMods(outReq)
 
 	if m.parent.Hooks != nil {
@@ -88,9 +95,11 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	outReq, trace := traceRequest(req, outReq)
 	resp, err := m.transport.RoundTrip(outReq)
+	bodyBuf, reqBlob := m.parent.finishSpill(reqSpill, bodyBuf, req.Header.Get("Content-Encoding"))
 	if err != nil {
-		m.parent.addCapture(&capture.Capture{ID: capID, Timestamp: start, Protocol: "h2", Error: err.Error()})
+		m.parent.addCapture(&capture.Capture{ID: capID, Timestamp: start, Protocol: "h2", Timings: trace.timings(time.Now()), Conn: trace.conn(), TLS: verifyFailure(err, m.hostname), Error: err.Error()})
 		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -112,24 +121,41 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			Timestamp: start,
 			Protocol:  "h2",
 			Request: capture.RequestSnapshot{
-				Method:  req.Method,
-				URL:     req.URL.String(),
-				Headers: reqHeaders,
-				Body:    capture.BodyBytes(reqCaptureBody),
+				Method:   req.Method,
+				URL:      req.URL.String(),
+				Headers:  reqHeaders,
+				Body:     capture.BodyBytes(reqCaptureBody),
+				BodyBlob: reqBlob,
 			},
 			Duration: duration,
+			Timings:  trace.timings(time.Now()),
+			Conn:     trace.conn(),
+			TLS:      m.parent.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 		}
 		c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 		m.parent.streamSSE(rw, resp, c)
 		return
 	}
 
-	respBody, _ := io.ReadAll(resp.Body)
+	var respBody []byte
+	var respBlob *capture.BlobRef
+	var received time.Time
+	streaming := m.parent.streamsResponse(outReq, resp, false)
+	if streaming {
+		var sp *capture.Spill
+		resp.Body, sp = m.parent.tapBody(resp.Body, false)
+		m.parent.relayResponse(rw, resp)
+		received = time.Now()
+		respBody, respBlob = m.parent.finishSpill(sp, nil, resp.Header.Get("Content-Encoding"))
+	} else {
+		respBody, _ = io.ReadAll(resp.Body)
+		received = time.Now()
 
-	if m.parent.Hooks != nil {
-		status := resp.StatusCode
-		respBody = m.parent.Hooks.RunOnResponse(outReq.Method, outReq.URL.String(), &status, resp.Header, respBody)
-		resp.StatusCode = status
+		if m.parent.Hooks != nil {
+			status := resp.StatusCode
+			respBody = m.parent.Hooks.RunOnResponse(outReq.Method, outReq.URL.String(), &status, resp.Header, respBody)
+			resp.StatusCode = status
+		}
 	}
 
 	captureBody := decompressBody(respBody, resp.Header.Get("Content-Encoding"))
@@ -144,17 +170,22 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		Timestamp: start,
 		Protocol:  "h2",
 		Request: capture.RequestSnapshot{
-			Method:  req.Method,
-			URL:     req.URL.String(),
-			Headers: reqHeaders,
-			Body:    capture.BodyBytes(reqCaptureBody),
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Headers:  reqHeaders,
+			Body:     capture.BodyBytes(reqCaptureBody),
+			BodyBlob: reqBlob,
 		},
 		Response: &capture.ResponseSnapshot{
 			StatusCode: resp.StatusCode,
 			Headers:    respHeaders,
 			Body:       capture.BodyBytes(captureBody),
+			BodyBlob:   respBlob,
 		},
 		Duration: duration,
+		Timings:  trace.timings(received),
+		Conn:     trace.conn(),
+		TLS:      m.parent.originTLS(resp.TLS, resp.Request.URL.Hostname()),
 	}
 	c.GraphQL = detectGraphQL(req.Header.Get("Content-Type"), reqCaptureBody)
 	if isGRPC(req.Header) || isGRPC(resp.Header) {
@@ -165,6 +196,9 @@ func (m *mitmH2Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 	m.parent.addCapture(c)
+	if streaming {
+		return
+	}
 
 	for k, v := range resp.Header {
 		for _, vv := range v {
@@ -190,12 +224,9 @@ func (m *mitmH2Handler) serveH2WebSocket(rw http.ResponseWriter, req *http.Reque
 	}
 
 	addr := net.JoinHostPort(m.hostname, "443")
-	oc, err := tls.Dial("tcp", addr, &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"http/1.1"},
-	})
+	oc, err := tls.Dial("tcp", addr, m.parent.UpstreamTLS.Config(m.hostname, "http/1.1"))
 	if err != nil {
-		m.parent.addCapture(&capture.Capture{ID: capID, Timestamp: start, Error: err.Error()})
+		m.parent.addCapture(&capture.Capture{ID: capID, Timestamp: start, TLS: verifyFailure(err, m.hostname), Error: err.Error()})
 		http.Error(rw, err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -276,12 +307,15 @@ func (m *mitmH2Handler) serveH2WebSocket(rw http.ResponseWriter, req *http.Reque
 	m.parent.Log.Info("websocket", "url", req.URL.String(), "id", capID[:8], "proto", "h2")
 }
 
-func (h *Handler) mitmHTTP2(clientConn net.Conn, originConn *tls.Conn, hostname string, _ *http.Request) {
+func (h *Handler) mitmHTTP2(clientConn net.Conn, hostname string) {
 	srv := &http2.Server{}
 	handler := &mitmH2Handler{
 		hostname:  hostname,
 		parent:    h,
 		transport: h.Transport,
 	}
-	srv.ServeConn(clientConn, &http2.ServeConnOpts{Handler: handler})
+	// Requests carry the client connection's addresses, as they would
+	// from an http.Server.
+	ctx := context.WithValue(context.Background(), http.LocalAddrContextKey, clientConn.LocalAddr())
+	srv.ServeConn(clientConn, &http2.ServeConnOpts{Context: ctx, Handler: handler})
 }
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/mock"
+)
+
+func mockProxy(t *testing.T, rules ...*mock.Rule) (*httptest.Server, *http.Client) {
+	t.Helper()
+	mocks := mock.NewStore("")
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		_ = mocks.Add(r)
+	}
+	h := &Handler{
+		Transport: &http.Transport{},
+		Store:     capture.NewStore(10, ""),
+		Mocks:     mocks,
+		Log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	proxyURL, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}}
+	return srv, client
+}
+
+func TestMockCapturedAndHit(t *testing.T) {
+	srv, client := mockProxy(t, &mock.Rule{ID: "echo-rule", Method: "POST", URLPattern: "/echo", Status: 201, Body: "made"})
+	h := srv.Config.Handler.(*Handler)
+
+	for range 2 {
+		resp, err := client.Post("http://mock.test/echo", "text/plain", strings.NewReader("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	hits := h.Mocks.Hits()["echo-rule"]
+	if hits.Count != 2 || len(hits.Captures) != 2 {
+		t.Fatalf("hits = %+v", hits)
+	}
+	c := h.Store.Get(hits.Captures[1])
+	if c == nil {
+		t.Fatal("hit capture not stored")
+	}
+	if c.Mock != "echo-rule" || string(c.Request.Body) != "ping" || c.Response == nil || c.Response.StatusCode != 201 || string(c.Response.Body) != "made" {
+		t.Errorf("capture = %+v", c)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/muxover/snare/v2/mock"
+)
+
+// malformedResponse has a non-numeric status and an invalid length, which
+// every HTTP/1 client rejects.
+const malformedResponse = "HTTP/1.1 2x0 Malformed\r\nContent-Length: nan\r\nTransfer-Encoding: bogus\r\n\r\n\x00\xff"
+
+// sendMockBody writes body throttled to the fault's rate, stopping at its
+// body limit. It reports whether the body was cut short, in which case the
+// caller must break the connection.
+func sendMockBody(w io.Writer, flush func(), body []byte, f *mock.Fault) (bool, error) {
+	n := f.BodyLimit(len(body))
+	cut := f != nil && f.Abort == mock.AbortClose
+	rate := 0
+	if f != nil {
+		rate = f.BytesPerSecond
+	}
+	if rate <= 0 {
+		_, err := w.Write(body[:n])
+		flush()
+		return cut, err
+	}
+	// Ten writes a second keep the rate smooth without a syscall per byte.
+	chunk := max(rate/10, 1)
+	for off := 0; off < n; off += chunk {
+		end := min(off+chunk, n)
+		if _, err := w.Write(body[off:end]); err != nil {
+			return cut, err
+		}
+		flush()
+		if end < n {
+			time.Sleep(time.Duration(end-off) * time.Second / time.Duration(rate))
+		}
+	}
+	return cut, nil
+}
+
+// breakConn ends a connection for a reset or malformed fault.
+func breakConn(conn net.Conn, abort string) {
+	if abort == mock.AbortMalformed {
+		_, _ = io.WriteString(conn, malformedResponse)
+		_ = conn.Close()
+		return
+	}
+	resetConn(conn)
+}
+
+// resetConn closes conn with SO_LINGER 0, so the peer sees a TCP RST
+// rather than a clean close.
+func resetConn(conn net.Conn) {
+	raw := conn
+	if tc, ok := raw.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if tcp, ok := raw.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+		_ = tcp.Close()
+	}
+	_ = conn.Close()
+}
+
+// writeMockFaultH1 is writeMockH1 for a rule with a fault. It writes the
+// response by hand so the body can be throttled or cut off, and reports
+// whether the connection is still usable.
+func writeMockFaultH1(conn net.Conn, mr *mock.Response, f *mock.Fault) (bool, error) {
+	if d := f.Wait(); d > 0 {
+		time.Sleep(d)
+	}
+	if f.Abort == mock.AbortReset || f.Abort == mock.AbortMalformed {
+		breakConn(conn, f.Abort)
+		return false, nil
+	}
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "HTTP/1.1 %d %s\r\n", mr.Status, http.StatusText(mr.Status))
+	mr.Header.Set("Content-Length", strconv.Itoa(len(mr.Body)))
+	_ = mr.Header.Write(&head)
+	head.WriteString("\r\n")
+	if _, err := conn.Write(head.Bytes()); err != nil {
+		return false, err
+	}
+	cut, err := sendMockBody(conn, func() {}, mr.Body, f)
+	if cut || err != nil {
+		_ = conn.Close()
+		return false, err
+	}
+	return true, nil
+}
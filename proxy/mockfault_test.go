@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/mock"
+)
+
+func TestMockFaultDelayAndThrottle(t *testing.T) {
+	body := strings.Repeat("x", 400)
+	_, client := mockProxy(t,
+		&mock.Rule{ID: "slow-rule", URLPattern: "/slow", Body: "ok", Fault: &mock.Fault{Delay: "150ms"}},
+		&mock.Rule{ID: "drip-rule", URLPattern: "/drip", Body: body, Fault: &mock.Fault{BytesPerSecond: 1000}},
+	)
+
+	start := time.Now()
+	resp, err := client.Get("http://mock.test/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("delayed response took %v", d)
+	}
+
+	start = time.Now()
+	resp, err = client.Get("http://mock.test/drip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(got) != body {
+		t.Fatalf("throttled body = %d bytes, %v", len(got), err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("400 bytes at 1000 B/s took %v", d)
+	}
+}
+
+func TestMockFaultAborts(t *testing.T) {
+	srv, client := mockProxy(t,
+		&mock.Rule{ID: "close-rule", URLPattern: "/close", Body: strings.Repeat("y", 100), Fault: &mock.Fault{Abort: mock.AbortClose, AbortAfter: 10}},
+		&mock.Rule{ID: "reset-rule", URLPattern: "/reset", Fault: &mock.Fault{Abort: mock.AbortReset}},
+		&mock.Rule{ID: "malformed-rule", URLPattern: "/malformed", Fault: &mock.Fault{Abort: mock.AbortMalformed}},
+	)
+
+	resp, err := client.Get("http://mock.test/close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(got) != 10 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("close: read %d bytes, err %v", len(got), err)
+	}
+
+	// Read the raw reply so the reset is seen rather than retried.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "GET http://mock.test/reset HTTP/1.1\r\nHost: mock.test\r\n\r\n")
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("reset: read err = %v, want connection reset", err)
+	}
+
+	conn, err = net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "GET http://mock.test/malformed HTTP/1.1\r\nHost: mock.test\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		t.Error("malformed: response parsed")
+	}
+}
+
+func TestMockFaultInSequence(t *testing.T) {
+	_, client := mockProxy(t, &mock.Rule{ID: "flaky-rule", URLPattern: "/pay", Responses: []mock.Step{
+		{Fault: &mock.Fault{Abort: mock.AbortClose}, Body: "partial body"},
+		{Status: 200, Body: "ok"},
+	}})
+	resp, err := client.Get("http://mock.test/pay")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("first attempt should fail")
+	}
+	resp, err = client.Get("http://mock.test/pay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != "ok" {
+		t.Errorf("second attempt = %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/policy"
+	"github.com/muxover/snare/v2/proxy/cert"
+)
+
+func TestConnectPolicy(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+	originRoots := x509.NewCertPool()
+	originRoots.AddCert(origin.Certificate())
+
+	caCert, caKey, err := cert.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := capture.NewStore(10, "")
+	pol := policy.NewStore(filepath.Join(t.TempDir(), "policy.json"), nil, 2)
+	h := &Handler{
+		Transport:   &http.Transport{},
+		Store:       store,
+		Log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MitmEnable:  true,
+		HostCerts:   cert.NewHostCertCache(caCert, caKey),
+		UpstreamTLS: &UpstreamTLS{RootCAs: originRoots},
+		Policy:      pol,
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+	proxyURL, _ := url.Parse(proxySrv.URL)
+
+	// The client trusts only the origin, as if it pinned its certificate.
+	get := func() (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: originRoots},
+		}}
+		resp, err := client.Get(origin.URL + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := get(); err == nil {
+			t.Fatal("expected the pinned client to reject snare's certificate")
+		}
+	}
+	// The proxy sees the client's alert after the client has given up.
+	deadline := time.Now().Add(2 * time.Second)
+	for pol.Decide("127.0.0.1") != policy.Passthrough {
+		if time.Now().After(deadline) {
+			t.Fatal("pinning was not detected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if body, err := get(); err != nil || body != "origin" {
+		t.Fatalf("passthrough: body = %q, err = %v", body, err)
+	}
+	if n := len(store.List(0)); n != 0 {
+		t.Fatalf("passthrough recorded %d captures", n)
+	}
+
+	if err := pol.Add(policy.Rule{Pattern: "127.0.0.1", Action: policy.Block}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(); err == nil {
+		t.Fatal("expected blocked CONNECT to fail")
+	}
+	list := store.List(0)
+	if len(list) != 1 || !strings.Contains(list[0].Error, "blocked") {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func TestStreamPolicyUsesSNI(t *testing.T) {
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	dialed := make(chan struct{}, 1)
+	go func() {
+		if c, err := origin.Accept(); err == nil {
+			dialed <- struct{}{}
+			c.Close()
+		}
+	}()
+	srv, store, _ := redirectedTo(t, origin.Addr().String(), nil)
+	srv.Handler.Policy = policy.NewStore("", []policy.Rule{{Pattern: "*.example.test", Action: policy.Block}}, 0)
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{ServerName: "api.example.test", InsecureSkipVerify: true})
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected the blocked stream to be closed")
+	}
+	list := store.List(0)
+	if len(list) != 1 || !strings.Contains(list[0].Error, "api.example.test is blocked") {
+		t.Fatalf("captures = %+v", list)
+	}
+	select {
+	case <-dialed:
+		t.Fatal("blocked stream dialed the origin")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
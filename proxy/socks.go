@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/muxover/snare/v2/policy"
+)
+
+const socksHandshakeTimeout = 15 * time.Second
+
+// SocksServer is a SOCKS5 and SOCKS4a front end for a Handler. TLS streams
+// go through the MITM path, plaintext HTTP through the HTTP proxy path,
+// and anything else is tunneled untouched.
+type SocksServer struct {
+	Listener net.Listener
+	Handler  *Handler
+	// Users maps usernames to passwords. When it is empty any client is
+	// accepted. Either way the SOCKS username, or the SOCKS4 user ID, tags
+	// the client's captures.
+	Users map[string]string
+	Log   *slog.Logger
+}
+
+func NewSocksServer(addr string, h *Handler, users map[string]string, log *slog.Logger) (*SocksServer, error) {
+	if log == nil {
+		log = slog.Default()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &SocksServer{Listener: ln, Handler: h, Users: users, Log: log}, nil
+}
+
+func (s *SocksServer) Start() {
+	go func() {
+		for {
+			conn, err := s.Listener.Accept()
+			if err != nil {
+				return
+			}
+			s.Log.Debug("socks connection", "remote", conn.RemoteAddr().String())
+			go s.serveConn(conn)
+		}
+	}()
+}
+
+func (s *SocksServer) Close() error {
+	return s.Listener.Close()
+}
+
+// errSocksBlocked is the reply error for a destination the policy blocks.
+var errSocksBlocked = errors.New("blocked by policy")
+
+// socksRequest is a parsed CONNECT request, with reply writing the
+// version-specific answer to it.
+type socksRequest struct {
+	target string
+	user   string
+	reply  func(ok bool, err error) error
+}
+
+func (s *SocksServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	br := bufio.NewReader(conn)
+	ver, err := br.ReadByte()
+	if err != nil {
+		return
+	}
+	var req *socksRequest
+	switch ver {
+	case 5:
+		req, err = s.handshake5(br, conn)
+	case 4:
+		req, err = s.handshake4(br, conn)
+	default:
+		err = fmt.Errorf("unsupported SOCKS version %d", ver)
+	}
+	if err != nil {
+		s.Log.Debug("socks handshake", "remote", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+
+	h := s.Handler.forClient(req.user)
+	h.Log.Info("socks", "target", req.target, "user", req.user, "remote", conn.RemoteAddr().String())
+	// The client sends nothing until it has a reply, so a block is decided
+	// on the destination here; serveStream checks the SNI again.
+	if host, _, _ := net.SplitHostPort(req.target); h.Policy.Decide(host) == policy.Block {
+		h.blocked(host, req.target)
+		_ = req.reply(false, errSocksBlocked)
+		return
+	}
+	upstream, setup, err := dialOrigin(req.target)
+	if err != nil {
+		h.Log.Error("socks dial", "target", req.target, "err", err)
+		_ = req.reply(false, err)
+		return
+	}
+	defer upstream.Close()
+	if err := req.reply(true, nil); err != nil {
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	h.serveStream(conn, br, req.target, upstream, setup)
+}
+
+// handshake5 negotiates auth and reads the request of a SOCKS5 client
+// whose version byte has been consumed.
+func (s *SocksServer) handshake5(br *bufio.Reader, conn net.Conn) (*socksRequest, error) {
+	n, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	methods := make([]byte, n)
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return nil, err
+	}
+	method := byte(0xff)
+	switch {
+	case bytes.IndexByte(methods, 0x02) >= 0:
+		method = 0x02
+	case bytes.IndexByte(methods, 0x00) >= 0 && len(s.Users) == 0:
+		method = 0x00
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return nil, err
+	}
+	if method == 0xff {
+		return nil, errors.New("no acceptable auth method")
+	}
+
+	var user string
+	if method == 0x02 {
+		// RFC 1929 username/password subnegotiation.
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return nil, err
+		}
+		name := make([]byte, hdr[1])
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		plen, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		pass := make([]byte, plen)
+		if _, err := io.ReadFull(br, pass); err != nil {
+			return nil, err
+		}
+		user = string(name)
+		if want, ok := s.Users[user]; len(s.Users) > 0 && (!ok || want != string(pass)) {
+			_, _ = conn.Write([]byte{1, 1})
+			return nil, fmt.Errorf("bad credentials for %q", user)
+		}
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return nil, err
+		}
+	}
+
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	reply := func(code byte) error {
+		_, err := conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	if hdr[0] != 5 {
+		return nil, fmt.Errorf("bad request version %d", hdr[0])
+	}
+	var host string
+	switch hdr[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, 4)
+		if hdr[3] == 0x04 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		l, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		_ = reply(0x08)
+		return nil, fmt.Errorf("unsupported address type %d", hdr[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return nil, err
+	}
+	if hdr[1] != 0x01 {
+		_ = reply(0x07)
+		return nil, fmt.Errorf("unsupported command %d", hdr[1])
+	}
+	return &socksRequest{
+		target: net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))),
+		user:   user,
+		reply: func(ok bool, err error) error {
+			switch {
+			case ok:
+				return reply(0x00)
+			case errors.Is(err, errSocksBlocked):
+				return reply(0x02)
+			case errors.Is(err, syscall.ECONNREFUSED):
+				return reply(0x05)
+			default:
+				return reply(0x04)
+			}
+		},
+	}, nil
+}
+
+// handshake4 reads the request of a SOCKS4 or SOCKS4a client whose
+// version byte has been consumed. SOCKS4 carries no password, so when
+// Users is set only users with an empty password may use it.
+func (s *SocksServer) handshake4(br *bufio.Reader, conn net.Conn) (*socksRequest, error) {
+	hdr := make([]byte, 7)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	user, err := br.ReadString(0)
+	if err != nil {
+		return nil, err
+	}
+	user = user[:len(user)-1]
+	reply := func(ok bool, _ error) error {
+		code := byte(0x5b)
+		if ok {
+			code = 0x5a
+		}
+		_, err := conn.Write([]byte{0, code, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	host := net.IP(hdr[3:7]).String()
+	if hdr[3] == 0 && hdr[4] == 0 && hdr[5] == 0 && hdr[6] != 0 {
+		// SOCKS4a: the client could not resolve the name and sends it.
+		name, err := br.ReadString(0)
+		if err != nil {
+			return nil, err
+		}
+		host = name[:len(name)-1]
+	}
+	if hdr[0] != 0x01 {
+		_ = reply(false, nil)
+		return nil, fmt.Errorf("unsupported command %d", hdr[0])
+	}
+	if want, ok := s.Users[user]; len(s.Users) > 0 && (!ok || want != "") {
+		_ = reply(false, nil)
+		return nil, fmt.Errorf("SOCKS4 user %q not allowed", user)
+	}
+	return &socksRequest{
+		target: net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(hdr[1:3])))),
+		user:   user,
+		reply:  reply,
+	}, nil
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/proxy/cert"
+	xproxy "golang.org/x/net/proxy"
+)
+
+func startSocks(t *testing.T, users map[string]string) (*SocksServer, *capture.Store, *x509.Certificate) {
+	t.Helper()
+	store := capture.NewStore(10, "")
+	caCert, caKey, err := cert.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{
+		Transport:  &http.Transport{},
+		Store:      store,
+		Log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MitmEnable: true,
+		HostCerts:  cert.NewHostCertCache(caCert, caKey),
+		// The test origins use httptest's self-signed certificate.
+		UpstreamTLS: &UpstreamTLS{Insecure: []string{"*"}},
+	}
+	srv, err := NewSocksServer("127.0.0.1:0", h, users, h.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+	return srv, store, caCert
+}
+
+func socksClient(t *testing.T, srv *SocksServer, auth *xproxy.Auth, tlsCfg *tls.Config) *http.Client {
+	t.Helper()
+	dialer, err := xproxy.SOCKS5("tcp", srv.Listener.Addr().String(), auth, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.(xproxy.ContextDialer).DialContext(ctx, network, addr)
+		},
+		TLSClientConfig: tlsCfg,
+	}}
+}
+
+func TestSocks5PlainHTTPTaggedByUser(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "plain")
+	}))
+	defer origin.Close()
+	srv, store, _ := startSocks(t, map[string]string{"ci": "secret"})
+
+	bad := socksClient(t, srv, &xproxy.Auth{User: "ci", Password: "wrong"}, nil)
+	if _, err := bad.Get(origin.URL + "/x"); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+
+	client := socksClient(t, srv, &xproxy.Auth{User: "ci", Password: "secret"}, nil)
+	resp, err := client.Get(origin.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "plain" {
+		t.Fatalf("body = %q", body)
+	}
+
+	list := store.List(0)
+	if len(list) != 1 {
+		t.Fatalf("expected one capture, got %d", len(list))
+	}
+	c := list[0]
+	if c.Request.URL != origin.URL+"/hello" || !c.HasTag("ci") {
+		t.Fatalf("capture url=%s tags=%v", c.Request.URL, c.Tags)
+	}
+}
+
+func TestSocks5MITM(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure")
+	}))
+	defer origin.Close()
+	srv, store, ca := startSocks(t, nil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	client := socksClient(t, srv, nil, &tls.Config{RootCAs: pool})
+	resp, err := client.Get(strings.Replace(origin.URL, "127.0.0.1", "localhost", 1) + "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "secure" {
+		t.Fatalf("body = %q", body)
+	}
+	list := store.List(0)
+	if len(list) != 1 || list[0].Request.URL != "https://localhost/secret" {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func TestSocks4a(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "four")
+	}))
+	defer origin.Close()
+	srv, store, _ := startSocks(t, nil)
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p, _ := strconv.Atoi(port)
+	req := []byte{4, 1, byte(p >> 8), byte(p), 0, 0, 0, 1}
+	req = append(req, "legacy\x00localhost\x00"...)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x5a {
+		t.Fatalf("reply = %v, err = %v", reply, err)
+	}
+	_, _ = io.WriteString(conn, "GET /v4 HTTP/1.1\r\nHost: localhost:"+port+"\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "four" {
+		t.Fatalf("body = %q", body)
+	}
+	list := store.List(0)
+	if len(list) != 1 || !list[0].HasTag("legacy") {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func TestProxyAuthorizationTagsCaptures(t *testing.T) {
+	var sawAuth string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawAuth = r.Header.Get("Proxy-Authorization")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+
+	store := capture.NewStore(10, "")
+	h := &Handler{
+		Transport: &http.Transport{},
+		Store:     store,
+		Log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Tags:      []string{"always"},
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	proxyURL.User = url.User("make_test")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(origin.URL + "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	list := store.List(0)
+	if len(list) != 1 || !list[0].HasTag("make_test") || !list[0].HasTag("always") {
+		t.Fatalf("captures = %d, tags = %v", len(list), list[0].Tags)
+	}
+	if sawAuth != "" || list[0].Request.Headers.Get("Proxy-Authorization") != "" {
+		t.Fatal("Proxy-Authorization forwarded to the origin")
+	}
+}
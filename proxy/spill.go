@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/intercept"
+)
+
+// streamsRequest reports whether the request body can be relayed upstream as
+// it arrives. Bodies that intercept, hooks or shadows need in full, and small
+// bodies of known length, are still buffered.
+func (h *Handler) streamsRequest(req *http.Request, ignored bool) bool {
+	if h.SpillThreshold <= 0 || req.Body == nil || req.Body == http.NoBody || len(h.Shadows) > 0 {
+		return false
+	}
+	if !ignored && (h.Hooks != nil || (h.Intercept != nil && intercept.MatchesPattern(req, h.InterceptMatch))) {
+		return false
+	}
+	return req.ContentLength < 0 || req.ContentLength > h.SpillThreshold
+}
+
+// streamsResponse reports whether the response body can be relayed to the
+// client as it arrives rather than buffered for rewrites, hooks or gRPC decoding.
+func (h *Handler) streamsResponse(req *http.Request, resp *http.Response, ignored bool) bool {
+	if h.SpillThreshold <= 0 || len(h.BodyRewrites) > 0 || (!ignored && h.Hooks != nil) {
+		return false
+	}
+	if isGRPC(req.Header) || isGRPC(resp.Header) {
+		return false
+	}
+	return resp.ContentLength < 0 || resp.ContentLength > h.SpillThreshold
+}
+
+// tapBody wraps body so everything read from it is also collected by a Spill.
+// Ignored traffic is never recorded, so its body is relayed untapped and the
+// returned Spill is nil.
+func (h *Handler) tapBody(body io.ReadCloser, ignored bool) (io.ReadCloser, *capture.Spill) {
+	if ignored {
+		return body, nil
+	}
+	sp := h.Store.NewSpill(h.SpillThreshold, h.MaxBodySize)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, sp), body}, sp
+}
+
+// finishSpill returns the captured body for a tapped stream: inline bytes
+// when it stayed under SpillThreshold, otherwise a blob reference. A nil
+// Spill means the body was buffered and is returned unchanged.
+func (h *Handler) finishSpill(sp *capture.Spill, body []byte, encoding string) ([]byte, *capture.BlobRef) {
+	if sp == nil {
+		return body, nil
+	}
+	data, ref, err := sp.Finish(encoding)
+	if err != nil {
+		h.Log.Warn("spill body", "err", err)
+	}
+	return data, ref
+}
+
+// relayResponse writes resp to the client, copying the body as it arrives.
+func (h *Handler) relayResponse(rw http.ResponseWriter, resp *http.Response) {
+	if h.Delay > 0 {
+		time.Sleep(h.Delay)
+	}
+	for k, v := range resp.Header {
+		for _, vv := range v {
+			rw.Header().Add(k, vv)
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(rw, resp.Body)
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+func TestServeHTTPSpillsLargeBodies(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(got, payload) {
+			t.Errorf("origin got %d request bytes, want %d", len(got), len(payload))
+		}
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(payload)
+	}))
+	defer origin.Close()
+
+	store := capture.NewStore(10, t.TempDir())
+	h := &Handler{
+		Transport:      &http.Transport{},
+		Store:          store,
+		Log:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		SpillThreshold: 4096,
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Post(origin.URL+"/upload", "application/octet-stream", io.NopCloser(bytes.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("client got %d bytes, want %d", len(body), len(payload))
+	}
+
+	list := store.List(1)
+	if len(list) != 1 {
+		t.Fatal("expected one capture")
+	}
+	c := list[0]
+	if c.Request.BodyBlob == nil || c.Response == nil || c.Response.BodyBlob == nil {
+		t.Fatalf("expected both bodies spilled, got req=%v resp=%v", c.Request.BodyBlob, c.Response)
+	}
+	if c.Response.BodyBlob.Size != int64(len(payload)) || len(c.Response.Body) != 0 {
+		t.Fatalf("unexpected blob ref %+v", c.Response.BodyBlob)
+	}
+	full := store.WithBodies(c)
+	if !bytes.Equal(full.Request.Body, payload) || !bytes.Equal(full.Response.Body, payload) {
+		t.Fatal("blob bodies did not round-trip")
+	}
+	if c.Request.BodyBlob.Hash != c.Response.BodyBlob.Hash {
+		t.Fatal("identical bodies should share a blob")
+	}
+}
+
+func TestServeHTTPIgnoredDoesNotSpill(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(got, payload) {
+			t.Errorf("origin got %d request bytes, want %d", len(got), len(payload))
+		}
+		_, _ = w.Write(payload)
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	h := &Handler{
+		Transport:      &http.Transport{},
+		Store:          capture.NewStore(10, dir),
+		Log:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		SpillThreshold: 4096,
+		IgnorePatterns: []string{"/upload"},
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Post(origin.URL+"/upload", "application/octet-stream", io.NopCloser(bytes.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("client got %d bytes, want %d", len(body), len(payload))
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, capture.BlobDir)); len(entries) > 0 {
+		t.Fatalf("ignored traffic spilled %d blob entries", len(entries))
+	}
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/muxover/snare/v2/policy"
+)
+
+// streamSniffTimeout bounds the wait for a client's first bytes. Clients
+// of server-speaks-first protocols send nothing, and are tunneled.
+const streamSniffTimeout = 500 * time.Millisecond
+
+// serveStream handles a client stream bound for target: TLS goes through
+// the MITM path, plaintext HTTP through the HTTP proxy path, and anything
+// else is tunneled. br reads from conn and may already hold bytes from it.
+// upstream may already be connected to target; when it is nil, target is
+// dialed only once the policy has allowed the stream.
+func (h *Handler) serveStream(conn net.Conn, br *bufio.Reader, target string, upstream net.Conn, setup *phaseTrace) {
+	_ = conn.SetReadDeadline(time.Now().Add(streamSniffTimeout))
+	var first []byte
+	if _, err := br.Peek(1); err == nil {
+		first, _ = br.Peek(br.Buffered())
+	} else {
+		// Clear the stored timeout so later reads go back to conn.
+		_, _ = br.Read(nil)
+	}
+	hostname, _, _ := net.SplitHostPort(target)
+	isTLS := len(first) > 0 && first[0] == 0x16
+	if isTLS {
+		if sni := peekSNI(br); sni != "" {
+			hostname = sni
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	client := &bufferedConn{Conn: conn, r: br}
+
+	action := h.Policy.Decide(hostname)
+	if action == policy.Block {
+		h.blocked(hostname, target)
+		return
+	}
+	defer func() {
+		if upstream != nil {
+			upstream.Close()
+		}
+	}()
+	dial := func() bool {
+		if upstream != nil {
+			return true
+		}
+		var err error
+		if upstream, setup, err = dialOrigin(target); err != nil {
+			h.Log.Error("stream dial", "target", target, "err", err)
+			return false
+		}
+		return true
+	}
+	switch {
+	case action == policy.Passthrough || h.isIgnored(target) || len(first) == 0:
+		if dial() {
+			tunnel(client, upstream)
+		}
+	case isTLS && h.MitmEnable && h.HostCerts != nil:
+		if !dial() {
+			return
+		}
+		tlsClientConn, err := h.clientTLS(client, hostname)
+		if err != nil {
+			h.clientHandshakeFailed(hostname, err)
+			return
+		}
+		if sni := tlsClientConn.ConnectionState().ServerName; sni != "" {
+			hostname = sni
+		}
+		originConn, err := h.handshakeOrigin(upstream, hostname, setup)
+		if err != nil {
+			h.Log.Error("mitm dial origin", "host", target, "err", err)
+			msg := h.originFailure(target, hostname, setup, err)
+			if tlsClientConn.ConnectionState().NegotiatedProtocol != "h2" {
+				_, _ = fmt.Fprintf(tlsClientConn, "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(msg), msg)
+			}
+			return
+		}
+		defer originConn.Close()
+		h.mitmServe(tlsClientConn, originConn, hostname, setup)
+	case looksLikeHTTP(first):
+		if upstream != nil {
+			upstream.Close()
+			upstream = nil
+		}
+		h.serveStreamHTTP(client, target)
+	default:
+		if dial() {
+			tunnel(client, upstream)
+		}
+	}
+}
+
+// serveStreamHTTP runs HTTP/1.1 on a client stream. Requests arrive in
+// origin form, so they are made absolute against target before being
+// proxied like any other.
+func (h *Handler) serveStreamHTTP(conn net.Conn, target string) {
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Host
+			if req.URL.Host == "" {
+				req.URL.Host = target
+			}
+			h.ServeHTTP(rw, req)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	_ = srv.Serve(newConnListener(conn))
+}
+
+// peekSNI returns the server name from the TLS ClientHello buffered in
+// br, or "" when there is none or the record does not fit in the buffer.
+func peekSNI(br *bufio.Reader) string {
+	header, err := br.Peek(5)
+	if err != nil {
+		return ""
+	}
+	record, err := br.Peek(5 + int(binary.BigEndian.Uint16(header[3:5])))
+	if err != nil {
+		return ""
+	}
+	var name string
+	// Run a server handshake over a copy of the record just far enough to
+	// have crypto/tls parse the ClientHello.
+	_ = tls.Server(&helloConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return name
+}
+
+var errHelloRead = errors.New("client hello read")
+
+// helloConn is a read-only net.Conn over bytes already received.
+type helloConn struct {
+	r io.Reader
+}
+
+func (c *helloConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *helloConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *helloConn) Close() error                       { return nil }
+func (c *helloConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *helloConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c *helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *helloConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("PATCH "), []byte("OPTIONS "), []byte("TRACE "), []byte("CONNECT "),
+}
+
+// looksLikeHTTP reports whether b opens an HTTP/1 request line.
+func looksLikeHTTP(b []byte) bool {
+	for _, m := range httpMethods {
+		n := min(len(b), len(m))
+		if n > 0 && bytes.Equal(b[:n], m[:n]) && (n == len(m) || n == len(b)) {
+			return true
+		}
+	}
+	return false
+}
+
+func tunnel(client, upstream net.Conn) {
+	go func() {
+		_, _ = io.Copy(upstream, client)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+	}()
+	_, _ = io.Copy(client, upstream)
+}
+
+// bufferedConn reads through r, which holds bytes already peeked from Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener hands out a single connection, then blocks until that
+// connection is closed so http.Server.Serve returns when it is done.
+type connListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{done: make(chan struct{})}
+	l.conn = &closeNotifyConn{Conn: conn, done: l.done}
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+type closeNotifyConn struct {
+	net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
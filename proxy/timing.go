@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+// phaseTrace records when each phase of one upstream exchange starts and
+// ends. Transport requests fill it through httptrace; the raw MITM HTTP/1
+// path sets the fields itself.
+type phaseTrace struct {
+	mu        sync.Mutex
+	start     time.Time
+	dnsStart  time.Time
+	dnsDone   time.Time
+	connStart time.Time
+	connDone  time.Time
+	tlsStart  time.Time
+	tlsDone   time.Time
+	gotConn   time.Time
+	wrote     time.Time
+	firstByte time.Time
+	reused    bool
+	remote    string
+	local     string
+	client    string
+	listener  string
+}
+
+// traceRequest returns outReq with a ClientTrace attached that records into
+// the returned phaseTrace, and with its origin host for choosing a client
+// certificate. req is the client's request, for its connection addresses.
+func traceRequest(req, outReq *http.Request) (*http.Request, *phaseTrace) {
+	t := &phaseTrace{start: time.Now(), client: req.RemoteAddr}
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		t.listener = addr.String()
+	}
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart, true) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone, false) },
+		// With several addresses the dialer races connections, so the
+		// connect phase runs from the first attempt to the last success.
+		ConnectStart: func(string, string) { t.mark(&t.connStart, true) },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.mark(&t.connDone, false)
+			}
+		},
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart, true) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.mark(&t.tlsDone, false) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+			if info.Conn != nil {
+				t.remote = info.Conn.RemoteAddr().String()
+				t.local = info.Conn.LocalAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wrote, false) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte, true) },
+	}
+	ctx := context.WithValue(outReq.Context(), originHostKey{}, outReq.URL.Hostname())
+	return outReq.WithContext(httptrace.WithClientTrace(ctx, ct)), t
+}
+
+// mark sets *at to now, keeping an earlier value when first is set.
+func (t *phaseTrace) mark(at *time.Time, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if first && !at.IsZero() {
+		return
+	}
+	*at = time.Now()
+}
+
+// timings converts the trace into phase durations, with the response body
+// fully read at end.
+func (t *phaseTrace) timings(end time.Time) *capture.Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := &capture.Timings{
+		DNS:        span(t.dnsStart, t.dnsDone),
+		Connect:    span(t.connStart, t.connDone),
+		TLS:        span(t.tlsStart, t.tlsDone),
+		Send:       span(t.gotConn, t.wrote),
+		Wait:       span(t.wrote, t.firstByte),
+		Receive:    span(t.firstByte, end),
+		Reused:     t.reused,
+		RemoteAddr: t.remote,
+	}
+	if !t.gotConn.IsZero() {
+		out.Blocked = max(span(t.start, t.gotConn)-out.DNS-out.Connect-out.TLS, 0)
+	}
+	return out
+}
+
+// conn returns the connection addresses the trace saw.
+func (t *phaseTrace) conn() *capture.ConnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == "" && t.remote == "" {
+		return nil
+	}
+	return &capture.ConnInfo{Client: t.client, Proxy: t.listener, OriginLocal: t.local, Origin: t.remote}
+}
+
+func span(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
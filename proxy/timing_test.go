@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+func TestServeHTTPRecordsTimings(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+
+	store := capture.NewStore(10, "")
+	h := &Handler{
+		Transport: &http.Transport{},
+		Store:     store,
+		Log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(origin.URL + "/slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	list := store.List(2)
+	if len(list) != 2 {
+		t.Fatalf("expected two captures, got %d", len(list))
+	}
+	second, first := list[0].Timings, list[1].Timings
+	if first == nil || second == nil {
+		t.Fatal("expected timings on both captures")
+	}
+	if first.Reused || first.Connect == 0 || first.RemoteAddr == "" {
+		t.Fatalf("first request: %+v", first)
+	}
+	if !second.Reused || second.Connect != 0 {
+		t.Fatalf("second request: %+v", second)
+	}
+	if first.Wait < 20*time.Millisecond {
+		t.Fatalf("wait %s should cover the origin's delay", first.Wait)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+)
+
+// TransparentServer accepts connections redirected to it by iptables or
+// nftables (REDIRECT or TPROXY), recovers where each was headed, and hands
+// it to the Handler as if the client had asked for that destination.
+type TransparentServer struct {
+	Listener net.Listener
+	Handler  *Handler
+	Log      *slog.Logger
+
+	// origDst recovers a connection's original destination; tests replace it.
+	origDst func(net.Conn) (string, error)
+}
+
+func NewTransparentServer(addr string, h *Handler, log *slog.Logger) (*TransparentServer, error) {
+	if log == nil {
+		log = slog.Default()
+	}
+	ln, err := listenTransparent(addr, log)
+	if err != nil {
+		return nil, err
+	}
+	return &TransparentServer{Listener: ln, Handler: h, Log: log, origDst: originalDst}, nil
+}
+
+func (s *TransparentServer) Start() {
+	go func() {
+		for {
+			conn, err := s.Listener.Accept()
+			if err != nil {
+				return
+			}
+			s.Log.Debug("connection", "remote", conn.RemoteAddr().String())
+			go s.serveConn(conn)
+		}
+	}()
+}
+
+func (s *TransparentServer) Close() error {
+	return s.Listener.Close()
+}
+
+func (s *TransparentServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	target, err := s.origDst(conn)
+	if err != nil {
+		s.Log.Warn("transparent: no original destination", "remote", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	// Without a REDIRECT or TPROXY rule the destination is the proxy itself,
+	// and dialing it would loop.
+	_, dstPort, _ := net.SplitHostPort(target)
+	_, ownPort, _ := net.SplitHostPort(s.Listener.Addr().String())
+	if target == conn.LocalAddr().String() && dstPort == ownPort {
+		s.Log.Warn("transparent: connection was not redirected; use HTTP_PROXY with --mode forward instead", "remote", conn.RemoteAddr().String())
+		return
+	}
+	s.Handler.Log.Info("transparent", "target", target, "remote", conn.RemoteAddr().String())
+	s.Handler.serveStream(conn, bufio.NewReader(conn), target, nil, nil)
+}
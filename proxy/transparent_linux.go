@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	soOriginalDst     = 80 // SO_ORIGINAL_DST in linux/netfilter_ipv4.h
+	ip6tSoOriginalDst = 80 // IP6T_SO_ORIGINAL_DST in linux/netfilter_ipv6/ip6_tables.h
+)
+
+// listenTransparent listens on addr with IP_TRANSPARENT set when the
+// process is allowed to, so TPROXY rules can deliver to it. REDIRECT rules
+// need no special socket option.
+func listenTransparent(addr string, log *slog.Logger) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+			})
+			if err == nil && sockErr != nil {
+				log.Debug("IP_TRANSPARENT unavailable; TPROXY rules will not reach the proxy, REDIRECT still works", "err", sockErr)
+			}
+			return err
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst returns the address a redirected connection was headed for.
+// REDIRECT (NAT) connections report it through SO_ORIGINAL_DST; TPROXY
+// connections keep it as their local address.
+func originalDst(conn net.Conn) (string, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn.LocalAddr().String(), nil
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var dst string
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+			var info *syscall.IPv6MTUInfo
+			info, sockErr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSoOriginalDst)
+			if sockErr == nil {
+				// Port holds network byte order whatever the host's.
+				port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&info.Addr.Port))[:])
+				dst = net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(port)))
+			}
+			return
+		}
+		var mreq *syscall.IPv6Mreq
+		mreq, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if sockErr == nil {
+			// The option fills a sockaddr_in: family, port, then address.
+			b := mreq.Multiaddr
+			port := binary.BigEndian.Uint16(b[2:4])
+			dst = net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(int(port)))
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		// Not NATed: a TPROXY connection, or one that was not redirected.
+		return conn.LocalAddr().String(), nil
+	}
+	return dst, nil
+}
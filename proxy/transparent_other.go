@@ -0,0 +1,19 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("transparent mode is only supported on Linux")
+
+func listenTransparent(string, *slog.Logger) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(net.Conn) (string, error) {
+	return "", errTransparentUnsupported
+}
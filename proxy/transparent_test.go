@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/proxy/cert"
+)
+
+// redirectedTo returns a transparent server whose connections all appear
+// to have been redirected from dst, standing in for an iptables rule.
+func redirectedTo(t *testing.T, dst string, upstream *UpstreamTLS) (*TransparentServer, *capture.Store, *x509.Certificate) {
+	t.Helper()
+	caCert, caKey, err := cert.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := capture.NewStore(10, "")
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &TransparentServer{
+		Listener: ln,
+		Handler: &Handler{
+			Transport:   &http.Transport{},
+			Store:       store,
+			Log:         log,
+			MitmEnable:  true,
+			HostCerts:   cert.NewHostCertCache(caCert, caKey),
+			UpstreamTLS: upstream,
+		},
+		Log:     log,
+		origDst: func(net.Conn) (string, error) { return dst, nil },
+	}
+	srv.Start()
+	t.Cleanup(func() { srv.Close() })
+	return srv, store, caCert
+}
+
+func TestTransparentTLSUsesSNI(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hidden")
+	}))
+	defer origin.Close()
+	srv, store, ca := redirectedTo(t, origin.Listener.Addr().String(), &UpstreamTLS{Insecure: []string{"*"}})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	// The client thinks it is talking to api.example.test; only the
+	// redirect knows the real address.
+	client := &http.Client{Transport: &http.Transport{
+		DialContext:     (&net.Dialer{}).DialContext,
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "api.example.test"},
+	}}
+	resp, err := client.Get("https://" + srv.Listener.Addr().String() + "/v1/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hidden" {
+		t.Fatalf("body = %q", body)
+	}
+	list := store.List(0)
+	if len(list) != 1 || list[0].Request.URL != "https://api.example.test/v1/items" {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func TestTransparentPlainHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "plain")
+	}))
+	defer origin.Close()
+	srv, store, _ := redirectedTo(t, origin.Listener.Addr().String(), &UpstreamTLS{Insecure: []string{"*"}})
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Listener.Addr().String()+"/p", nil)
+	req.Host = origin.Listener.Addr().String()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "plain" {
+		t.Fatalf("body = %q", body)
+	}
+	list := store.List(0)
+	if len(list) != 1 || list[0].Request.URL != origin.URL+"/p" {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func TestTransparentRejectsDirectConnections(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("transparent mode is Linux only")
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := NewTransparentServer("127.0.0.1:0", &Handler{Log: log}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the proxy to hang up, got %v", err)
+	}
+}
@@ -1,16 +1,21 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"time"
 
 	"golang.org/x/net/http2"
 )
 
-func ProxyTransport(skipVerify bool, upstreamProxy string) (*http.Transport, error) {
+// ProxyTransport returns the transport for upstream requests, verifying
+// origin certificates as upstream says.
+func ProxyTransport(upstream *UpstreamTLS, upstreamProxy string) (*http.Transport, error) {
 	proxyFunc := http.ProxyFromEnvironment
 	if upstreamProxy != "" {
 		u, err := url.Parse(upstreamProxy)
@@ -31,10 +36,43 @@ func ProxyTransport(skipVerify bool, upstreamProxy string) (*http.Transport, err
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-	}
-	if skipVerify {
-		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		TLSClientConfig:       upstream.Config(""),
 	}
 	_ = http2.ConfigureTransport(t)
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialTLS(ctx, t, upstream, network, addr)
+	}
 	return t, nil
 }
+
+// dialTLS connects to an origin for t with a TLS config for that origin's
+// host, so IP origins, which send no SNI, are still verified against the
+// address they were dialed at. Requests through an upstream proxy do not
+// come here and use t.TLSClientConfig.
+func dialTLS(ctx context.Context, t *http.Transport, upstream *UpstreamTLS, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	hctx, cancel := context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+	defer cancel()
+	conn := tls.Client(raw, upstream.Config(host, t.TLSClientConfig.NextProtos...))
+	err = conn.HandshakeContext(hctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(conn.ConnectionState(), err)
+	}
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/policy"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// UpstreamTLS is how snare verifies the certificates of the origins it
+// connects to. A nil *UpstreamTLS verifies every origin against the
+// system roots.
+type UpstreamTLS struct {
+	// RootCAs replaces the system roots when set.
+	RootCAs *x509.CertPool
+	// Insecure lists hosts whose certificates are not verified: an exact
+	// host name, "*.example.com" for its subdomains, or "*" for all.
+	Insecure []string
+	// ClientCerts are presented to origins that ask for one; the first
+	// whose Pattern matches the host is used.
+	ClientCerts []ClientCert
+	// KeyLog receives NSS key log lines for origin connections.
+	KeyLog io.Writer
+}
+
+// ClientCert is a client certificate for origins whose host matches
+// Pattern.
+type ClientCert struct {
+	Pattern string
+	// Identity names the certificate on captures: the leaf's subject.
+	Identity string
+	Cert     tls.Certificate
+}
+
+// LoadClientCert reads a client certificate from a PEM certificate and
+// key file, or from a PKCS#12 bundle when certFile ends in .p12 or .pfx,
+// in which case keyOrPassword is the bundle's password. An empty PEM key
+// file means the key is in certFile.
+func LoadClientCert(pattern, certFile, keyOrPassword string) (ClientCert, error) {
+	var cert tls.Certificate
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(certFile)); {
+	case ext == ".p12" || ext == ".pfx":
+		cert, err = loadPKCS12(certFile, keyOrPassword)
+	case keyOrPassword == "":
+		cert, err = tls.LoadX509KeyPair(certFile, certFile)
+	default:
+		cert, err = tls.LoadX509KeyPair(certFile, keyOrPassword)
+	}
+	if err != nil {
+		return ClientCert{}, fmt.Errorf("%s: %w", certFile, err)
+	}
+	return ClientCert{Pattern: pattern, Identity: cert.Leaf.Subject.String(), Cert: cert}, nil
+}
+
+func loadPKCS12(path, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	var unsupported pkcs12.NotImplementedError
+	if errors.As(err, &unsupported) {
+		return tls.Certificate{}, fmt.Errorf("%w; re-export it with openssl pkcs12 -export -legacy, or use PEM files", err)
+	}
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	// tls.X509KeyPair wants the leaf first: the certificate that shares
+	// the key's local key ID.
+	var keyPEM, leafPEM, chainPEM []byte
+	var keyID string
+	for _, b := range blocks {
+		if b.Type == "PRIVATE KEY" {
+			keyPEM = pem.EncodeToMemory(b)
+			keyID = b.Headers["localKeyId"]
+		}
+	}
+	for _, b := range blocks {
+		switch {
+		case b.Type != "CERTIFICATE":
+		case leafPEM == nil && b.Headers["localKeyId"] == keyID:
+			leafPEM = pem.EncodeToMemory(b)
+		default:
+			chainPEM = append(chainPEM, pem.EncodeToMemory(b)...)
+		}
+	}
+	return tls.X509KeyPair(append(leafPEM, chainPEM...), keyPEM)
+}
+
+// clientCert returns the client certificate for host, or nil.
+func (u *UpstreamTLS) clientCert(host string) *ClientCert {
+	if u == nil {
+		return nil
+	}
+	for i := range u.ClientCerts {
+		if policy.Match(u.ClientCerts[i].Pattern, host) {
+			return &u.ClientCerts[i]
+		}
+	}
+	return nil
+}
+
+// LoadRootCAs reads a PEM bundle of trusted root certificates.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// Skips reports whether verification is turned off for host.
+func (u *UpstreamTLS) Skips(host string) bool {
+	if u == nil {
+		return false
+	}
+	for _, p := range u.Insecure {
+		if policy.Match(p, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns a client TLS config for origins. Go's own verification
+// is replaced by one that honours Insecure per host and reports failures
+// as *tls.CertificateVerificationError, so the rejected chain can be
+// recorded. serverName is the origin's host, a name or an IP address the
+// certificate must cover. With an empty serverName, as in a shared
+// http.Transport config, the name is taken from the connection; IP origins
+// reached that way send no SNI and fail verification unless Insecure covers
+// them. Client certificates are chosen by serverName, or by the host of the
+// request that dialed.
+func (u *UpstreamTLS) Config(serverName string, nextProtos ...string) *tls.Config {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         nextProtos,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := serverName
+			if name == "" {
+				name = cs.ServerName
+			}
+			return u.verify(cs, name)
+		},
+	}
+	if u != nil {
+		cfg.KeyLogWriter = u.KeyLog
+	}
+	if u != nil && len(u.ClientCerts) > 0 {
+		cfg.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			name := serverName
+			if name == "" {
+				name, _ = cri.Context().Value(originHostKey{}).(string)
+			}
+			if cc := u.clientCert(name); cc != nil {
+				return &cc.Cert, nil
+			}
+			return &tls.Certificate{}, nil
+		}
+	}
+	return cfg
+}
+
+// originHostKey carries the origin host of a Transport request into the
+// handshake of the connection dialed for it.
+type originHostKey struct{}
+
+func (u *UpstreamTLS) verify(cs tls.ConnectionState, name string) error {
+	if u.Skips(name) || len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	if name == "" {
+		return &tls.CertificateVerificationError{UnverifiedCertificates: cs.PeerCertificates, Err: errors.New("no server name to verify the certificate against")}
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	if u != nil {
+		opts.Roots = u.RootCAs
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return &tls.CertificateVerificationError{UnverifiedCertificates: cs.PeerCertificates, Err: err}
+	}
+	return nil
+}
+
+// originTLS describes an established connection to host for a capture.
+func (h *Handler) originTLS(cs *tls.ConnectionState, host string) *capture.TLSInfo {
+	if cs == nil {
+		return nil
+	}
+	var identity string
+	if cc := h.UpstreamTLS.clientCert(host); cc != nil {
+		identity = cc.Identity
+	}
+	return &capture.TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		SNI:         cs.ServerName,
+		Verified:    !h.UpstreamTLS.Skips(host),
+		Chain:       certChain(cs.PeerCertificates),
+		ClientCert:  identity,
+	}
+}
+
+// verifyFailure describes the chain an origin presented when err is a
+// certificate verification failure, and returns nil for any other error.
+func verifyFailure(err error, serverName string) *capture.TLSInfo {
+	var ve *tls.CertificateVerificationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	return &capture.TLSInfo{
+		SNI:         serverName,
+		VerifyError: ve.Err.Error(),
+		Chain:       certChain(ve.UnverifiedCertificates),
+	}
+}
+
+func certChain(certs []*x509.Certificate) []capture.CertInfo {
+	out := make([]capture.CertInfo, 0, len(certs))
+	for _, c := range certs {
+		sum := sha256.Sum256(c.Raw)
+		out = append(out, capture.CertInfo{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			DNSNames:  c.DNSNames,
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+			SHA256:    hex.EncodeToString(sum[:]),
+		})
+	}
+	return out
+}
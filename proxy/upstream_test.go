@@ -0,0 +1,301 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/proxy/cert"
+)
+
+func TestUpstreamVerification(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+	originRoots := x509.NewCertPool()
+	originRoots.AddCert(origin.Certificate())
+
+	get := func(srv *TransparentServer, ca *x509.Certificate) string {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+		// httptest's certificate is issued for example.com.
+		client := &http.Client{Transport: &http.Transport{
+			DialContext:     (&net.Dialer{}).DialContext,
+			TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"},
+		}}
+		resp, err := client.Get("https://" + srv.Listener.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		srv, store, ca := redirectedTo(t, origin.Listener.Addr().String(), nil)
+		if body := get(srv, ca); !strings.Contains(body, "failed verification") {
+			t.Fatalf("body = %q", body)
+		}
+		list := store.List(0)
+		if len(list) != 1 || list[0].Error == "" || list[0].TLS == nil {
+			t.Fatalf("captures = %+v", list)
+		}
+		info := list[0].TLS
+		if info.Verified || info.VerifyError == "" || len(info.Chain) == 0 || info.SNI != "example.com" {
+			t.Fatalf("tls = %+v", info)
+		}
+	})
+
+	t.Run("custom roots", func(t *testing.T) {
+		srv, store, ca := redirectedTo(t, origin.Listener.Addr().String(), &UpstreamTLS{RootCAs: originRoots})
+		if body := get(srv, ca); body != "ok" {
+			t.Fatalf("body = %q", body)
+		}
+		list := store.List(0)
+		if len(list) != 1 || list[0].TLS == nil {
+			t.Fatalf("captures = %+v", list)
+		}
+		info := list[0].TLS
+		if !info.Verified || info.Version == "" || info.CipherSuite == "" || info.SNI != "example.com" || len(info.Chain) != 1 {
+			t.Fatalf("tls = %+v", info)
+		}
+	})
+
+	t.Run("opt out", func(t *testing.T) {
+		srv, store, ca := redirectedTo(t, origin.Listener.Addr().String(), &UpstreamTLS{Insecure: []string{"*.com"}})
+		if body := get(srv, ca); body != "ok" {
+			t.Fatalf("body = %q", body)
+		}
+		if list := store.List(0); len(list) != 1 || list[0].TLS == nil || list[0].TLS.Verified {
+			t.Fatalf("captures = %+v", list)
+		}
+	})
+}
+
+func TestUpstreamVerifiesIPOrigins(t *testing.T) {
+	get := func(origin *httptest.Server) error {
+		roots := x509.NewCertPool()
+		roots.AddCert(origin.Certificate())
+		tr, err := ProxyTransport(&UpstreamTLS{RootCAs: roots}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Proxy = nil
+		resp, err := (&http.Client{Transport: tr}).Get(origin.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// httptest's certificate covers 127.0.0.1.
+	withIP := httptest.NewTLSServer(handler)
+	defer withIP.Close()
+	if err := get(withIP); err != nil {
+		t.Fatalf("certificate for the IP rejected: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		DNSNames:              []string{"example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutIP := httptest.NewUnstartedServer(handler)
+	withoutIP.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	withoutIP.StartTLS()
+	defer withoutIP.Close()
+	if err := get(withoutIP); err == nil || !strings.Contains(err.Error(), "127.0.0.1") {
+		t.Fatalf("certificate without the IP accepted: %v", err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	origin.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	origin.StartTLS()
+	defer origin.Close()
+	originRoots := x509.NewCertPool()
+	originRoots.AddCert(origin.Certificate())
+
+	certFile, keyFile := writeClientCert(t, "svc-snare")
+	cc, err := LoadClientCert("127.0.0.1", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &UpstreamTLS{RootCAs: originRoots, ClientCerts: []ClientCert{cc}}
+	transport, err := ProxyTransport(upstream, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := capture.NewStore(10, "")
+	target, _ := url.Parse(origin.URL)
+	h := &Handler{
+		Transport:     transport,
+		Store:         store,
+		Log:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Mode:          "reverse",
+		ReverseTarget: target,
+		UpstreamTLS:   upstream,
+	}
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "svc-snare" {
+		t.Fatalf("body = %q", body)
+	}
+	list := store.List(0)
+	if len(list) != 1 || list[0].TLS == nil || list[0].TLS.ClientCert != "CN=svc-snare" {
+		t.Fatalf("captures = %+v", list)
+	}
+}
+
+func writeClientCert(t *testing.T, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestKeyLogAndConnInfo(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer origin.Close()
+
+	caCert, caKey, err := cert.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyLog := &syncBuffer{}
+	store := capture.NewStore(10, "")
+	h := &Handler{
+		Transport:   &http.Transport{},
+		Store:       store,
+		Log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MitmEnable:  true,
+		HostCerts:   cert.NewHostCertCache(caCert, caKey),
+		UpstreamTLS: &UpstreamTLS{Insecure: []string{"*"}, KeyLog: keyLog},
+		KeyLog:      keyLog,
+	}
+	proxySrv := httptest.NewServer(h)
+	defer proxySrv.Close()
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	resp, err := client.Get(origin.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// One client random per leg.
+	randoms := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(keyLog.String()), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] == "CLIENT_TRAFFIC_SECRET_0" {
+			randoms[f[1]] = true
+		}
+	}
+	if len(randoms) != 2 {
+		t.Fatalf("key log covers %d connections:\n%s", len(randoms), keyLog.String())
+	}
+
+	list := store.List(0)
+	if len(list) != 1 || list[0].Conn == nil {
+		t.Fatalf("captures = %+v", list)
+	}
+	conn := list[0].Conn
+	if conn.Client == "" || conn.Proxy != proxySrv.Listener.Addr().String() || conn.Origin != origin.Listener.Addr().String() || conn.OriginLocal == "" {
+		t.Fatalf("conn = %+v", conn)
+	}
+}
@@ -0,0 +1,218 @@
+package query
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+	"github.com/muxover/snare/v2/session"
+)
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindDuration
+	kindTime
+)
+
+type field struct {
+	name    string
+	kind    fieldKind
+	fold    bool
+	session bool
+	values  func(e *env) []string
+}
+
+func one(s string) []string { return []string{s} }
+
+func some(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+func present(b bool) []string {
+	if b {
+		return one("true")
+	}
+	return nil
+}
+
+var fields = map[string]*field{
+	"id":     {kind: kindString, values: func(e *env) []string { return one(e.c.ID) }},
+	"method": {kind: kindString, fold: true, values: func(e *env) []string { return one(e.c.Request.Method) }},
+	"url":    {kind: kindString, values: func(e *env) []string { return one(e.c.Request.URL) }},
+	"host":   {kind: kindString, fold: true, values: func(e *env) []string { return one(hostOf(e.c)) }},
+	"path":   {kind: kindString, values: func(e *env) []string { return one(pathOf(e.c)) }},
+	"proto":  {kind: kindString, fold: true, values: func(e *env) []string { return some(e.c.Protocol) }},
+	"error":  {kind: kindString, values: func(e *env) []string { return some(e.c.Error) }},
+	"status": {kind: kindNumber, values: func(e *env) []string {
+		if e.c.Response == nil {
+			return nil
+		}
+		return one(strconv.Itoa(e.c.Response.StatusCode))
+	}},
+	"duration": {kind: kindDuration, values: func(e *env) []string {
+		return one(strconv.FormatFloat(float64(e.c.Duration)/float64(time.Millisecond), 'f', -1, 64))
+	}},
+	"time": {kind: kindTime, values: func(e *env) []string { return one(e.c.Timestamp.Format(time.RFC3339Nano)) }},
+	"operation": {kind: kindString, fold: true, values: func(e *env) []string {
+		if e.c.GraphQL == nil {
+			return nil
+		}
+		return some(e.c.GraphQL.OperationName)
+	}},
+	"req.body": {kind: kindString, values: func(e *env) []string { return some(string(e.bodies().Request.Body)) }},
+	"resp.body": {kind: kindString, values: func(e *env) []string {
+		c := e.bodies()
+		if c.Response == nil {
+			return nil
+		}
+		return some(string(c.Response.Body))
+	}},
+	"body": {kind: kindString, values: func(e *env) []string {
+		c := e.bodies()
+		out := some(string(c.Request.Body))
+		if c.Response != nil {
+			out = append(out, some(string(c.Response.Body))...)
+		}
+		return out
+	}},
+	"req.size": {kind: kindNumber, values: func(e *env) []string {
+		return one(strconv.FormatInt(bodySize(e.c.Request.Body, e.c.Request.BodyBlob), 10))
+	}},
+	"resp.size": {kind: kindNumber, values: func(e *env) []string {
+		if e.c.Response == nil {
+			return nil
+		}
+		return one(strconv.FormatInt(bodySize(e.c.Response.Body, e.c.Response.BodyBlob), 10))
+	}},
+	"websocket": {kind: kindString, values: func(e *env) []string { return present(e.c.WebSocket != nil) }},
+	"grpc":      {kind: kindString, values: func(e *env) []string { return present(e.c.GRPC != nil) }},
+	"sse":       {kind: kindString, values: func(e *env) []string { return present(e.c.SSE != nil) }},
+	"graphql":   {kind: kindString, values: func(e *env) []string { return present(e.c.GraphQL != nil) }},
+	"tag":       {kind: kindString, values: func(e *env) []string { return e.c.Tags }},
+	"note":      {kind: kindString, values: func(e *env) []string { return some(e.c.Note) }},
+	"starred":   {kind: kindString, values: func(e *env) []string { return present(e.c.Starred) }},
+	"mock":      {kind: kindString, values: func(e *env) []string { return some(e.c.Mock) }},
+	"session":   {session: true, values: func(e *env) []string { return present(sessionKeeper(e.c)) }},
+}
+
+var aliases = map[string]string{
+	"protocol":  "proto",
+	"timestamp": "time",
+	"code":      "status",
+	"latency":   "duration",
+	"op":        "operation",
+	"ws":        "websocket",
+	"tags":      "tag",
+	"star":      "starred",
+	"pinned":    "starred",
+}
+
+// Fields lists the field names a query can use, for help text.
+func Fields() []string {
+	return []string{
+		"id", "method", "url", "host", "path", "proto", "status", "duration", "time", "error",
+		"operation", "body", "req.body", "resp.body", "req.size", "resp.size",
+		"req.header.<name>", "resp.header.<name>", "req.json.<$path>", "resp.json.<$path>",
+		"websocket", "grpc", "sse", "graphql", "tag", "note", "starred", "mock", "session",
+	}
+}
+
+func lookupField(name string) (*field, error) {
+	key := strings.ToLower(name)
+	if a, ok := aliases[key]; ok {
+		key = a
+	}
+	if f, ok := fields[key]; ok {
+		g := *f
+		g.name = key
+		return &g, nil
+	}
+	for _, side := range []string{"req", "resp"} {
+		if h, ok := strings.CutPrefix(key, side+".header."); ok && h != "" {
+			return headerField(key, side, name[len(side)+len(".header."):]), nil
+		}
+		if p, ok := strings.CutPrefix(name, side+".json."); ok {
+			path, err := compilePath(p)
+			if err != nil {
+				return nil, err
+			}
+			return jsonField(key, side, path), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown field %q", name)
+}
+
+func headerField(name, side, header string) *field {
+	return &field{name: name, values: func(e *env) []string {
+		var h http.Header
+		if side == "req" {
+			h = e.c.Request.Headers
+		} else if e.c.Response != nil {
+			h = e.c.Response.Headers
+		}
+		return h.Values(header)
+	}}
+}
+
+// bodySize is the size of a body, counting one stored as a blob.
+func bodySize(body []byte, blob *capture.BlobRef) int64 {
+	if len(body) == 0 && blob != nil {
+		return blob.Size
+	}
+	return int64(len(body))
+}
+
+func jsonField(name, side string, path jsonPath) *field {
+	return &field{name: name, values: func(e *env) []string {
+		c := e.bodies()
+		doc := &e.reqJSON
+		body := c.Request.Body
+		if side == "resp" {
+			doc = &e.respJSON
+			body = nil
+			if c.Response != nil {
+				body = c.Response.Body
+			}
+		}
+		if *doc == nil {
+			*doc = parseJSONDoc(body)
+		}
+		return (*doc).lookup(path)
+	}}
+}
+
+type sessionNode struct {
+	negate bool
+	entry  session.Entry
+}
+
+func newSessionNode(op, name string) (node, error) {
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("session supports == and != only")
+	}
+	sessions, err := session.Load()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := sessions[name]
+	if !ok {
+		return nil, fmt.Errorf("no session %q", name)
+	}
+	return sessionNode{negate: op == "!=", entry: e}, nil
+}
+
+func (n sessionNode) match(e *env) bool {
+	return n.entry.Contains(e.c.Timestamp) != n.negate
+}
+
+// sessionKeeper backs the bare session field: inside any named session.
+var sessionKeeper = session.Keeper()
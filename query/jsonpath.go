@@ -0,0 +1,151 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled subset of JSONPath: $ followed by .key, [n], .*
+// and [*] steps. Negative indexes count from the end.
+type jsonPath []pathStep
+
+type pathStep struct {
+	key   string
+	index int
+	wild  bool
+	isIdx bool
+}
+
+func compilePath(src string) (jsonPath, error) {
+	s, ok := strings.CutPrefix(src, "$")
+	if !ok {
+		return nil, fmt.Errorf("JSONPath %q must start with $", src)
+	}
+	var path jsonPath
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			key := s[:end]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in JSONPath %q", src)
+			}
+			path = append(path, pathStep{key: key, wild: key == "*"})
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in JSONPath %q", src)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			if inner == "*" {
+				path = append(path, pathStep{wild: true})
+				continue
+			}
+			n, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index [%s] in JSONPath %q", inner, src)
+			}
+			path = append(path, pathStep{index: n, isIdx: true})
+		default:
+			return nil, fmt.Errorf("unexpected %q in JSONPath %q", s[0], src)
+		}
+	}
+	return path, nil
+}
+
+type jsonDoc struct {
+	v  any
+	ok bool
+}
+
+func parseJSONDoc(body []byte) *jsonDoc {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return &jsonDoc{}
+	}
+	return &jsonDoc{v: v, ok: true}
+}
+
+// lookup returns every value the path selects, rendered as text: strings
+// unquoted, other scalars and containers as compact JSON.
+func (d *jsonDoc) lookup(path jsonPath) []string {
+	if !d.ok {
+		return nil
+	}
+	cur := []any{d.v}
+	for _, st := range path {
+		var next []any
+		for _, v := range cur {
+			switch v := v.(type) {
+			case map[string]any:
+				if st.wild {
+					for _, x := range v {
+						next = append(next, x)
+					}
+				} else if x, ok := v[st.key]; ok && !st.isIdx {
+					next = append(next, x)
+				}
+			case []any:
+				switch {
+				case st.wild:
+					next = append(next, v...)
+				case st.isIdx:
+					i := st.index
+					if i < 0 {
+						i += len(v)
+					}
+					if i >= 0 && i < len(v) {
+						next = append(next, v[i])
+					}
+				}
+			}
+		}
+		cur = next
+	}
+	out := make([]string, 0, len(cur))
+	for _, v := range cur {
+		switch v := v.(type) {
+		case string:
+			out = append(out, v)
+		case nil:
+			out = append(out, "null")
+		default:
+			data, _ := json.Marshal(v)
+			out = append(out, string(data))
+		}
+	}
+	return out
+}
+
+// JSONPath is a compiled path for use outside query expressions, such as
+// mock rule body matchers.
+type JSONPath struct {
+	path jsonPath
+}
+
+// CompileJSONPath compiles the same JSONPath subset as query expressions.
+func CompileJSONPath(src string) (*JSONPath, error) {
+	path, err := compilePath(src)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONPath{path: path}, nil
+}
+
+// Select returns the values p selects in the JSON document body, rendered
+// as text like query comparisons see them. It returns nil when body is not
+// JSON.
+func (p *JSONPath) Select(body []byte) []string {
+	return parseJSONDoc(body).lookup(p.path)
+}
@@ -0,0 +1,196 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-$[]*:/+", r)
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			s, err := strconv.Unquote(string(rs[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i+1, err)
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+		case r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != '\'' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			toks = append(toks, token{tokString, string(rs[i+1 : j]), i})
+			i = j + 1
+		case strings.ContainsRune("=!<>~&|", r):
+			j := i + 1
+			for j < len(rs) && strings.ContainsRune("=!<>~&|", rs[j]) && j-i < 2 {
+				j++
+			}
+			toks = append(toks, token{tokOp, string(rs[i:j]), i})
+			i = j
+		case isWordRune(r):
+			j := i
+			for j < len(rs) && isWordRune(rs[j]) {
+				j++
+			}
+			toks = append(toks, token{tokWord, string(rs[i:j]), i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", r, i+1)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(rs)}), nil
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(words ...string) bool {
+	t := p.peek()
+	for _, w := range words {
+		if (t.kind == tokWord || t.kind == tokOp) && strings.EqualFold(t.text, w) {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and", "&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.keyword("not", "!") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at %d", t.pos+1)
+		}
+		return n, nil
+	case tokWord:
+		f, err := lookupField(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w at %d", err, t.pos+1)
+		}
+		op := p.peek()
+		if op.kind != tokOp || op.text == "&&" || op.text == "||" || op.text == "!" {
+			return existsNode{f}, nil
+		}
+		p.next()
+		v := p.next()
+		if v.kind != tokWord && v.kind != tokString {
+			return nil, fmt.Errorf("expected a value after %s at %d", op.text, op.pos+1)
+		}
+		n, err := newCompare(f, op.text, v.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %s: %w", t.text, op.text, v.text, err)
+		}
+		return n, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+	}
+}
@@ -0,0 +1,347 @@
+// Package query implements the capture filter language shared by list,
+// watch, grep, pipe, assert, the TUI and the web API.
+//
+// A query combines comparisons with and, or, not and parentheses:
+//
+//	host ~ "api." and status >= 500 and resp.json.$.error.code == "RATE_LIMIT"
+//
+// Operators are == != ~ (substring) !~ =~ (regular expression) < <= > >=.
+// A field on its own tests that it is present and non-empty.
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+// Query is a compiled filter expression.
+type Query struct {
+	src  string
+	root node
+	load func(*capture.Capture) *capture.Capture
+}
+
+// Parse compiles src. An empty or blank src matches every capture.
+func Parse(src string) (*Query, error) {
+	q := &Query{src: strings.TrimSpace(src)}
+	if q.src == "" {
+		return q, nil
+	}
+	toks, err := lex(q.src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos+1)
+	}
+	q.root = root
+	return q, nil
+}
+
+// And combines the non-empty expressions in parts into one query.
+func And(parts ...string) (*Query, error) {
+	var terms []string
+	for _, p := range parts {
+		if strings.TrimSpace(p) != "" {
+			terms = append(terms, "("+p+")")
+		}
+	}
+	return Parse(strings.Join(terms, " and "))
+}
+
+func (q *Query) String() string { return q.src }
+
+// Empty reports whether q matches everything.
+func (q *Query) Empty() bool { return q == nil || q.root == nil }
+
+func (q *Query) Match(c *capture.Capture) bool {
+	if q.Empty() {
+		return true
+	}
+	return q.root.match(&env{c: c, load: q.load})
+}
+
+// WithBodies returns a copy of q that reads bodies stored as blobs through
+// load when a body field is tested, such as capture.Store.SearchBodies.
+// Without it those bodies match as empty.
+func (q *Query) WithBodies(load func(*capture.Capture) *capture.Capture) *Query {
+	if q == nil {
+		return nil
+	}
+	out := *q
+	out.load = load
+	return &out
+}
+
+// Filter returns the captures in cs that match q, keeping their order.
+func (q *Query) Filter(cs []*capture.Capture) []*capture.Capture {
+	if q.Empty() {
+		return cs
+	}
+	var out []*capture.Capture
+	for _, c := range cs {
+		if q.Match(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Hint returns an index filter that every capture matching q also matches,
+// so callers can narrow the store lookup before evaluating q itself.
+func (q *Query) Hint() capture.Filter {
+	var f capture.Filter
+	if q.Empty() {
+		return f
+	}
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case andNode:
+			walk(n.left)
+			walk(n.right)
+		case *compareNode:
+			switch {
+			case n.field.name == "status" && n.op == "==":
+				f.Status = int(n.num)
+			case n.field.name == "time" && (n.op == ">=" || n.op == ">"):
+				f.Since = n.time
+			case n.field.name == "time" && (n.op == "<=" || n.op == "<"):
+				f.Until = n.time
+			}
+		}
+	}
+	walk(q.root)
+	return f
+}
+
+type env struct {
+	c        *capture.Capture
+	load     func(*capture.Capture) *capture.Capture
+	full     *capture.Capture
+	reqJSON  *jsonDoc
+	respJSON *jsonDoc
+}
+
+// bodies returns the capture with its blob bodies read back in, loading
+// them the first time a field needs them.
+func (e *env) bodies() *capture.Capture {
+	if e.full == nil {
+		e.full = e.c
+		if e.load != nil && (e.c.Request.BodyBlob != nil || e.c.Response != nil && e.c.Response.BodyBlob != nil) {
+			e.full = e.load(e.c)
+		}
+	}
+	return e.full
+}
+
+type node interface {
+	match(e *env) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) match(e *env) bool { return n.left.match(e) && n.right.match(e) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) match(e *env) bool { return n.left.match(e) || n.right.match(e) }
+
+type notNode struct{ n node }
+
+func (n notNode) match(e *env) bool { return !n.n.match(e) }
+
+type existsNode struct{ field *field }
+
+func (n existsNode) match(e *env) bool {
+	for _, v := range n.field.values(e) {
+		if v != "" && v != "0" && v != "false" {
+			return true
+		}
+	}
+	return false
+}
+
+type compareNode struct {
+	field *field
+	op    string
+	str   string
+	num   float64
+	isNum bool
+	time  time.Time
+	re    *regexp.Regexp
+}
+
+func newCompare(f *field, op, val string) (node, error) {
+	if op == "=" {
+		op = "=="
+	}
+	n := &compareNode{field: f, op: op, str: val}
+	switch op {
+	case "==", "!=", "~", "!~", "<", "<=", ">", ">=":
+	case "=~":
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return nil, err
+		}
+		n.re = re
+	default:
+		return nil, fmt.Errorf("unknown operator")
+	}
+	if f.session {
+		return newSessionNode(op, val)
+	}
+	switch f.kind {
+	case kindNumber:
+		num, err := strconv.ParseFloat(val, 64)
+		if err != nil && n.re == nil && op != "~" && op != "!~" {
+			return nil, fmt.Errorf("%s is a number", f.name)
+		}
+		n.num, n.isNum = num, err == nil
+	case kindDuration:
+		d, err := parseDuration(val)
+		if err != nil {
+			return nil, err
+		}
+		n.num, n.isNum = d, true
+	case kindTime:
+		t, err := parseTime(val)
+		if err != nil {
+			return nil, err
+		}
+		n.time = t
+	default:
+		if num, err := strconv.ParseFloat(val, 64); err == nil {
+			n.num, n.isNum = num, true
+		}
+	}
+	if f.fold {
+		n.str = strings.ToLower(n.str)
+	}
+	return n, nil
+}
+
+func (n *compareNode) match(e *env) bool {
+	negate := n.op == "!=" || n.op == "!~"
+	for _, v := range n.field.values(e) {
+		if n.test(v) {
+			return !negate
+		}
+	}
+	return negate
+}
+
+func (n *compareNode) test(v string) bool {
+	if n.field.fold {
+		v = strings.ToLower(v)
+	}
+	switch n.op {
+	case "==", "!=":
+		if n.field.kind == kindTime {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			return err == nil && t.Equal(n.time)
+		}
+		if n.isNum {
+			if x, err := strconv.ParseFloat(v, 64); err == nil {
+				return x == n.num
+			}
+		}
+		return v == n.str
+	case "~", "!~":
+		return strings.Contains(v, n.str)
+	case "=~":
+		return n.re.MatchString(v)
+	}
+	cmp, ok := n.order(v)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// order compares v with the operand, numerically when both are numbers.
+func (n *compareNode) order(v string) (int, bool) {
+	if n.field.kind == kindTime {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return 0, false
+		}
+		return t.Compare(n.time), true
+	}
+	if n.isNum {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case x < n.num:
+			return -1, true
+		case x > n.num:
+			return 1, true
+		}
+		return 0, true
+	}
+	return strings.Compare(v, n.str), true
+}
+
+// parseDuration reads a Go duration or a plain number of milliseconds and
+// returns milliseconds.
+func parseDuration(s string) (float64, error) {
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		return ms, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use e.g. 500ms or 2s", s)
+	}
+	return float64(d) / float64(time.Millisecond), nil
+}
+
+// parseTime reads an absolute timestamp, or a duration such as 1h meaning
+// that long ago.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC3339, 2006-01-02 or a duration ago such as 1h", s)
+}
+
+func hostOf(c *capture.Capture) string { return capture.HostOf(c) }
+
+func pathOf(c *capture.Capture) string {
+	u, err := url.Parse(c.Request.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+func TestMatch(t *testing.T) {
+	c := &capture.Capture{
+		ID:        "abc",
+		Timestamp: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Protocol:  "h2",
+		Duration:  750 * time.Millisecond,
+		Tags:      []string{"bug-1234", "checkout"},
+		Request: capture.RequestSnapshot{
+			Method:  "POST",
+			URL:     "https://api.example.com/v1/orders?page=2",
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body:    capture.BodyBytes(`{"items":[{"sku":"a1","qty":2},{"sku":"b2","qty":5}]}`),
+		},
+		Response: &capture.ResponseSnapshot{
+			StatusCode: 429,
+			Headers:    http.Header{"Retry-After": {"30"}},
+			Body:       capture.BodyBytes(`{"error":{"code":"RATE_LIMIT"}}`),
+		},
+	}
+	cases := []struct {
+		q    string
+		want bool
+	}{
+		{``, true},
+		{`host ~ "api." and status >= 400 and resp.json.$.error.code == "RATE_LIMIT"`, true},
+		{`method == post`, true},
+		{`status == 200 or status == 429`, true},
+		{`not status >= 500`, true},
+		{`status > 429`, false},
+		{`duration > 500ms and duration < 1s`, true},
+		{`duration >= 1000`, false},
+		{`req.header.content-type ~ json`, true},
+		{`resp.header.retry-after`, true},
+		{`req.header.authorization`, false},
+		{`req.json.$.items[*].sku == b2`, true},
+		{`req.json.$.items[-1].qty > 4`, true},
+		{`req.json.$.items[0].qty > 4`, false},
+		{`path =~ "^/v1/(orders|carts)$"`, true},
+		{`url !~ "page=2"`, false},
+		{`proto == H2 && !websocket`, true},
+		{`time >= 2026-10-01 and time < "2026-10-02"`, true},
+		{`(method == GET or method == PUT) and status == 429`, false},
+		{`tag == checkout and not starred`, true},
+		{`tag ~ bug- and note`, false},
+	}
+	for _, tc := range cases {
+		q, err := Parse(tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.q, err)
+		}
+		if got := q.Match(c); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`status >=`,
+		`nope == 1`,
+		`status == abc`,
+		`(status == 200`,
+		`url =~ "["`,
+		`resp.json.error == x`,
+		`duration > soon`,
+		`method == GET GET`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	q, err := Parse(`status == 500 and time >= 2026-01-01 and (method == GET or host ~ x)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := q.Hint()
+	if f.Status != 500 || f.Since.IsZero() || f.Method != "" || f.Host != "" {
+		t.Fatalf("hint: %+v", f)
+	}
+}
+
+func TestWithBodies(t *testing.T) {
+	c := &capture.Capture{
+		Request:  capture.RequestSnapshot{Method: "GET", URL: "http://a.test/"},
+		Response: &capture.ResponseSnapshot{StatusCode: 200, BodyBlob: &capture.BlobRef{Size: 1 << 20}},
+	}
+	loads := 0
+	load := func(c *capture.Capture) *capture.Capture {
+		loads++
+		full := *c
+		resp := *c.Response
+		resp.Body, resp.BodyBlob = capture.BodyBytes(`{"error":"quota exceeded"}`), nil
+		full.Response = &resp
+		return &full
+	}
+	q, err := Parse(`body ~ "quota" and resp.json.$.error == "quota exceeded" and resp.size > 1000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Match(c) {
+		t.Fatal("matched a blob body without a loader")
+	}
+	if !q.WithBodies(load).Match(c) || loads != 1 {
+		t.Fatalf("blob body not matched once loaded (%d loads)", loads)
+	}
+	if q, _ := Parse("status == 200"); !q.WithBodies(load).Match(c) || loads != 1 {
+		t.Fatalf("loaded bodies for a query without body fields (%d loads)", loads)
+	}
+}
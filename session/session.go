@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/muxover/snare/v2/capture"
@@ -14,6 +15,15 @@ import (
 type Entry struct {
 	Start time.Time `json:"start"`
 	End   time.Time `json:"end,omitempty"`
+	// Auto marks the run-* sessions serve and run open on their own; they
+	// are not named sessions and do not keep captures.
+	Auto bool `json:"auto,omitempty"`
+}
+
+// Contains reports whether t falls inside the session. Open sessions
+// extend to the present.
+func (e Entry) Contains(t time.Time) bool {
+	return !t.Before(e.Start) && (e.End.IsZero() || !t.After(e.End))
 }
 
 func FilePath() string {
@@ -63,6 +73,32 @@ func Captures(all []*capture.Capture, e Entry) []*capture.Capture {
 	return out
 }
 
+// Keeper reports whether a capture falls inside any named session. The
+// sessions file is re-read whenever it changes, so sessions started after
+// the Keeper was made are honoured too.
+func Keeper() func(*capture.Capture) bool {
+	var (
+		mu       sync.Mutex
+		modTime  time.Time
+		sessions map[string]Entry
+	)
+	return func(c *capture.Capture) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if fi, err := os.Stat(FilePath()); err == nil && !fi.ModTime().Equal(modTime) {
+			if m, err := Load(); err == nil {
+				sessions, modTime = m, fi.ModTime()
+			}
+		}
+		for _, e := range sessions {
+			if !e.Auto && e.Contains(c.Timestamp) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func RequestPath(c *capture.Capture) string {
 	u, err := url.Parse(c.Request.URL)
 	if err != nil {
@@ -0,0 +1,43 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+func TestKeeperIgnoresAutoSessions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	now := time.Now()
+	if err := Save(map[string]Entry{
+		"run-serve": {Start: now.Add(-48 * time.Hour), Auto: true},
+		"checkout":  {Start: now.Add(-30 * time.Hour), End: now.Add(-29 * time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := capture.NewStoreBackend(100, t.TempDir(), capture.BackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, age := range []time.Duration{40 * time.Hour, 30*time.Hour - time.Minute, time.Minute} {
+		s.Add(&capture.Capture{
+			ID:        fmt.Sprintf("id-%d", i),
+			Timestamp: now.Add(-age),
+			Request:   capture.RequestSnapshot{Method: "GET", URL: "http://a.test/"},
+		})
+	}
+	s.SetRetention(capture.Retention{MaxAge: 24 * time.Hour, Keep: Keeper()})
+	if n, err := s.Compact(); err != nil || n != 1 {
+		t.Fatalf("removed %d, err %v", n, err)
+	}
+	got := map[string]bool{}
+	for _, c := range s.AllFromDisk() {
+		got[c.ID] = true
+	}
+	if got["id-0"] || !got["id-1"] || !got["id-2"] {
+		t.Fatalf("kept %v", got)
+	}
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"sort"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+// Bar is one request placed on a waterfall.
+type Bar struct {
+	ID       string           `json:"id"`
+	Method   string           `json:"method"`
+	URL      string           `json:"url"`
+	Status   int              `json:"status"`
+	Offset   time.Duration    `json:"offset_ns"`
+	Duration time.Duration    `json:"duration_ns"`
+	Lane     int              `json:"lane"`
+	Critical bool             `json:"critical,omitempty"`
+	Timings  *capture.Timings `json:"timings,omitempty"`
+}
+
+// End is the offset at which the request finished.
+func (b Bar) End() time.Duration {
+	return b.Offset + b.Duration
+}
+
+// Gap is a stretch of the waterfall with no request in flight.
+type Gap struct {
+	Offset   time.Duration `json:"offset_ns"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Waterfall lays a set of captures out on a shared timeline.
+type Waterfall struct {
+	Start          time.Time     `json:"start"`
+	Span           time.Duration `json:"span_ns"`
+	Lanes          int           `json:"lanes"`
+	MaxConcurrency int           `json:"max_concurrency"`
+	Bars           []Bar         `json:"bars"`
+	Gaps           []Gap         `json:"gaps"`
+	Critical       []string      `json:"critical"`
+	CriticalTime   time.Duration `json:"critical_ns"`
+}
+
+// NewWaterfall builds a waterfall from captures, ordered by start time.
+// Requests are packed into the fewest lanes that keep overlapping requests
+// apart, so the lane count is the peak concurrency. The critical path runs
+// back from the request that finished last, at each step to the request
+// that finished most recently before the current one started.
+func NewWaterfall(captures []*capture.Capture) Waterfall {
+	w := Waterfall{Bars: []Bar{}, Gaps: []Gap{}, Critical: []string{}}
+	if len(captures) == 0 {
+		return w
+	}
+	sorted := make([]*capture.Capture, len(captures))
+	copy(sorted, captures)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+	w.Start = sorted[0].Timestamp
+
+	var laneEnds []time.Duration
+	for _, c := range sorted {
+		b := Bar{
+			ID:       c.ID,
+			Method:   c.Request.Method,
+			URL:      c.Request.URL,
+			Status:   ResponseStatus(c),
+			Offset:   c.Timestamp.Sub(w.Start),
+			Duration: c.Duration,
+			Timings:  c.Timings,
+		}
+		if c.Timings != nil {
+			b.Duration = max(b.Duration, c.Timings.Total())
+		}
+		b.Lane = -1
+		for i, end := range laneEnds {
+			if end <= b.Offset {
+				b.Lane = i
+				break
+			}
+		}
+		if b.Lane < 0 {
+			b.Lane = len(laneEnds)
+			laneEnds = append(laneEnds, 0)
+		}
+		laneEnds[b.Lane] = b.End()
+		w.Bars = append(w.Bars, b)
+	}
+	w.Lanes = len(laneEnds)
+	w.MaxConcurrency = w.Lanes
+
+	var reach time.Duration
+	for _, b := range w.Bars {
+		if b.Offset > reach {
+			w.Gaps = append(w.Gaps, Gap{Offset: reach, Duration: b.Offset - reach})
+		}
+		reach = max(reach, b.End())
+	}
+	w.Span = reach
+
+	last := 0
+	for i, b := range w.Bars {
+		if b.End() >= w.Bars[last].End() {
+			last = i
+		}
+	}
+	var path []int
+	for cur := last; cur >= 0; {
+		path = append(path, cur)
+		w.Bars[cur].Critical = true
+		w.CriticalTime += w.Bars[cur].Duration
+		next := -1
+		for i, b := range w.Bars {
+			if i == cur || b.Critical || b.End() > w.Bars[cur].Offset {
+				continue
+			}
+			if next < 0 || b.End() > w.Bars[next].End() {
+				next = i
+			}
+		}
+		cur = next
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		w.Critical = append(w.Critical, w.Bars[path[i]].ID)
+	}
+	return w
+}
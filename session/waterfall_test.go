@@ -0,0 +1,56 @@
+package session
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/muxover/snare/v2/capture"
+)
+
+func TestNewWaterfall(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(id string, start, dur int) *capture.Capture {
+		return &capture.Capture{
+			ID:        id,
+			Timestamp: base.Add(time.Duration(start) * time.Millisecond),
+			Duration:  time.Duration(dur) * time.Millisecond,
+			Request:   capture.RequestSnapshot{Method: "GET", URL: "http://example.com/" + id},
+		}
+	}
+	// page loads, then two assets in parallel, then after an idle second a
+	// follow-up that only waits on the slower asset.
+	wf := NewWaterfall([]*capture.Capture{
+		at("late", 1500, 100),
+		at("page", 0, 100),
+		at("css", 120, 50),
+		at("js", 110, 300),
+	})
+
+	if wf.Span != 1600*time.Millisecond {
+		t.Fatalf("span = %s", wf.Span)
+	}
+	if wf.MaxConcurrency != 2 {
+		t.Fatalf("max concurrency = %d", wf.MaxConcurrency)
+	}
+	lanes := map[string]int{}
+	for _, b := range wf.Bars {
+		lanes[b.ID] = b.Lane
+	}
+	if lanes["page"] != 0 || lanes["js"] != 0 || lanes["css"] != 1 || lanes["late"] != 0 {
+		t.Fatalf("lanes = %v", lanes)
+	}
+	want := []Gap{
+		{Offset: 100 * time.Millisecond, Duration: 10 * time.Millisecond},
+		{Offset: 410 * time.Millisecond, Duration: 1090 * time.Millisecond},
+	}
+	if !slices.Equal(wf.Gaps, want) {
+		t.Fatalf("gaps = %v", wf.Gaps)
+	}
+	if !slices.Equal(wf.Critical, []string{"page", "js", "late"}) {
+		t.Fatalf("critical path = %v", wf.Critical)
+	}
+	if wf.CriticalTime != 500*time.Millisecond {
+		t.Fatalf("critical time = %s", wf.CriticalTime)
+	}
+}
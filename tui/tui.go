@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/intercept"
 	"github.com/muxover/snare/v2/mock"
+	"github.com/muxover/snare/v2/query"
 	sess "github.com/muxover/snare/v2/session"
 )
 
@@ -44,6 +46,7 @@ const (
 	tabMocks
 	tabIntercept
 	tabSessions
+	tabWaterfall
 )
 
 type viewState int
@@ -57,12 +60,16 @@ const (
 	viewSessStart
 	viewSessDiff
 	viewReplayEdit
+	viewAnnotate
 )
 
 type tickMsg time.Time
 
+type captureEventMsg capture.Event
+
 type Model struct {
 	store     *capture.Store
+	events    <-chan capture.Event
 	mocks     *mock.Store
 	intercept *intercept.Queue
 
@@ -80,9 +87,12 @@ type Model struct {
 	filter      string
 	filterDraft string
 	diffA       string
+	annInput    textinput.Model
+	annField    string
 
 	// mocks tab
 	mockRules  []*mock.Rule
+	mockHits   map[string]mock.Hits
 	mockCursor int
 	mockInputs [5]textinput.Model
 	mockFocus  int
@@ -105,6 +115,11 @@ type Model struct {
 	sessInput  textinput.Model
 	sessA      string
 
+	// waterfall tab
+	wf       sess.Waterfall
+	wfTitle  string
+	wfCursor int
+
 	proxyURL     string
 	clearConfirm bool
 }
@@ -122,6 +137,7 @@ func New(store *capture.Store, mocks *mock.Store, iq *intercept.Queue, proxyURL
 		sessInput: si,
 		proxyURL:  proxyURL,
 	}
+	m.events, _ = store.Follow(pollInterval)
 	m.reload()
 	m.reloadMocks()
 	m.reloadIntercept()
@@ -134,13 +150,33 @@ func (m *Model) reload() {
 	m.applyFilter()
 }
 
+// applyFilter treats the filter as a query expression when it parses as
+// one. Otherwise it lists full-text search hits best first, followed by any
+// other captures whose URL or method contains the filter.
 func (m *Model) applyFilter() {
 	if m.filter == "" {
 		m.filtered = m.all
+	} else if q, err := query.Parse(m.filter); err == nil {
+		m.filtered = q.WithBodies(m.store.SearchBodies).Filter(m.all)
 	} else {
 		low := strings.ToLower(m.filter)
+		byID := make(map[string]*capture.Capture, len(m.all))
+		for _, c := range m.all {
+			byID[c.ID] = c
+		}
 		var out []*capture.Capture
+		seen := make(map[string]bool)
+		hits, _ := m.store.Search(m.filter, 0)
+		for _, h := range hits {
+			if c := byID[h.Capture.ID]; c != nil {
+				out = append(out, c)
+				seen[c.ID] = true
+			}
+		}
 		for _, c := range m.all {
+			if seen[c.ID] {
+				continue
+			}
 			if strings.Contains(strings.ToLower(c.Request.URL), low) ||
 				strings.Contains(strings.ToLower(c.Request.Method), low) {
 				out = append(out, c)
@@ -156,6 +192,7 @@ func (m *Model) applyFilter() {
 func (m *Model) reloadMocks() {
 	if m.mocks != nil {
 		m.mockRules = m.mocks.Rules()
+		m.mockHits = m.mocks.Hits()
 	}
 }
 
@@ -178,7 +215,48 @@ func (m *Model) reloadSessions() {
 }
 
 func (m Model) Init() tea.Cmd {
-	return tea.Tick(pollInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+	return tea.Batch(
+		tea.Tick(pollInterval, func(t time.Time) tea.Msg { return tickMsg(t) }),
+		m.waitEvent(),
+	)
+}
+
+func (m Model) waitEvent() tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := <-m.events
+		if !ok {
+			return nil
+		}
+		return captureEventMsg(ev)
+	}
+}
+
+func (m *Model) applyEvent(ev capture.Event) {
+	switch ev.Type {
+	case capture.EventAdded:
+		for _, c := range m.all {
+			if c.ID == ev.ID {
+				return
+			}
+		}
+		m.all = append([]*capture.Capture{ev.Capture}, m.all...)
+	case capture.EventUpdated:
+		for i, c := range m.all {
+			if c.ID == ev.ID {
+				m.all[i] = ev.Capture
+			}
+		}
+	case capture.EventDeleted:
+		for i, c := range m.all {
+			if c.ID == ev.ID {
+				m.all = append(m.all[:i:i], m.all[i+1:]...)
+				break
+			}
+		}
+	case capture.EventCleared:
+		m.all = nil
+	}
+	m.applyFilter()
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -189,8 +267,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.vp = viewport.New(msg.Width, m.height-4)
 		return m, nil
 
+	case captureEventMsg:
+		m.applyEvent(capture.Event(msg))
+		return m, m.waitEvent()
+
 	case tickMsg:
-		m.reload()
 		m.reloadMocks()
 		m.reloadIntercept()
 		m.reloadSessions()
@@ -222,6 +303,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		var cmd tea.Cmd
 		m.sessInput, cmd = m.sessInput.Update(msg)
 		return m, cmd
+	case viewAnnotate:
+		var cmd tea.Cmd
+		m.annInput, cmd = m.annInput.Update(msg)
+		return m, cmd
 	case viewDetail, viewSessDiff:
 		var cmd tea.Cmd
 		m.vp, cmd = m.vp.Update(msg)
@@ -240,6 +325,8 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.replayEditKey(msg)
 	case viewSessStart:
 		return m.sessStartKey(msg)
+	case viewAnnotate:
+		return m.annotateKey(msg)
 	}
 
 	switch msg.String() {
@@ -262,6 +349,10 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.state = viewList
 		m.reloadSessions()
 		return m, nil
+	case "5":
+		m.tab = tabWaterfall
+		m.state = viewList
+		return m, nil
 	case "ctrl+c":
 		return m, tea.Quit
 	}
@@ -281,6 +372,8 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return m.icListKey(msg)
 		case tabSessions:
 			return m.sessListKey(msg)
+		case tabWaterfall:
+			return m.waterfallKey(msg)
 		default:
 			return m.listKey(msg)
 		}
@@ -371,6 +464,45 @@ func (m Model) listKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				}
 			}
 		}
+	case "w":
+		title := "all captures"
+		if m.filter != "" {
+			title = "filter: " + m.filter
+		}
+		m.openWaterfall(title, m.filtered)
+	case "*":
+		if len(m.filtered) > 0 {
+			c := m.filtered[m.cursor]
+			starred := !c.Starred
+			updated, err := m.store.Annotate(c.ID, capture.Annotation{Starred: &starred})
+			switch {
+			case err != nil:
+				m.notify = "star failed: " + err.Error()
+			case starred:
+				m.notify = "starred"
+			default:
+				m.notify = "unstarred"
+			}
+			if updated != nil {
+				m.applyEvent(capture.Event{Type: capture.EventUpdated, ID: c.ID, Capture: updated})
+			}
+		}
+	case "t", "n":
+		if len(m.filtered) > 0 {
+			c := m.filtered[m.cursor]
+			m.annInput = textinput.New()
+			m.annInput.Width = 50
+			if msg.String() == "t" {
+				m.annField = "tags"
+				m.annInput.Placeholder = "space-separated tags"
+				m.annInput.SetValue(strings.Join(c.Tags, " "))
+			} else {
+				m.annField = "note"
+				m.annInput.SetValue(c.Note)
+			}
+			m.annInput.Focus()
+			m.state = viewAnnotate
+		}
 	case "/":
 		m.filterDraft = m.filter
 		m.state = viewFilter
@@ -381,6 +513,49 @@ func (m Model) listKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m Model) annotateKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.state = viewList
+		return m, nil
+	case "enter":
+		m.state = viewList
+		if len(m.filtered) == 0 {
+			return m, nil
+		}
+		c := m.filtered[m.cursor]
+		var a capture.Annotation
+		if m.annField == "tags" {
+			tags := strings.FieldsFunc(m.annInput.Value(), func(r rune) bool { return r == ' ' || r == ',' })
+			for _, t := range tags {
+				if !c.HasTag(t) {
+					a.AddTags = append(a.AddTags, t)
+				}
+			}
+			for _, t := range c.Tags {
+				if !slices.Contains(tags, t) {
+					a.RemoveTags = append(a.RemoveTags, t)
+				}
+			}
+		} else {
+			note := m.annInput.Value()
+			a.Note = &note
+		}
+		updated, err := m.store.Annotate(c.ID, a)
+		if err != nil {
+			m.notify = err.Error()
+			return m, nil
+		}
+		m.applyEvent(capture.Event{Type: capture.EventUpdated, ID: c.ID, Capture: updated})
+		m.notify = m.annField + " saved"
+		return m, nil
+	default:
+		var cmd tea.Cmd
+		m.annInput, cmd = m.annInput.Update(msg)
+		return m, cmd
+	}
+}
+
 func (m Model) detailKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	m.notify = ""
 	switch msg.String() {
@@ -767,6 +942,11 @@ func (m Model) sessListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.reloadSessions()
 			m.notify = "session deleted"
 		}
+	case "w":
+		if len(m.sessNames) > 0 {
+			name := m.sessNames[m.sessCursor]
+			m.openWaterfall("session "+name, sess.Captures(m.store.AllFromDisk(), m.sessions[name]))
+		}
 	case " ":
 		if len(m.sessNames) > 0 {
 			name := m.sessNames[m.sessCursor]
@@ -799,6 +979,47 @@ func (m Model) sessListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m *Model) openWaterfall(title string, captures []*capture.Capture) {
+	m.wf = sess.NewWaterfall(captures)
+	m.wfTitle = title
+	m.wfCursor = 0
+	m.tab = tabWaterfall
+	m.state = viewList
+}
+
+func (m Model) waterfallKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.notify = ""
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "up", "k":
+		if m.wfCursor > 0 {
+			m.wfCursor--
+		}
+	case "down", "j":
+		if m.wfCursor < len(m.wf.Bars)-1 {
+			m.wfCursor++
+		}
+	case "enter":
+		if len(m.wf.Bars) == 0 {
+			break
+		}
+		id := m.wf.Bars[m.wfCursor].ID
+		for _, c := range m.all {
+			if c.ID == id {
+				m.tab = tabCaptures
+				m.vp = viewport.New(m.width, m.height-4)
+				m.vp.SetContent(renderDetail(c, m.width))
+				m.vp.GotoTop()
+				m.state = viewDetail
+				return m, nil
+			}
+		}
+		m.notify = "capture no longer in memory"
+	}
+	return m, nil
+}
+
 func (m Model) sessStartKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "esc":
@@ -855,6 +1076,7 @@ func (m Model) renderHeader() string {
 		{"2", "Mocks", tabMocks},
 		{"3", "Intercept", tabIntercept},
 		{"4", "Sessions", tabSessions},
+		{"5", "Waterfall", tabWaterfall},
 	}
 	var parts []string
 	for _, tab := range tabs {
@@ -876,6 +1098,8 @@ func (m Model) renderBody() string {
 		return m.renderInterceptBody()
 	case tabSessions:
 		return m.renderSessionsBody()
+	case tabWaterfall:
+		return m.renderWaterfall()
 	default:
 		return m.renderCapturesBody()
 	}
@@ -945,10 +1169,13 @@ func (m Model) renderListView() string {
 		rows = []string{styleDim.Render("  no captures")}
 	}
 
-	hint := "  ↑↓ navigate · enter inspect · r replay · d delete · C clear all · space mark diff · D diff · / filter · esc clear filter · 1-4 tabs · q quit"
+	hint := "  ↑↓ navigate · enter inspect · w waterfall · r replay · d delete · C clear all · space mark diff · D diff · * star · t tags · n note · / filter · esc clear filter · 1-5 tabs · q quit"
 	if m.notify != "" {
 		hint = "  " + m.notify
 	}
+	if m.state == viewAnnotate {
+		hint = "  " + m.annField + ": " + m.annInput.View() + "  enter save · esc cancel"
+	}
 
 	lines := []string{title, cols}
 	lines = append(lines, rows...)
@@ -980,8 +1207,14 @@ func (m Model) renderRow(i int) string {
 	if len(u) > maxURL {
 		u = u[:maxURL-1] + "…"
 	}
+	if len(c.Tags) > 0 {
+		u += "  #" + strings.Join(c.Tags, " #")
+	}
 	row := fmt.Sprintf("  %-8s  %-8s  %-7s  %-3s  %-8s  %s",
 		id, c.Timestamp.Format("15:04:05"), c.Request.Method, status, fmtLatency(c.Duration), u)
+	if c.Starred {
+		row = " ★" + row[2:]
+	}
 	if i == m.cursor {
 		prefix := "▶"
 		if m.diffA == c.ID {
@@ -1025,6 +1258,9 @@ func (m Model) renderMockList() string {
 			method = "*"
 		}
 		line := fmt.Sprintf("  %-6s  %-30s  →  %d", method, truncate(r.URLPattern, 30), r.Status)
+		if h := m.mockHits[r.ID]; h.Count > 0 {
+			line += fmt.Sprintf("  %4d hits  last %s", h.Count, h.Last.Local().Format("15:04:05"))
+		}
 		if i == m.mockCursor {
 			rows = append(rows, styleSel.Render("▶"+line[1:]))
 		} else {
@@ -1034,7 +1270,7 @@ func (m Model) renderMockList() string {
 	if len(m.mockRules) == 0 {
 		rows = []string{styleDim.Render("  no mock rules")}
 	}
-	hint := styleBar.Render("  ↑↓ navigate · a add · d delete · C clear all · 1-4 tabs · q quit")
+	hint := styleBar.Render("  ↑↓ navigate · a add · d delete · C clear all · 1-5 tabs · q quit")
 	if m.notify != "" {
 		hint = "  " + m.notify
 	}
@@ -1088,7 +1324,7 @@ func (m Model) renderICList() string {
 	if len(m.pending) == 0 {
 		rows = []string{styleDim.Render("  " + label)}
 	}
-	hint := styleBar.Render("  ↑↓ navigate · f forward · x drop · e edit · r reload · 1-4 tabs · q quit")
+	hint := styleBar.Render("  ↑↓ navigate · f forward · x drop · e edit · r reload · 1-5 tabs · q quit")
 	if m.notify != "" {
 		hint = "  " + m.notify
 	}
@@ -1157,7 +1393,7 @@ func (m Model) renderSessList() string {
 	if len(m.sessNames) == 0 {
 		rows = []string{styleDim.Render("  no sessions — press s to start one")}
 	}
-	hint := styleBar.Render("  ↑↓ navigate · s start · e end · x delete · space mark diff · D diff · 1-4 tabs · q quit")
+	hint := styleBar.Render("  ↑↓ navigate · s start · e end · w waterfall · x delete · space mark diff · D diff · 1-5 tabs · q quit")
 	if m.notify != "" {
 		hint = "  " + m.notify
 	}
@@ -1170,6 +1406,90 @@ func (m Model) renderSessList() string {
 	return strings.Join(lines, "\n")
 }
 
+func (m Model) renderWaterfall() string {
+	wf := m.wf
+	if m.wfTitle == "" {
+		lines := []string{styleDim.Render("  press w on a session (4) or on the capture list (1) to build a waterfall")}
+		for len(lines) < m.height-3 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, styleBar.Render("  1-5 tabs · q quit"))
+		return strings.Join(lines, "\n")
+	}
+	title := styleSec.Render(m.wfTitle) + styleDim.Render(fmt.Sprintf(
+		"  %d req · span %s · max concurrency %d · %d idle gaps · critical path %d req, %s",
+		len(wf.Bars), fmtLatency(wf.Span), wf.MaxConcurrency, len(wf.Gaps), len(wf.Critical), fmtLatency(wf.CriticalTime)))
+
+	const labelWidth = 36
+	track := max(10, m.width-labelWidth-12)
+	col := func(d time.Duration) int {
+		if wf.Span <= 0 {
+			return 0
+		}
+		return min(track-1, int(d*time.Duration(track)/wf.Span))
+	}
+	idle := make([]bool, track)
+	for _, g := range wf.Gaps {
+		for i := col(g.Offset); i < col(g.Offset+g.Duration); i++ {
+			idle[i] = true
+		}
+	}
+
+	visible := max(1, m.height-5)
+	start := 0
+	if m.wfCursor >= visible {
+		start = m.wfCursor - visible + 1
+	}
+	var rows []string
+	for i := start; i < len(wf.Bars) && i < start+visible; i++ {
+		b := wf.Bars[i]
+		status := "-"
+		if b.Status > 0 {
+			status = strconv.Itoa(b.Status)
+		}
+		path := b.URL
+		if u, err := url.Parse(b.URL); err == nil && u.Path != "" {
+			path = u.Path
+		}
+		label := fmt.Sprintf("  %-7s %-3s %s", b.Method, status, truncate(path, labelWidth-14))
+		label = fmt.Sprintf("%-*s", labelWidth, label)
+		if i == m.wfCursor {
+			label = styleSel.Render(label)
+		}
+		from, to := col(b.Offset), col(b.End())
+		var bar strings.Builder
+		for c := 0; c < track; c++ {
+			switch {
+			case c >= from && (c < to || c == from):
+				bar.WriteString("█")
+			case idle[c]:
+				bar.WriteString("·")
+			default:
+				bar.WriteString(" ")
+			}
+		}
+		drawn := styleDim.Render(bar.String())
+		if b.Critical {
+			drawn = styleActive.Render(bar.String())
+		}
+		rows = append(rows, label+" "+drawn+" "+fmt.Sprintf("%8s", fmtLatency(b.Duration)))
+	}
+	if len(wf.Bars) == 0 {
+		rows = []string{styleDim.Render("  no captures in range")}
+	}
+	hint := styleBar.Render("  ↑↓ navigate · enter inspect · yellow = critical path · dots = idle · 1-5 tabs · q quit")
+	if m.notify != "" {
+		hint = "  " + m.notify
+	}
+	lines := []string{title, styleDim.Render(fmt.Sprintf("%*s0%*s", labelWidth+1, "", track+9, "+"+fmtLatency(wf.Span)))}
+	lines = append(lines, rows...)
+	for len(lines) < m.height-2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, hint)
+	return strings.Join(lines, "\n")
+}
+
 func (m Model) renderSessStart() string {
 	var lines []string
 	lines = append(lines, styleSec.Render("Start session"))
@@ -1184,6 +1504,18 @@ func (m Model) renderSessStart() string {
 
 func renderDetail(c *capture.Capture, width int) string {
 	var b strings.Builder
+	if c.Starred || len(c.Tags) > 0 || c.Note != "" {
+		if c.Starred {
+			b.WriteString(styleActive.Render("★ starred") + "\n")
+		}
+		if len(c.Tags) > 0 {
+			b.WriteString(styleDim.Render("tags: ") + strings.Join(c.Tags, ", ") + "\n")
+		}
+		if c.Note != "" {
+			b.WriteString(styleDim.Render("note: ") + c.Note + "\n")
+		}
+		b.WriteString("\n")
+	}
 	b.WriteString(styleSec.Render("── Request ") + strings.Repeat("─", max(0, width-12)) + "\n")
 	b.WriteString(c.Request.Method + " " + c.Request.URL + "\n")
 	for k, vals := range c.Request.Headers {
@@ -1208,6 +1540,11 @@ func renderDetail(c *capture.Capture, width int) string {
 		}
 	}
 
+	if c.Timings != nil {
+		b.WriteString("\n" + styleSec.Render("── Timings ") + strings.Repeat("─", max(0, width-12)) + "\n")
+		b.WriteString(renderTimings(c.Timings, width))
+	}
+
 	if c.GraphQL != nil {
 		b.WriteString("\n" + styleSec.Render("── GraphQL ") + strings.Repeat("─", max(0, width-12)) + "\n")
 		if c.GraphQL.OperationName != "" {
@@ -1291,6 +1628,31 @@ func renderDetail(c *capture.Capture, width int) string {
 	return b.String()
 }
 
+func renderTimings(t *capture.Timings, width int) string {
+	var b strings.Builder
+	conn := "new connection"
+	if t.Reused {
+		conn = "reused connection"
+	}
+	if t.RemoteAddr != "" {
+		conn += " to " + t.RemoteAddr
+	}
+	b.WriteString(styleDim.Render(conn) + "\n")
+	barWidth := max(10, min(40, width-30))
+	total := t.Total()
+	var offset time.Duration
+	for _, p := range t.Phases() {
+		bar := ""
+		if total > 0 && p.Duration > 0 {
+			lead := int(offset * time.Duration(barWidth) / total)
+			bar = strings.Repeat(" ", lead) + strings.Repeat("█", max(1, int(p.Duration*time.Duration(barWidth)/total)))
+		}
+		b.WriteString(fmt.Sprintf("%-8s %8s  %s\n", p.Name, fmtLatency(p.Duration), styleWS.Render(bar)))
+		offset += p.Duration
+	}
+	return b.String()
+}
+
 func renderCaptureDiff(a, b *capture.Capture, width int) string {
 	var sb strings.Builder
 	sb.WriteString(styleSec.Render("── Diff ") + strings.Repeat("─", max(0, width-9)) + "\n")
@@ -22,6 +22,7 @@ import (
 	"github.com/muxover/snare/v2/capture"
 	"github.com/muxover/snare/v2/intercept"
 	"github.com/muxover/snare/v2/mock"
+	"github.com/muxover/snare/v2/query"
 	sess "github.com/muxover/snare/v2/session"
 )
 
@@ -65,14 +66,37 @@ func (s *Server) notifyDelete(id string) {
 	s.broadcast("event: deleted\ndata: \"" + id + "\"\n\n")
 }
 
+// WatchStore relays Store changes, including ones made by other snare
+// processes, to dashboard clients. The returned func stops relaying.
+func (s *Server) WatchStore() func() {
+	events, cancel := s.Store.Subscribe()
+	go func() {
+		for ev := range events {
+			switch ev.Type {
+			case capture.EventAdded, capture.EventUpdated:
+				s.NotifyCapture(ev.Capture)
+			case capture.EventDeleted:
+				s.notifyDelete(ev.ID)
+			case capture.EventCleared:
+				s.notifyClear()
+			}
+		}
+	}()
+	return cancel
+}
+
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	sub, _ := fs.Sub(Static, "static")
 	mux.Handle("/", http.FileServerFS(sub))
 	mux.HandleFunc("/api/captures", s.handleCaptures)
 	mux.HandleFunc("/api/captures/", s.handleCaptureByID)
+	mux.HandleFunc("/api/search", s.handleSearch)
 	mux.HandleFunc("/api/mocks", s.handleMocks)
 	mux.HandleFunc("/api/mocks/", s.handleMockByID)
+	mux.HandleFunc("/api/mocks/scenarios", s.handleMockScenarios)
+	mux.HandleFunc("/api/mocks/hits", s.handleMockHits)
+	mux.HandleFunc("/api/mocks/scenarios/", s.handleMockScenario)
 	mux.HandleFunc("/api/export", s.handleExport)
 	mux.HandleFunc("/api/intercept", s.handleIntercept)
 	mux.HandleFunc("/api/intercept/", s.handleInterceptByID)
@@ -97,20 +121,52 @@ func (s *Server) handleCaptures(w http.ResponseWriter, r *http.Request) {
 		if n, err := strconv.Atoi(limitStr); err == nil && n > 0 {
 			limit = n
 		}
-		captures := s.Store.List(limit)
+		q, err := query.Parse(r.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var captures []*capture.Capture
+		if q.Empty() {
+			captures = s.Store.List(limit)
+		} else {
+			captures = q.WithBodies(s.Store.SearchBodies).Filter(s.Store.List(0))
+			if len(captures) > limit {
+				captures = captures[:limit]
+			}
+		}
 		if captures == nil {
 			captures = []*capture.Capture{}
 		}
 		writeJSON(w, captures)
 	case http.MethodDelete:
 		s.Store.Clear(true)
-		s.notifyClear()
 		w.WriteHeader(http.StatusNoContent)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	hits, err := s.Store.Search(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hits == nil {
+		hits = []capture.SearchHit{}
+	}
+	writeJSON(w, hits)
+}
+
 func (s *Server) handleCaptureByID(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/captures/")
 	parts := strings.SplitN(path, "/", 2)
@@ -122,23 +178,39 @@ func (s *Server) handleCaptureByID(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case r.Method == http.MethodGet && sub == "":
-		c := s.Store.Get(id)
+		c := s.Store.WithBodies(s.Store.Get(id))
 		if c == nil {
 			http.NotFound(w, r)
 			return
 		}
 		writeJSON(w, c)
 
+	case r.Method == http.MethodPatch && sub == "":
+		var a capture.Annotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.Store.Get(id) == nil {
+			http.NotFound(w, r)
+			return
+		}
+		c, err := s.Store.Annotate(id, a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, c)
+
 	case r.Method == http.MethodDelete && sub == "":
 		if err := s.Store.DeleteByID(id); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		s.notifyDelete(id)
 		w.WriteHeader(http.StatusNoContent)
 
 	case r.Method == http.MethodPost && sub == "replay":
-		c := s.Store.Get(id)
+		c := s.Store.WithBodies(s.Store.Get(id))
 		if c == nil {
 			http.NotFound(w, r)
 			return
@@ -212,11 +284,16 @@ func (s *Server) replayCapture(c *capture.Capture, editMethod, editURL string, e
 func (s *Server) handleMocks(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		rules := s.Mocks.Rules()
-		if rules == nil {
-			rules = []*mock.Rule{}
+		type ruleHits struct {
+			*mock.Rule
+			Hits mock.Hits `json:"hits"`
+		}
+		hits := s.Mocks.Hits()
+		out := []ruleHits{}
+		for _, rule := range s.Mocks.Rules() {
+			out = append(out, ruleHits{rule, hits[rule.ID]})
 		}
-		writeJSON(w, rules)
+		writeJSON(w, out)
 
 	case http.MethodDelete:
 		if err := s.Mocks.Clear(); err != nil {
@@ -227,12 +304,25 @@ func (s *Server) handleMocks(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodPost:
 		var input struct {
-			Method      string `json:"method"`
-			URLMatch    string `json:"url_match"`
-			Status      int    `json:"status"`
-			Body        string `json:"body"`
-			ContentType string `json:"content_type"`
-			Name        string `json:"name"`
+			Method       string         `json:"method"`
+			URLMatch     string         `json:"url_match"`
+			MatchType    string         `json:"match_type"`
+			MatchQuery   []mock.Matcher `json:"match_query"`
+			MatchHeaders []mock.Matcher `json:"match_headers"`
+			MatchJSON    []mock.Matcher `json:"match_json"`
+			Priority     int            `json:"priority"`
+			Status       int            `json:"status"`
+			Body         string         `json:"body"`
+			ContentType  string         `json:"content_type"`
+			Template     bool           `json:"template"`
+			StatusTmpl   string         `json:"status_template"`
+			Scenario     string         `json:"scenario"`
+			WhenState    string         `json:"required_state"`
+			SetState     string         `json:"new_state"`
+			Responses    []mock.Step    `json:"responses"`
+			SequenceMode string         `json:"sequence_mode"`
+			Fault        *mock.Fault    `json:"fault"`
+			Name         string         `json:"name"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -243,17 +333,34 @@ func (s *Server) handleMocks(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		rule := &mock.Rule{
-			ID:          uuid.New().String(),
-			Name:        input.Name,
-			Method:      strings.ToUpper(input.Method),
-			URLPattern:  input.URLMatch,
-			Status:      input.Status,
-			Body:        input.Body,
-			ContentType: input.ContentType,
+			ID:             uuid.New().String(),
+			Name:           input.Name,
+			Method:         strings.ToUpper(input.Method),
+			URLPattern:     input.URLMatch,
+			MatchType:      input.MatchType,
+			MatchQuery:     input.MatchQuery,
+			MatchHeaders:   input.MatchHeaders,
+			MatchJSON:      input.MatchJSON,
+			Priority:       input.Priority,
+			Status:         input.Status,
+			Body:           input.Body,
+			ContentType:    input.ContentType,
+			Template:       input.Template || input.StatusTmpl != "",
+			StatusTemplate: input.StatusTmpl,
+			Scenario:       input.Scenario,
+			RequiredState:  input.WhenState,
+			NewState:       input.SetState,
+			Responses:      input.Responses,
+			SequenceMode:   input.SequenceMode,
+			Fault:          input.Fault,
 		}
 		if rule.Status == 0 {
 			rule.Status = http.StatusOK
 		}
+		if err := rule.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err := s.Mocks.Add(rule); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -284,6 +391,72 @@ func (s *Server) handleMockByID(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleMockHits serves GET /api/mocks/hits, the hits of every rule that
+// has matched by rule ID, and DELETE /api/mocks/hits, which resets them.
+func (s *Server) handleMockHits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.Mocks.Hits())
+	case http.MethodDelete:
+		if err := s.Mocks.ResetHits(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleMockScenarios(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.Mocks.Scenarios())
+}
+
+// handleMockScenario serves POST /api/mocks/scenarios/reset, which resets
+// every scenario, and POST /api/mocks/scenarios/<name>/reset or /set.
+func (s *Server) handleMockScenario(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/mocks/scenarios/")
+	if path == "reset" {
+		if err := s.Mocks.ResetScenarios(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	name, action, _ := strings.Cut(path, "/")
+	var err error
+	switch action {
+	case "reset":
+		err = s.Mocks.ResetScenarios(name)
+	case "set":
+		var input struct {
+			State string `json:"state"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.State == "" {
+			http.Error(w, "body must be {\"state\": \"...\"}", http.StatusBadRequest)
+			return
+		}
+		err = s.Mocks.SetScenario(name, input.State)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -294,6 +467,9 @@ func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	if len(captures) == 0 {
 		captures = s.Store.All()
 	}
+	for i, c := range captures {
+		captures[i] = s.Store.WithBodies(c)
+	}
 
 	switch format {
 	case "openapi":
@@ -595,6 +771,22 @@ func (s *Server) handleSessionByName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodGet && action == "" {
+		e, ok := sessions[name]
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"name":      name,
+			"start":     e.Start,
+			"end":       e.End,
+			"active":    e.End.IsZero(),
+			"waterfall": sess.NewWaterfall(sess.Captures(s.Store.AllFromDisk(), e)),
+		})
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return